@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+)
+
+// detectionDecoder 将模型的原始输出解析为候选检测框。不同版本的 YOLO 导出的
+// 输出格式不同，每种格式对应一个实现。
+type detectionDecoder interface {
+	// String 返回解码器的名称
+	String() string
+	// numClasses 返回每个候选框有 numAttributes 个属性（不含掩码系数和
+	// 关键点）时的类别数量
+	numClasses(numAttributes int) int
+	// decode 将 output 中置信度不低于 threshold 的候选框追加到 dst 并返回。
+	// 返回的坐标位于网络输入的坐标系中，label 尚未设置。
+	decode(output []float32, info *modelInfo, threshold float32,
+		dst []boundingBox) []boundingBox
+}
+
+// parseDecoderName 返回名称对应的解码器。"auto" 返回 nil，表示需要根据输出
+// 形状自动选择。
+func parseDecoderName(name string) (detectionDecoder, error) {
+	switch name {
+	case "auto", "":
+		return nil, nil
+	case "v8", "v11":
+		return yoloV8Decoder{}, nil
+	case "v5", "v7":
+		return yoloV5Decoder{}, nil
+	case "obb":
+		return yoloOBBDecoder{}, nil
+	}
+	return nil, fmt.Errorf("Unknown decoder: %s", name)
+}
+
+// detectDecoder 根据候选框数量推断输出格式。YOLOv5 和 YOLOv7 在每个格子上有
+// 3 个预设的 anchor，因此候选框数量是 YOLOv8 的 3 倍（640 输入时为 25200）。
+func detectDecoder(numAnchors, inputWidth, inputHeight int) detectionDecoder {
+	if numAnchors == 3*numAnchorsForInput(inputWidth, inputHeight) {
+		return yoloV5Decoder{}
+	}
+	return yoloV8Decoder{}
+}
+
+// appendCandidate 读取第 anchor 个候选框的中心点格式坐标，转换为左上角、
+// 右下角格式后追加到 dst。分割模型的掩码系数和姿态估计模型的关键点也会
+// 一并复制。
+func appendCandidate(dst []boundingBox, output []float32, info *modelInfo,
+	anchor, classID int, confidence float32) []boundingBox {
+	xc := info.attribute(output, anchor, 0)
+	yc := info.attribute(output, anchor, 1)
+	w := info.attribute(output, anchor, 2)
+	h := info.attribute(output, anchor, 3)
+	b := boundingBox{
+		classID:    classID,
+		confidence: confidence,
+		x1:         xc - w/2,
+		y1:         yc - h/2,
+		x2:         xc + w/2,
+		y2:         yc + h/2,
+	}
+	if info.numMasks > 0 {
+		b.maskCoeffs = make([]float32, info.numMasks)
+		offset := info.numAttributes - info.numMasks
+		for i := range b.maskCoeffs {
+			b.maskCoeffs[i] = info.attribute(output, anchor, offset+i)
+		}
+	}
+	if info.numKeypoints > 0 {
+		b.keypoints = make([]keypoint, info.numKeypoints)
+		offset := info.numAttributes - info.numMasks -
+			info.keypointAttributes()
+		for i := range b.keypoints {
+			k := offset + i*info.keypointDims
+			b.keypoints[i] = keypoint{
+				x:          info.attribute(output, anchor, k),
+				y:          info.attribute(output, anchor, k+1),
+				visibility: 1,
+			}
+			if info.keypointDims == 3 {
+				b.keypoints[i].visibility = info.attribute(output, anchor, k+2)
+			}
+		}
+	}
+	return append(dst, b)
+}
+
+// bestClass 返回第 anchor 个候选框中从第 offset 个属性开始的 numClasses 个
+// 类别分数中最高的一个
+func bestClass(output []float32, info *modelInfo, anchor, offset,
+	numClasses int) (int, float32) {
+	classID := 0
+	probability := float32(-1e9)
+	for col := 0; col < numClasses; col++ {
+		currentProb := info.attribute(output, anchor, col+offset)
+		if currentProb > probability {
+			probability = currentProb
+			classID = col
+		}
+	}
+	return classID, probability
+}
+
+// yoloV8Decoder 解析 YOLOv8 和 YOLO11 的 anchor-free 输出，每个候选框的属性
+// 为 (cx, cy, w, h, 各类别分数...)
+type yoloV8Decoder struct{}
+
+func (yoloV8Decoder) String() string {
+	return "YOLOv8/YOLO11"
+}
+
+func (yoloV8Decoder) numClasses(numAttributes int) int {
+	return numAttributes - 4
+}
+
+func (yoloV8Decoder) decode(output []float32, info *modelInfo,
+	threshold float32, dst []boundingBox) []boundingBox {
+	for idx := 0; idx < info.numAnchors; idx++ {
+		// 找到概率最高的类
+		classID, probability := bestClass(output, info, idx, 4,
+			info.numClasses)
+		if probability < threshold {
+			continue
+		}
+		dst = appendCandidate(dst, output, info, idx, classID, probability)
+	}
+	return dst
+}
+
+// yoloV5Decoder 解析 YOLOv5 和 YOLOv7 的输出，每个候选框的属性为
+// (cx, cy, w, h, objectness, 各类别分数...)，置信度为 objectness 与类别分数
+// 的乘积
+type yoloV5Decoder struct{}
+
+func (yoloV5Decoder) String() string {
+	return "YOLOv5/YOLOv7"
+}
+
+func (yoloV5Decoder) numClasses(numAttributes int) int {
+	return numAttributes - 5
+}
+
+func (yoloV5Decoder) decode(output []float32, info *modelInfo,
+	threshold float32, dst []boundingBox) []boundingBox {
+	for idx := 0; idx < info.numAnchors; idx++ {
+		// 先用 objectness 过滤，大部分候选框在这里就会被丢弃
+		objectness := info.attribute(output, idx, 4)
+		if objectness < threshold {
+			continue
+		}
+		classID, probability := bestClass(output, info, idx, 5,
+			info.numClasses)
+		confidence := objectness * probability
+		if confidence < threshold {
+			continue
+		}
+		dst = appendCandidate(dst, output, info, idx, classID, confidence)
+	}
+	return dst
+}
+
+// yoloOBBDecoder 解析 YOLOv8-obb 等旋转框检测模型的输出，每个候选框的属性
+// 为 (cx, cy, w, h, 各类别分数..., 角度)，角度的单位为弧度
+type yoloOBBDecoder struct{}
+
+func (yoloOBBDecoder) String() string {
+	return "YOLOv8-obb"
+}
+
+func (yoloOBBDecoder) numClasses(numAttributes int) int {
+	return numAttributes - 5
+}
+
+func (yoloOBBDecoder) decode(output []float32, info *modelInfo,
+	threshold float32, dst []boundingBox) []boundingBox {
+	angleIndex := 4 + info.numClasses
+	for idx := 0; idx < info.numAnchors; idx++ {
+		classID, probability := bestClass(output, info, idx, 4,
+			info.numClasses)
+		if probability < threshold {
+			continue
+		}
+		dst = appendCandidate(dst, output, info, idx, classID, probability)
+		b := &dst[len(dst)-1]
+		b.rotated = &rotatedBox{
+			cx:    info.attribute(output, idx, 0),
+			cy:    info.attribute(output, idx, 1),
+			w:     info.attribute(output, idx, 2),
+			h:     info.attribute(output, idx, 3),
+			angle: info.attribute(output, idx, angleIndex),
+		}
+		b.rotated.regularize()
+		b.x1, b.y1, b.x2, b.y2 = b.rotated.bounds()
+	}
+	return dst
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// classPalette 与 Ultralytics 绘制检测结果时使用的调色板相同
+var classPalette = []color.RGBA{
+	{0xff, 0x38, 0x38, 0xff}, {0xff, 0x9d, 0x97, 0xff},
+	{0xff, 0x70, 0x1f, 0xff}, {0xff, 0xb2, 0x1d, 0xff},
+	{0xcf, 0xd2, 0x31, 0xff}, {0x48, 0xf9, 0x0a, 0xff},
+	{0x92, 0xcc, 0x17, 0xff}, {0x3d, 0xdb, 0x86, 0xff},
+	{0x1a, 0x93, 0x34, 0xff}, {0x00, 0xd4, 0xbb, 0xff},
+	{0x2c, 0x99, 0xa8, 0xff}, {0x00, 0xc2, 0xff, 0xff},
+	{0x34, 0x45, 0x93, 0xff}, {0x64, 0x73, 0xff, 0xff},
+	{0x00, 0x18, 0xec, 0xff}, {0x84, 0x38, 0xff, 0xff},
+	{0x52, 0x00, 0x85, 0xff}, {0xcb, 0x38, 0xff, 0xff},
+	{0xff, 0x95, 0xc8, 0xff}, {0xff, 0x37, 0xc7, 0xff},
+}
+
+// classColor 返回某个类别在标注图像中使用的颜色
+func classColor(classID int) color.RGBA {
+	if classID < 0 {
+		classID = -classID
+	}
+	return classPalette[classID%len(classPalette)]
+}
+
+// boxColor 返回检测框在标注图像中使用的颜色。跟踪时按轨迹编号选择颜色，
+// 使同一类别的不同目标可以区分。
+func boxColor(b *boundingBox) color.RGBA {
+	if b.trackID != 0 {
+		return classColor(b.trackID)
+	}
+	return classColor(b.classID)
+}
+
+// fillRect 使用颜色 c 填充 dst 中的矩形 r
+func fillRect(dst draw.Image, r image.Rectangle, c color.Color) {
+	draw.Draw(dst, r.Intersect(dst.Bounds()), image.NewUniform(c),
+		image.Point{}, draw.Over)
+}
+
+// strokeRect 在 dst 上绘制宽度为 thickness 的矩形边框
+func strokeRect(dst draw.Image, r image.Rectangle, thickness int,
+	c color.Color) {
+	fillRect(dst, image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+thickness), c)
+	fillRect(dst, image.Rect(r.Min.X, r.Max.Y-thickness, r.Max.X, r.Max.Y), c)
+	fillRect(dst, image.Rect(r.Min.X, r.Min.Y, r.Min.X+thickness, r.Max.Y), c)
+	fillRect(dst, image.Rect(r.Max.X-thickness, r.Min.Y, r.Max.X, r.Max.Y), c)
+}
+
+// drawLabel 在 (x, y) 处绘制带背景色的文字，(x, y) 为背景框的左下角。如果
+// 上方空间不够，文字会被放到 y 的下方。
+func drawLabel(dst draw.Image, x, y int, text string, background color.Color) {
+	face := basicfont.Face7x13
+	metrics := face.Metrics()
+	textWidth := font.MeasureString(face, text).Ceil()
+	textHeight := metrics.Height.Ceil()
+	bounds := dst.Bounds()
+	if y-textHeight < bounds.Min.Y {
+		y += textHeight
+	}
+	if x+textWidth+2 > bounds.Max.X {
+		x = max(bounds.Max.X-textWidth-2, bounds.Min.X)
+	}
+	fillRect(dst, image.Rect(x, y-textHeight, x+textWidth+2, y), background)
+	drawer := &font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(color.White),
+		Face: face,
+		Dot: fixed.Point26_6{
+			X: fixed.I(x + 1),
+			Y: fixed.I(y) - metrics.Descent,
+		},
+	}
+	drawer.DrawString(text)
+}
+
+// maskOpacity 是标注图像中分割掩码的不透明度
+const maskOpacity = 0x80
+
+// drawMask 将 mask 覆盖的像素以 opacity 的不透明度用颜色 c 覆盖。mask 的
+// 坐标相对于原始图像的左上角，offset 为 dst 中与之对应的点。
+func drawMask(dst draw.Image, mask *image.Alpha, offset image.Point,
+	c color.RGBA, opacity uint8) {
+	src := image.NewUniform(color.NRGBA{c.R, c.G, c.B, opacity})
+	r := mask.Bounds().Add(offset)
+	draw.DrawMask(dst, r, src, image.Point{}, mask, mask.Bounds().Min,
+		draw.Over)
+}
+
+// drawMaskOverlay 返回一张与 bounds 大小相同的透明图像，其中只包含用类别
+// 颜色绘制的分割掩码，可以叠加在原始图像上查看
+func drawMaskOverlay(bounds image.Rectangle, boxes []boundingBox) *image.NRGBA {
+	dst := image.NewNRGBA(bounds)
+	for i := range boxes {
+		if boxes[i].mask == nil {
+			continue
+		}
+		drawMask(dst, boxes[i].mask, bounds.Min, classColor(boxes[i].classID),
+			maskOpacity)
+	}
+	return dst
+}
+
+// posePalette 与 Ultralytics 绘制人体姿态时使用的调色板相同
+var posePalette = []color.RGBA{
+	{255, 128, 0, 255}, {255, 153, 51, 255}, {255, 178, 102, 255},
+	{230, 230, 0, 255}, {255, 153, 255, 255}, {153, 204, 255, 255},
+	{255, 102, 255, 255}, {255, 51, 255, 255}, {102, 178, 255, 255},
+	{51, 153, 255, 255}, {255, 153, 153, 255}, {255, 102, 102, 255},
+	{255, 51, 51, 255}, {153, 255, 153, 255}, {102, 255, 102, 255},
+	{51, 255, 51, 255}, {0, 255, 0, 255}, {0, 0, 255, 255},
+	{255, 0, 0, 255}, {255, 255, 255, 255},
+}
+
+// cocoSkeleton 是 COCO 人体姿态的 17 个关键点之间的连线，编号从 0 开始
+var cocoSkeleton = [][2]int{
+	{15, 13}, {13, 11}, {16, 14}, {14, 12}, {11, 12}, {5, 11}, {6, 12},
+	{5, 6}, {5, 7}, {6, 8}, {7, 9}, {8, 10}, {1, 2}, {0, 1}, {0, 2},
+	{1, 3}, {2, 4}, {3, 5}, {4, 6},
+}
+
+// cocoLimbColors 和 cocoKeypointColors 是 cocoSkeleton 中每条连线和每个
+// 关键点在 posePalette 中的颜色编号
+var (
+	cocoLimbColors = []int{9, 9, 9, 9, 7, 7, 7, 0, 0, 0, 0, 0, 16, 16, 16,
+		16, 16, 16, 16}
+	cocoKeypointColors = []int{16, 16, 16, 16, 16, 0, 0, 0, 0, 0, 0, 9, 9,
+		9, 9, 9, 9}
+)
+
+// keypointVisibilityThreshold 是绘制关键点时可见度的最小值
+const keypointVisibilityThreshold = 0.5
+
+// fillCircle 在 dst 上绘制以 (cx, cy) 为圆心、半径为 radius 的实心圆
+func fillCircle(dst draw.Image, cx, cy, radius int, c color.Color) {
+	for dy := -radius; dy <= radius; dy++ {
+		for dx := -radius; dx <= radius; dx++ {
+			if dx*dx+dy*dy > radius*radius {
+				continue
+			}
+			p := image.Pt(cx+dx, cy+dy)
+			if p.In(dst.Bounds()) {
+				dst.Set(p.X, p.Y, c)
+			}
+		}
+	}
+}
+
+// drawLine 在 dst 上绘制从 (x0, y0) 到 (x1, y1)、宽度约为 thickness 的线段
+func drawLine(dst draw.Image, x0, y0, x1, y1, thickness int, c color.Color) {
+	radius := max(thickness/2, 0)
+	dx, dy := x1-x0, y1-y0
+	steps := max(dx, -dx, dy, -dy)
+	if steps == 0 {
+		fillCircle(dst, x0, y0, radius, c)
+		return
+	}
+	for i := 0; i <= steps; i++ {
+		x := x0 + (dx*i+steps/2)/steps
+		y := y0 + (dy*i+steps/2)/steps
+		fillCircle(dst, x, y, radius, c)
+	}
+}
+
+// drawRotatedBox 在 dst 上绘制旋转框 r 的四条边，offset 为 dst 中与原始
+// 图像左上角对应的点。返回最上方的顶点，用于放置标签。
+func drawRotatedBox(dst draw.Image, r *rotatedBox, offset image.Point,
+	thickness int, c color.Color) image.Point {
+	corners := r.corners()
+	var points [4]image.Point
+	top := 0
+	for i, corner := range corners {
+		points[i] = image.Pt(int(corner[0]), int(corner[1])).Add(offset)
+		if points[i].Y < points[top].Y {
+			top = i
+		}
+	}
+	for i, p := range points {
+		q := points[(i+1)%len(points)]
+		drawLine(dst, p.X, p.Y, q.X, q.Y, thickness, c)
+	}
+	return points[top]
+}
+
+// drawKeypoints 在 dst 上绘制 b 的关键点。COCO 人体姿态的 17 个关键点还会
+// 按骨架连线，其他关键点只绘制点，使用类别颜色。可见度低于
+// keypointVisibilityThreshold 的关键点不会被绘制。
+func drawKeypoints(dst draw.Image, b *boundingBox, offset image.Point,
+	thickness int) {
+	isCOCO := len(b.keypoints) == len(cocoKeypointColors)
+	visible := func(i int) (image.Point, bool) {
+		k := &b.keypoints[i]
+		p := image.Pt(int(k.x), int(k.y)).Add(offset)
+		return p, k.visibility >= keypointVisibilityThreshold
+	}
+	if isCOCO {
+		for i, limb := range cocoSkeleton {
+			p0, ok0 := visible(limb[0])
+			p1, ok1 := visible(limb[1])
+			if !ok0 || !ok1 {
+				continue
+			}
+			drawLine(dst, p0.X, p0.Y, p1.X, p1.Y, thickness,
+				posePalette[cocoLimbColors[i]])
+		}
+	}
+	radius := thickness + 2
+	for i := range b.keypoints {
+		p, ok := visible(i)
+		if !ok {
+			continue
+		}
+		c := classColor(b.classID)
+		if isCOCO {
+			c = posePalette[cocoKeypointColors[i]]
+		}
+		fillCircle(dst, p.X, p.Y, radius, c)
+	}
+}
+
+// drawDetections 返回 pic 的一个副本，其中每个检测框（以及旋转框、分割掩码
+// 和姿态关键点）都用类别对应的颜色绘制，并在框的上方标注类别和置信度。
+func drawDetections(pic image.Image, boxes []boundingBox) *image.RGBA {
+	bounds := pic.Bounds()
+	dst := image.NewRGBA(bounds)
+	draw.Draw(dst, bounds, pic, bounds.Min, draw.Src)
+	for i := range boxes {
+		if boxes[i].mask != nil {
+			drawMask(dst, boxes[i].mask, bounds.Min,
+				classColor(boxes[i].classID), maskOpacity)
+		}
+	}
+	// 边框宽度随图像大小变化，与 Ultralytics 的默认行为类似
+	thickness := max((bounds.Dx()+bounds.Dy())*3/2000, 2)
+	for i := range boxes {
+		b := &boxes[i]
+		c := boxColor(b)
+		r := b.toRect().Add(bounds.Min)
+		labelPosition := r.Min
+		if b.rotated != nil {
+			labelPosition = drawRotatedBox(dst, b.rotated, bounds.Min,
+				thickness, c)
+		} else {
+			strokeRect(dst, r, thickness, c)
+		}
+		label := fmt.Sprintf("%s %.2f", b.label, b.confidence)
+		if b.trackID != 0 {
+			label = fmt.Sprintf("#%d %s", b.trackID, label)
+		}
+		drawLabel(dst, labelPosition.X, labelPosition.Y, label, c)
+	}
+	for i := range boxes {
+		drawKeypoints(dst, &boxes[i], bounds.Min, thickness)
+	}
+	return dst
+}
+
+// zoneColor 是标注图像中区域和计数线段的颜色
+var zoneColor = color.RGBA{0xff, 0xff, 0xff, 0xff}
+
+// drawAnalytics 在 dst 上绘制 config 中的区域和线段，并标注 result 中区域
+// 内的目标数量和线段两个方向的累计越线次数
+func drawAnalytics(dst *image.RGBA, config *analyticsConfig,
+	result *jsonAnalytics) {
+	bounds := dst.Bounds()
+	thickness := max((bounds.Dx()+bounds.Dy())*3/2000, 2)
+	toPoint := func(p [2]float64) image.Point {
+		return image.Pt(int(p[0]), int(p[1])).Add(bounds.Min)
+	}
+	for i, zone := range config.Zones {
+		top := toPoint(zone.Polygon[0])
+		for j, p := range zone.Polygon {
+			a := toPoint(p)
+			b := toPoint(zone.Polygon[(j+1)%len(zone.Polygon)])
+			drawLine(dst, a.X, a.Y, b.X, b.Y, thickness, zoneColor)
+			if a.Y < top.Y {
+				top = a
+			}
+		}
+		drawLabel(dst, top.X, top.Y, fmt.Sprintf("%s: %d", zone.Name,
+			result.Zones[i].Count), zoneColor)
+	}
+	for i, line := range config.Lines {
+		a := toPoint(line.Points[0])
+		b := toPoint(line.Points[1])
+		drawLine(dst, a.X, a.Y, b.X, b.Y, thickness, zoneColor)
+		var total jsonDirectionCounts
+		for _, counts := range result.Lines[i].Totals {
+			total.LeftToRight += counts.LeftToRight
+			total.RightToLeft += counts.RightToLeft
+		}
+		drawLabel(dst, a.X, a.Y, fmt.Sprintf("%s L>R %d R>L %d", line.Name,
+			total.LeftToRight, total.RightToLeft), zoneColor)
+	}
+}
+
+// saveImage 根据 path 的扩展名将 pic 保存为 PNG 或 JPEG 图片
+func saveImage(pic image.Image, path string) error {
+	f, e := os.Create(path)
+	if e != nil {
+		return fmt.Errorf("Error creating %s: %w", path, e)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg":
+		e = jpeg.Encode(f, pic, &jpeg.Options{Quality: 90})
+	default:
+		e = png.Encode(f, pic)
+	}
+	if e != nil {
+		f.Close()
+		return fmt.Errorf("Error encoding image to %s: %w", path, e)
+	}
+	// 写入的数据可能在关闭时才报错，例如磁盘已满
+	e = f.Close()
+	if e != nil {
+		return fmt.Errorf("Error closing %s: %w", path, e)
+	}
+	return nil
+}
+
+// annotatedImagePath 返回输入图片 imagePath 对应的标注图像在 outputDir 中的
+// 路径，format 为 "png" 或 "jpeg"。
+func annotatedImagePath(outputDir, imagePath, format string) string {
+	return outputImagePath(outputDir, imagePath, "_annotated", format)
+}
+
+// outputImagePath 返回由 imagePath 生成的图像在 outputDir 中的路径，format
+// 为 "png" 或 "jpeg"。文件名为原始文件名中的 "." 替换为 "_" 后加上 suffix，
+// 保留原始的扩展名，使 a.jpg 和 a.png 的输出不会互相覆盖。
+func outputImagePath(outputDir, imagePath, suffix, format string) string {
+	base := strings.ReplaceAll(filepath.Base(imagePath), ".", "_")
+	extension := ".png"
+	if format == "jpeg" {
+		extension = ".jpg"
+	}
+	return filepath.Join(outputDir, base+suffix+extension)
+}
+
+// checkOutputCollisions 检查 imagePaths 中是否有两张不同的图片对应
+// outputPath 返回的同一个输出文件，例如不同目录中的同名图片
+func checkOutputCollisions(imagePaths []string,
+	outputPath func(imagePath string) string) error {
+	sources := make(map[string]string)
+	for _, imagePath := range imagePaths {
+		p := outputPath(imagePath)
+		previous, ok := sources[p]
+		if ok && (previous != imagePath) {
+			return fmt.Errorf("Both %s and %s would be saved to %s",
+				previous, imagePath, p)
+		}
+		sources[p] = imagePath
+	}
+	return nil
+}
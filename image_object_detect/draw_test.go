@@ -0,0 +1,41 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAnnotatedImagePath(t *testing.T) {
+	tests := []struct {
+		imagePath, format, expected string
+	}{
+		{"images/a.jpg", "png", "a_jpg_annotated.png"},
+		{"images/a.png", "png", "a_png_annotated.png"},
+		{"a.b.png", "jpeg", "a_b_png_annotated.jpg"},
+		{"noext", "png", "noext_annotated.png"},
+	}
+	for _, test := range tests {
+		expected := filepath.Join("out", test.expected)
+		actual := annotatedImagePath("out", test.imagePath, test.format)
+		if actual != expected {
+			t.Errorf("Expected the annotated image for %s to be %s, got %s",
+				test.imagePath, expected, actual)
+		}
+	}
+}
+
+func TestCheckOutputCollisions(t *testing.T) {
+	outputPath := func(p string) string {
+		return annotatedImagePath("out", p, "png")
+	}
+	e := checkOutputCollisions([]string{"a.jpg", "a.png", "a.jpg"},
+		outputPath)
+	if e != nil {
+		t.Errorf("Unexpected error for distinct output files: %s", e)
+	}
+	e = checkOutputCollisions([]string{"x/a.jpg", "y/a.jpg"}, outputPath)
+	if (e == nil) || !strings.Contains(e.Error(), "x/a.jpg") {
+		t.Errorf("Expected an error naming both images, got %v", e)
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+)
+
+// cocoImage 是 COCO 标注文件中 images 数组的一项
+type cocoImage struct {
+	ID       int    `json:"id"`
+	FileName string `json:"file_name"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+}
+
+// cocoAnnotation 是 COCO 标注文件中 annotations 数组的一项，bbox 为
+// [x, y, w, h]
+type cocoAnnotation struct {
+	ID         int        `json:"id"`
+	ImageID    int        `json:"image_id"`
+	CategoryID int        `json:"category_id"`
+	BBox       [4]float32 `json:"bbox"`
+	IsCrowd    int        `json:"iscrowd"`
+}
+
+// cocoCategory 是 COCO 标注文件中 categories 数组的一项
+type cocoCategory struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// cocoDataset 保存 COCO instances_*.json 中评估所需的部分
+type cocoDataset struct {
+	Images      []cocoImage      `json:"images"`
+	Annotations []cocoAnnotation `json:"annotations"`
+	Categories  []cocoCategory   `json:"categories"`
+}
+
+// loadCOCODataset 读取并解析 COCO 格式的标注文件
+func loadCOCODataset(path string) (*cocoDataset, error) {
+	f, e := os.Open(path)
+	if e != nil {
+		return nil, fmt.Errorf("Error opening %s: %w", path, e)
+	}
+	defer f.Close()
+	var toReturn cocoDataset
+	e = json.NewDecoder(f).Decode(&toReturn)
+	if e != nil {
+		return nil, fmt.Errorf("Error parsing %s: %w", path, e)
+	}
+	return &toReturn, nil
+}
+
+// evalIOUThresholds 是 COCO 评估使用的 10 个 IoU 阈值 0.5:0.05:0.95
+var evalIOUThresholds = []float32{
+	0.5, 0.55, 0.6, 0.65, 0.7, 0.75, 0.8, 0.85, 0.9, 0.95,
+}
+
+// evalGroundTruth 是评估时使用的一个标注框
+type evalGroundTruth struct {
+	box   boundingBox
+	crowd bool // iscrowd 的标注不计入召回，与之匹配的检测结果被忽略
+}
+
+// evalKey 用于按图片和类别分组
+type evalKey struct {
+	imageID    int
+	categoryID int
+}
+
+// evalGroup 保存一张图片中一个类别的标注和检测结果
+type evalGroup struct {
+	groundTruth []evalGroundTruth
+	detections  []boundingBox
+}
+
+// evalMatch 记录一个检测结果在各个 IoU 阈值下的匹配情况
+type evalMatch struct {
+	score   float32
+	matched []bool // 每个 IoU 阈值下是否与非 crowd 标注匹配
+	ignored []bool // 每个 IoU 阈值下是否与 crowd 标注匹配而被忽略
+}
+
+// evaluator 累积检测结果和标注，并按 COCO 的方法计算 AP
+type evaluator struct {
+	groups map[evalKey]*evalGroup
+	// 每张图片每个类别最多参与评估的检测数量，与 COCO 的 maxDets 相同
+	maxDetections int
+}
+
+func newEvaluator(maxDetections int) *evaluator {
+	return &evaluator{
+		groups:        make(map[evalKey]*evalGroup),
+		maxDetections: maxDetections,
+	}
+}
+
+// group 返回 key 对应的 evalGroup，不存在时创建
+func (v *evaluator) group(key evalKey) *evalGroup {
+	g := v.groups[key]
+	if g == nil {
+		g = &evalGroup{}
+		v.groups[key] = g
+	}
+	return g
+}
+
+// addGroundTruth 添加一个标注，box 中只使用坐标
+func (v *evaluator) addGroundTruth(imageID, categoryID int, box boundingBox,
+	crowd bool) {
+	g := v.group(evalKey{imageID, categoryID})
+	g.groundTruth = append(g.groundTruth, evalGroundTruth{
+		box:   box,
+		crowd: crowd,
+	})
+}
+
+// addDetection 添加一个检测结果
+func (v *evaluator) addDetection(imageID, categoryID int, box boundingBox) {
+	g := v.group(evalKey{imageID, categoryID})
+	g.detections = append(g.detections, box)
+}
+
+// crowdIOU 计算检测框与 crowd 标注的重叠度。与 pycocotools 一样，使用检测框
+// 自身的面积而不是并集作为分母。
+func crowdIOU(detection, crowd *boundingBox) float32 {
+	area := detection.area()
+	if area <= 0 {
+		return 0
+	}
+	return detection.intersection(crowd) / area
+}
+
+// match 按 COCO 的贪心方法将 g 中的检测结果与标注匹配，返回每个检测结果的
+// 匹配情况以及非 crowd 标注的数量。
+func (g *evalGroup) match(maxDetections int) ([]evalMatch, int) {
+	detections := make([]boundingBox, len(g.detections))
+	copy(detections, g.detections)
+	sortByConfidence(detections)
+	if (maxDetections > 0) && (len(detections) > maxDetections) {
+		detections = detections[:maxDetections]
+	}
+	// 非 crowd 标注排在前面，这样检测结果会优先与它们匹配
+	groundTruth := make([]evalGroundTruth, len(g.groundTruth))
+	copy(groundTruth, g.groundTruth)
+	sort.SliceStable(groundTruth, func(i, j int) bool {
+		return !groundTruth[i].crowd && groundTruth[j].crowd
+	})
+	numPositives := 0
+	for i := range groundTruth {
+		if !groundTruth[i].crowd {
+			numPositives++
+		}
+	}
+
+	matches := make([]evalMatch, len(detections))
+	for i := range matches {
+		matches[i] = evalMatch{
+			score:   detections[i].confidence,
+			matched: make([]bool, len(evalIOUThresholds)),
+			ignored: make([]bool, len(evalIOUThresholds)),
+		}
+	}
+	for t, threshold := range evalIOUThresholds {
+		gtMatched := make([]bool, len(groundTruth))
+		for d := range detections {
+			best := -1
+			bestIOU := min(threshold, 1-1e-10)
+			for gi := range groundTruth {
+				gt := &groundTruth[gi]
+				// 非 crowd 标注只能匹配一次
+				if gtMatched[gi] && !gt.crowd {
+					continue
+				}
+				// 已经匹配到非 crowd 标注时，不再考虑 crowd 标注
+				if (best >= 0) && !groundTruth[best].crowd && gt.crowd {
+					break
+				}
+				var iou float32
+				if gt.crowd {
+					iou = crowdIOU(&detections[d], &gt.box)
+				} else {
+					iou = detections[d].iou(&gt.box)
+				}
+				if iou < bestIOU {
+					continue
+				}
+				bestIOU = iou
+				best = gi
+			}
+			if best < 0 {
+				continue
+			}
+			gtMatched[best] = true
+			if groundTruth[best].crowd {
+				matches[d].ignored[t] = true
+			} else {
+				matches[d].matched[t] = true
+			}
+		}
+	}
+	return matches, numPositives
+}
+
+// classMetrics 保存一个类别的评估结果
+type classMetrics struct {
+	categoryID int
+	// 非 crowd 标注的数量
+	instances int
+	// 每个 IoU 阈值下的 AP
+	ap []float64
+	// IoU 为 0.5 时，置信度不低于 prThreshold 的检测结果的精确率和召回率
+	precision, recall float64
+}
+
+// ap50 返回 IoU 阈值为 0.5 时的 AP
+func (m *classMetrics) ap50() float64 {
+	return m.ap[0]
+}
+
+// apMean 返回所有 IoU 阈值下 AP 的平均值，即 AP@0.5:0.95
+func (m *classMetrics) apMean() float64 {
+	var sum float64
+	for _, v := range m.ap {
+		sum += v
+	}
+	return sum / float64(len(m.ap))
+}
+
+// averagePrecision 使用 COCO 的 101 点插值计算 AP。matched 和 ignored 必须
+// 按置信度从高到低排列。
+func averagePrecision(matched, ignored []bool, numPositives int) float64 {
+	if numPositives == 0 {
+		return 0
+	}
+	var precision, recall []float64
+	tp, fp := 0, 0
+	for i := range matched {
+		if ignored[i] {
+			continue
+		}
+		if matched[i] {
+			tp++
+		} else {
+			fp++
+		}
+		precision = append(precision, float64(tp)/float64(tp+fp))
+		recall = append(recall, float64(tp)/float64(numPositives))
+	}
+	// 使精确率从右向左单调不减
+	for i := len(precision) - 1; i > 0; i-- {
+		if precision[i] > precision[i-1] {
+			precision[i-1] = precision[i]
+		}
+	}
+	var sum float64
+	for i := 0; i <= 100; i++ {
+		idx := sort.SearchFloat64s(recall, float64(i)/100)
+		if idx < len(precision) {
+			sum += precision[idx]
+		}
+	}
+	return sum / 101
+}
+
+// computeMetrics 计算每个类别的评估结果，没有标注的类别会被跳过。结果按
+// categoryID 排序。
+func (v *evaluator) computeMetrics(prThreshold float32) []classMetrics {
+	type categoryMatches struct {
+		matches      []evalMatch
+		numPositives int
+	}
+	byCategory := make(map[int]*categoryMatches)
+	for key, g := range v.groups {
+		c := byCategory[key.categoryID]
+		if c == nil {
+			c = &categoryMatches{}
+			byCategory[key.categoryID] = c
+		}
+		matches, numPositives := g.match(v.maxDetections)
+		c.matches = append(c.matches, matches...)
+		c.numPositives += numPositives
+	}
+
+	var toReturn []classMetrics
+	for categoryID, c := range byCategory {
+		if c.numPositives == 0 {
+			continue
+		}
+		sort.SliceStable(c.matches, func(i, j int) bool {
+			return c.matches[i].score > c.matches[j].score
+		})
+		m := classMetrics{
+			categoryID: categoryID,
+			instances:  c.numPositives,
+			ap:         make([]float64, len(evalIOUThresholds)),
+		}
+		matched := make([]bool, len(c.matches))
+		ignored := make([]bool, len(c.matches))
+		for t := range evalIOUThresholds {
+			for i := range c.matches {
+				matched[i] = c.matches[i].matched[t]
+				ignored[i] = c.matches[i].ignored[t]
+			}
+			m.ap[t] = averagePrecision(matched, ignored, c.numPositives)
+		}
+		// 在 IoU 0.5 下统计精确率和召回率
+		tp, fp := 0, 0
+		for i := range c.matches {
+			if c.matches[i].score < prThreshold {
+				break
+			}
+			if c.matches[i].ignored[0] {
+				continue
+			}
+			if c.matches[i].matched[0] {
+				tp++
+			} else {
+				fp++
+			}
+		}
+		if tp+fp > 0 {
+			m.precision = float64(tp) / float64(tp+fp)
+		}
+		m.recall = float64(tp) / float64(c.numPositives)
+		toReturn = append(toReturn, m)
+	}
+	sort.Slice(toReturn, func(i, j int) bool {
+		return toReturn[i].categoryID < toReturn[j].categoryID
+	})
+	return toReturn
+}
+
+// runEval 实现 eval 子命令：对 COCO 标注文件中的图片运行检测，并输出 mAP
+// 以及每个类别的 AP、精确率和召回率。
+func runEval(args []string) int {
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	// 与 Ultralytics 的 val 一样，评估时使用很低的置信度阈值
+	detectorSettings := registerDetectorFlags(fs, 0.001, 300)
+	var annotationsPath string
+	var imageDir string
+	var maxImages int
+	var prThreshold float64
+	fs.StringVar(&annotationsPath, "annotations", "",
+		"The path to a COCO instances_*.json annotation file.")
+	fs.StringVar(&imageDir, "image_dir", "",
+		"The directory containing the images listed in the annotation "+
+			"file. Images that aren't found are skipped.")
+	fs.IntVar(&maxImages, "max_images", 0,
+		"If positive, only evaluate this many images.")
+	fs.Float64Var(&prThreshold, "pr_confidence", 0.25,
+		"The confidence threshold used when reporting per-class precision "+
+			"and recall. AP is always computed over all detections.")
+	fs.Parse(args)
+	if (annotationsPath == "") || (imageDir == "") {
+		fmt.Println("You must specify -annotations and -image_dir. Run " +
+			"with -help for more information.")
+		return 1
+	}
+	if detectorSettings.zonesPath != "" {
+		fmt.Println("The eval subcommand doesn't support -zones.")
+		return 1
+	}
+	dataset, e := loadCOCODataset(annotationsPath)
+	if e != nil {
+		fmt.Printf("Error loading annotations: %s\n", e)
+		return 1
+	}
+	// 检测结果按类别名称对应到标注文件中的 category_id
+	categoryIDs := make(map[string]int)
+	categoryNames := make(map[int]string)
+	for _, c := range dataset.Categories {
+		categoryIDs[c.Name] = c.ID
+		categoryNames[c.ID] = c.Name
+	}
+
+	d, e := detectorSettings.newDetector()
+	if e != nil {
+		fmt.Printf("%s\n", e)
+		return 1
+	}
+	defer d.Destroy()
+
+	// COCO 的 maxDets 是按每张图片每个类别计算的，这里沿用 -max_detections
+	v := newEvaluator(detectorSettings.maxDetections)
+	evaluated := make(map[int]bool)
+	skipped := 0
+	for _, img := range dataset.Images {
+		if (maxImages > 0) && (len(evaluated) >= maxImages) {
+			break
+		}
+		imagePath := filepath.Join(imageDir, img.FileName)
+		if _, e := os.Stat(imagePath); e != nil {
+			skipped++
+			continue
+		}
+		pic, e := loadImageFile(imagePath)
+		if e != nil {
+			fmt.Printf("Error loading input image: %s\n", e)
+			return 1
+		}
+		boxes, _, e := d.detect(pic)
+		if e != nil {
+			fmt.Printf("Error running detection on %s: %s\n", imagePath, e)
+			return 1
+		}
+		for _, b := range boxes {
+			categoryID, ok := categoryIDs[b.label]
+			if !ok {
+				continue
+			}
+			v.addDetection(img.ID, categoryID, b)
+		}
+		evaluated[img.ID] = true
+		if len(evaluated)%100 == 0 {
+			fmt.Fprintf(os.Stderr, "Evaluated %d images\n", len(evaluated))
+		}
+	}
+	for _, a := range dataset.Annotations {
+		if !evaluated[a.ImageID] {
+			continue
+		}
+		v.addGroundTruth(a.ImageID, a.CategoryID, boundingBox{
+			x1: a.BBox[0],
+			y1: a.BBox[1],
+			x2: a.BBox[0] + a.BBox[2],
+			y2: a.BBox[1] + a.BBox[3],
+		}, a.IsCrowd != 0)
+	}
+
+	metrics := v.computeMetrics(float32(prThreshold))
+	fmt.Printf("Evaluated %d images (%d listed in %s were not found in %s)\n",
+		len(evaluated), skipped, annotationsPath, imageDir)
+	if len(metrics) == 0 {
+		fmt.Println("No ground-truth objects were found in the evaluated " +
+			"images.")
+		return 1
+	}
+	var map50, map5095 float64
+	for i := range metrics {
+		map50 += metrics[i].ap50()
+		map5095 += metrics[i].apMean()
+	}
+	map50 /= float64(len(metrics))
+	map5095 /= float64(len(metrics))
+	fmt.Printf("mAP@0.5: %.4f\n", map50)
+	fmt.Printf("mAP@0.5:0.95: %.4f\n\n", map5095)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Class\tInstances\tAP@0.5\tAP@0.5:0.95\tPrecision\t"+
+		"Recall\n")
+	for i := range metrics {
+		m := &metrics[i]
+		fmt.Fprintf(w, "%s\t%d\t%.4f\t%.4f\t%.4f\t%.4f\n",
+			categoryNames[m.categoryID], m.instances, m.ap50(), m.apMean(),
+			m.precision, m.recall)
+	}
+	w.Flush()
+	return 0
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAveragePrecisionPerfect(t *testing.T) {
+	ap := averagePrecision([]bool{true, true}, []bool{false, false}, 2)
+	if ap != 1 {
+		t.Errorf("Expected AP 1 for perfect detections, got %f", ap)
+	}
+}
+
+func TestAveragePrecisionInterpolation(t *testing.T) {
+	// 按置信度排序：TP, FP, TP，共 2 个标注。召回率 0.5 之前精确率为 1，之后
+	// 插值后的精确率为 2/3。
+	ap := averagePrecision([]bool{true, false, true},
+		[]bool{false, false, false}, 2)
+	expected := (51*1.0 + 50*(2.0/3.0)) / 101
+	if math.Abs(ap-expected) > 1e-9 {
+		t.Errorf("Expected AP %f, got %f", expected, ap)
+	}
+	// 漏检的标注会降低 AP
+	ap = averagePrecision([]bool{true}, []bool{false}, 2)
+	expected = 51.0 / 101
+	if math.Abs(ap-expected) > 1e-9 {
+		t.Errorf("Expected AP %f, got %f", expected, ap)
+	}
+}
+
+func TestEvaluatorMetrics(t *testing.T) {
+	v := newEvaluator(100)
+	gt := newTestBox(0, 1, 10, 10, 110, 110)
+	v.addGroundTruth(1, 1, gt, false)
+	// IoU 约为 0.68 的检测结果只在较低的 IoU 阈值下算作 TP
+	v.addDetection(1, 1, newTestBox(0, 0.9, 20, 20, 120, 120))
+	// 重复的检测结果是 FP，但置信度较低，不影响 AP@0.5
+	v.addDetection(1, 1, newTestBox(0, 0.3, 10, 10, 110, 110))
+	// 没有标注的类别不参与评估
+	v.addDetection(1, 2, newTestBox(1, 0.9, 0, 0, 10, 10))
+
+	metrics := v.computeMetrics(0.25)
+	if len(metrics) != 1 {
+		t.Fatalf("Expected metrics for 1 class, got %d", len(metrics))
+	}
+	m := &metrics[0]
+	if m.instances != 1 {
+		t.Errorf("Expected 1 instance, got %d", m.instances)
+	}
+	if m.ap50() != 1 {
+		t.Errorf("Expected AP@0.5 of 1, got %f", m.ap50())
+	}
+	// IoU 阈值 0.5~0.65 时第一个检测结果匹配；0.7 及以上时第二个检测结果
+	// 匹配，但排在一个 FP 之后，AP 为 0.5 左右。
+	iou := gt.iou(&boundingBox{x1: 20, y1: 20, x2: 120, y2: 120})
+	if (iou < 0.65) || (iou > 0.7) {
+		t.Fatalf("Bad test setup: IoU is %f", iou)
+	}
+	secondAP := 51.0 / 101 * 0.5
+	secondAP += 50.0 / 101 * 0.5
+	expected := (4 + 6*secondAP) / 10
+	if math.Abs(m.apMean()-expected) > 1e-9 {
+		t.Errorf("Expected AP@0.5:0.95 of %f, got %f", expected, m.apMean())
+	}
+	if (m.precision != 0.5) || (m.recall != 1) {
+		t.Errorf("Expected precision 0.5 and recall 1, got %f and %f",
+			m.precision, m.recall)
+	}
+}
+
+func TestEvaluatorCrowdIgnored(t *testing.T) {
+	v := newEvaluator(100)
+	v.addGroundTruth(1, 1, newTestBox(0, 1, 0, 0, 100, 100), false)
+	v.addGroundTruth(1, 1, newTestBox(0, 1, 200, 200, 400, 400), true)
+	v.addDetection(1, 1, newTestBox(0, 0.9, 0, 0, 100, 100))
+	// 落在 crowd 区域内的检测结果既不是 TP 也不是 FP
+	v.addDetection(1, 1, newTestBox(0, 0.95, 250, 250, 300, 300))
+	metrics := v.computeMetrics(0.25)
+	if len(metrics) != 1 {
+		t.Fatalf("Expected metrics for 1 class, got %d", len(metrics))
+	}
+	if metrics[0].instances != 1 {
+		t.Errorf("Crowd annotations shouldn't count as instances")
+	}
+	if metrics[0].apMean() != 1 {
+		t.Errorf("Expected AP of 1, got %f", metrics[0].apMean())
+	}
+	if metrics[0].precision != 1 {
+		t.Errorf("Expected precision 1, got %f", metrics[0].precision)
+	}
+}
@@ -8,4 +8,5 @@ require (
 	github.com/8ff/prettyTimer v0.0.0-20230830184900-c96793faf613
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 	github.com/yalue/onnxruntime_go v1.13.0
+	golang.org/x/image v0.18.0
 )
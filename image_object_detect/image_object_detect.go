@@ -1,30 +1,388 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"image"
 	_ "image/gif"  // 支持 GIF 格式图片
 	_ "image/jpeg" // 支持 JPEG 格式图片
 	_ "image/png"  // 支持 PNG 格式图片
+	"io"
+	"math"
 	"os"
+	"path/filepath"
 	"runtime"
-	"sort"
+	"strings"
+	"time"
 
 	"github.com/8ff/prettyTimer"
 	"github.com/nfnt/resize"
 	ort "github.com/yalue/onnxruntime_go"
 )
 
-// 模型和图片路径配置
-var modelPath = "./yolov8n.onnx"                  // YOLOv8 模型文件路径
-var imagePath = "/Users/wxz/Downloads/person.png" // 待检测图片路径
-var useCoreML = false                             // 是否使用 CoreML 加速
-
 // ModelSession 结构体用于管理 ONNX 运行时会话
 type ModelSession struct {
 	Session *ort.AdvancedSession // ONNX 运行时会话
-	Input   *ort.Tensor[float32] // 输入张量
-	Output  *ort.Tensor[float32] // 输出张量
+	// 输入张量，形状为 (批大小, 3, H, W)
+	Input *ort.Tensor[float32]
+	// 输出张量，第一个为检测输出，分割模型还有第二个掩码原型输出
+	Outputs []*ort.Tensor[float32]
+	Info    *modelInfo // 从模型中读取的输入输出信息
+}
+
+// stringListFlag 实现 flag.Value 接口，允许同一个参数被多次指定
+type stringListFlag []string
+
+func (s *stringListFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringListFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// detectionTiming 记录一次检测中各阶段的耗时
+type detectionTiming struct {
+	preprocess  time.Duration // 图像缩放和张量填充
+	inference   time.Duration // Session.Run()
+	postprocess time.Duration // 解析输出和 NMS
+}
+
+// add 将 other 中的耗时累加到 t
+func (t *detectionTiming) add(other *detectionTiming) {
+	t.preprocess += other.preprocess
+	t.inference += other.inference
+	t.postprocess += other.postprocess
+}
+
+// divide 返回 t 中每项耗时除以 n 的结果，用于计算平均值
+func (t detectionTiming) divide(n int) detectionTiming {
+	return detectionTiming{
+		preprocess:  t.preprocess / time.Duration(n),
+		inference:   t.inference / time.Duration(n),
+		postprocess: t.postprocess / time.Duration(n),
+	}
+}
+
+// detector 将模型会话与预处理、后处理的参数组合在一起
+type detector struct {
+	session             *ModelSession
+	mode                resizeMode
+	filter              resizeFilter
+	confidenceThreshold float32
+	nms                 *nmsOptions
+	// 不为 nil 时使用切片推理
+	tiling *tilingConfig
+	// 不为 nil 时使用测试时增强
+	tta *ttaConfig
+}
+
+// detect 对 pic 运行一次完整的检测，返回检测结果和各阶段耗时
+func (d *detector) detect(pic image.Image) ([]boundingBox, detectionTiming,
+	error) {
+	boxes, timing, e := d.detectBatch([]image.Image{pic})
+	if e != nil {
+		return nil, timing, e
+	}
+	return boxes[0], timing, nil
+}
+
+// batchSize 返回一次 Session.Run() 最多能处理的图片数量
+func (d *detector) batchSize() int {
+	return int(d.session.Input.GetShape()[0])
+}
+
+// detectBatch 返回 pics 中每张图片的检测结果和整批的各阶段耗时。pics 的
+// 数量不能超过 batchSize()。使用切片推理或测试时增强时，每张图片的切片或
+// 增强版本分别组成批次。
+func (d *detector) detectBatch(pics []image.Image) ([][]boundingBox,
+	detectionTiming, error) {
+	detectOne := d.detectTiled
+	if d.tta != nil {
+		detectOne = d.detectTTA
+	} else if d.tiling == nil {
+		return d.runBatch(pics)
+	}
+	var timing detectionTiming
+	if (len(pics) == 0) || (len(pics) > d.batchSize()) {
+		return nil, timing, fmt.Errorf("Expected between 1 and %d images, "+
+			"got %d", d.batchSize(), len(pics))
+	}
+	toReturn := make([][]boundingBox, len(pics))
+	for i, pic := range pics {
+		boxes, picTiming, e := detectOne(pic)
+		if e != nil {
+			return nil, timing, e
+		}
+		timing.add(&picTiming)
+		toReturn[i] = boxes
+	}
+	return toReturn, timing, nil
+}
+
+// runBatch 将 pics 放入同一个输入张量，只运行一次模型，返回每张图片的
+// 检测结果和整批的各阶段耗时。pics 的数量不能超过 batchSize()；数量不足时
+// 剩余的位置仍会参与推理，但其输出会被忽略。
+func (d *detector) runBatch(pics []image.Image) ([][]boundingBox,
+	detectionTiming, error) {
+	var timing detectionTiming
+	if (len(pics) == 0) || (len(pics) > d.batchSize()) {
+		return nil, timing, fmt.Errorf("Expected between 1 and %d images, "+
+			"got %d", d.batchSize(), len(pics))
+	}
+	// 准备输入
+	start := time.Now()
+	transforms := make([]inputTransform, len(pics))
+	for i, pic := range pics {
+		var e error
+		transforms[i], e = prepareInput(pic, d.session.Input, i, d.mode,
+			d.filter)
+		if e != nil {
+			return nil, timing, fmt.Errorf("Error converting image %d to "+
+				"network input: %w", i, e)
+		}
+	}
+	timing.preprocess = time.Since(start)
+	// 运行模型
+	start = time.Now()
+	e := d.session.Session.Run()
+	if e != nil {
+		return nil, timing, fmt.Errorf("Error running ORT session: %w", e)
+	}
+	timing.inference = time.Since(start)
+	// 按图片拆分输出并分别处理
+	start = time.Now()
+	toReturn := make([][]boundingBox, len(pics))
+	for i, pic := range pics {
+		bounds := pic.Bounds().Canon()
+		toReturn[i] = processOutput(d.session.outputData(i), d.session.Info,
+			transforms[i], bounds.Dx(), bounds.Dy(), d.confidenceThreshold,
+			d.nms)
+	}
+	timing.postprocess = time.Since(start)
+	return toReturn, timing, nil
+}
+
+// detectorFlags 保存创建 detector 所需的命令行参数，供主程序和各个子命令
+// 共用
+type detectorFlags struct {
+	session             sessionConfig
+	confidenceThreshold float64
+	iouThreshold        float64
+	classAgnostic       bool
+	maxDetections       int
+	softNMSName         string
+	softNMSSigma        float64
+	resizeModeName      string
+	resizeFilterName    string
+	tileSize            int
+	tileOverlap         float64
+	tileFullImage       bool
+	useTTA              bool
+	ttaScales           string
+	ttaFlip             bool
+	ttaIoU              float64
+	zonesPath           string
+}
+
+// registerDetectorFlags 在 fs 中注册创建 detector 所需的参数。不同的子命令
+// 需要不同的默认置信度阈值和最大检测数量，因此由调用方指定。
+func registerDetectorFlags(fs *flag.FlagSet, defaultConfidence float64,
+	defaultMaxDetections int) *detectorFlags {
+	f := &detectorFlags{}
+	fs.StringVar(&f.session.onnxruntimeLibPath, "onnxruntime_lib",
+		getDefaultSharedLibPath(),
+		"The path to the onnxruntime shared library for your system.")
+	fs.StringVar(&f.session.modelPath, "model", "./yolov8n.onnx",
+		"The path to the YOLO .onnx network to load. Its input resolution, "+
+			"output layout, and class count are read from the file.")
+	fs.StringVar(&f.session.labelsPath, "labels", "",
+		"A text file containing one class name per line. By default, the "+
+			"names are read from the model's metadata, falling back to the "+
+			"COCO classes for 80-class models.")
+	fs.StringVar(&f.session.decoderName, "decoder", "auto",
+		"The model's output format: \"v8\" (also used by YOLO11), \"v5\" "+
+			"(also used by YOLOv7, with a separate objectness score), "+
+			"\"obb\" (YOLOv8-obb rotated boxes), or \"auto\" to infer it "+
+			"from the model's metadata and output shape.")
+	fs.StringVar(&f.session.keypointShape, "kpt_shape", "",
+		"The keypoint shape of a pose model, such as \"17,3\" for 17 "+
+			"keypoints with (x, y, visibility). By default, it is read "+
+			"from the model's metadata.")
+	fs.IntVar(&f.session.defaultInputSize, "input_size", 640,
+		"The input resolution to use if the model's input size is dynamic "+
+			"and isn't recorded in its metadata.")
+	fs.Float64Var(&f.confidenceThreshold, "confidence", defaultConfidence,
+		"Detections with a confidence below this value are discarded.")
+	fs.Float64Var(&f.iouThreshold, "iou", 0.7,
+		"The IoU threshold above which overlapping boxes are suppressed.")
+	fs.BoolVar(&f.classAgnostic, "class_agnostic", false,
+		"If set, overlapping boxes are suppressed regardless of their "+
+			"class. By default, only boxes of the same class suppress "+
+			"each other.")
+	fs.IntVar(&f.maxDetections, "max_detections", defaultMaxDetections,
+		"The maximum number of detections kept per image. 0 means no limit.")
+	fs.StringVar(&f.softNMSName, "soft_nms", "none",
+		"Use Soft-NMS instead of discarding overlapping boxes. Must be "+
+			"\"none\", \"linear\", or \"gaussian\".")
+	fs.Float64Var(&f.softNMSSigma, "soft_nms_sigma", 0.5,
+		"The sigma parameter used by gaussian Soft-NMS.")
+	fs.StringVar(&f.resizeModeName, "resize_mode", "letterbox",
+		"How images are resized to the network's input: \"letterbox\" "+
+			"keeps the aspect ratio and pads with gray, as Ultralytics "+
+			"does, while \"stretch\" scales each axis independently.")
+	fs.StringVar(&f.resizeFilterName, "resize_filter", "lanczos",
+		"The interpolation used to resize images: \"lanczos\" uses "+
+			"nfnt/resize's Lanczos3 filter, while \"bilinear\" is faster "+
+			"and matches OpenCV's INTER_LINEAR, which Ultralytics uses.")
+	fs.IntVar(&f.tileSize, "tile_size", 0,
+		"If positive, use sliced inference: cut each image into "+
+			"overlapping square tiles of this many pixels, detect objects "+
+			"in each tile at the network's resolution, and merge the "+
+			"results with NMS. This finds small objects in large images.")
+	fs.Float64Var(&f.tileOverlap, "tile_overlap", 0.2,
+		"The fraction of each tile's size that overlaps its neighbors.")
+	fs.BoolVar(&f.tileFullImage, "tile_full_image", true,
+		"When using sliced inference, also run detection on the whole "+
+			"resized image, to find objects larger than a tile.")
+	fs.BoolVar(&f.useTTA, "tta", false,
+		"If set, use test-time augmentation: run detection on several "+
+			"scaled and flipped versions of each image, and fuse the "+
+			"results with weighted box fusion. Only supports plain "+
+			"detection models.")
+	fs.StringVar(&f.ttaScales, "tta_scales", "0.83,1,1.2",
+		"A comma-separated list of the scales used by -tta.")
+	fs.BoolVar(&f.ttaFlip, "tta_flip", true,
+		"If set, -tta also runs detection on horizontally flipped images.")
+	fs.Float64Var(&f.ttaIoU, "tta_iou", 0.55,
+		"The IoU above which -tta fuses boxes of the same object.")
+	fs.StringVar(&f.zonesPath, "zones", "",
+		"A JSON file defining polygon zones and counting lines. If set, "+
+			"each image's output reports the objects in each zone. Line "+
+			"crossings are only counted by the stream subcommand with "+
+			"-track.")
+	fs.BoolVar(&f.session.useCoreML, "use_coreml",
+		os.Getenv("USE_COREML") == "true",
+		"If set, attempt to use the CoreML execution provider. Defaults to "+
+			"true if the USE_COREML environment variable is \"true\".")
+	return f
+}
+
+// loadZones 读取 -zones 指定的区域配置，没有指定时返回 nil。allowLines 为
+// false 时配置中不能有计数线段，因为只有连续的帧才能统计越线次数。
+func (f *detectorFlags) loadZones(allowLines bool) (*analyticsConfig,
+	error) {
+	if f.zonesPath == "" {
+		return nil, nil
+	}
+	config, e := loadAnalyticsConfig(f.zonesPath)
+	if e != nil {
+		return nil, e
+	}
+	if !allowLines && (len(config.Lines) != 0) {
+		return nil, fmt.Errorf("Counting line crossings requires the " +
+			"stream subcommand with -track")
+	}
+	return config, nil
+}
+
+// newDetector 检查参数并创建 detector。返回的 detector 在不再需要时必须
+// 调用 Destroy() 释放。
+func (f *detectorFlags) newDetector() (*detector, error) {
+	if f.session.onnxruntimeLibPath == "" {
+		return nil, fmt.Errorf("You must specify a path to the onnxruntime " +
+			"shared library on your system. Run with -help for more " +
+			"information.")
+	}
+	mode, e := parseResizeMode(f.resizeModeName)
+	if e != nil {
+		return nil, e
+	}
+	filter, e := parseResizeFilter(f.resizeFilterName)
+	if e != nil {
+		return nil, e
+	}
+	softMethod, e := parseSoftNMSMethod(f.softNMSName)
+	if e != nil {
+		return nil, e
+	}
+	if (softMethod == softNMSGaussian) && (f.softNMSSigma <= 0) {
+		return nil, fmt.Errorf("The Soft-NMS sigma must be positive")
+	}
+	var tiling *tilingConfig
+	if f.tileSize > 0 {
+		tiling = &tilingConfig{
+			size:      f.tileSize,
+			overlap:   f.tileOverlap,
+			fullImage: f.tileFullImage,
+		}
+		e = tiling.validate()
+		if e != nil {
+			return nil, e
+		}
+	}
+	var tta *ttaConfig
+	if f.useTTA {
+		if tiling != nil {
+			return nil, fmt.Errorf("Test-time augmentation can't be used " +
+				"with sliced inference")
+		}
+		scales, e := parseTTAScales(f.ttaScales)
+		if e != nil {
+			return nil, e
+		}
+		tta = &ttaConfig{
+			scales:       scales,
+			flip:         f.ttaFlip,
+			iouThreshold: float32(f.ttaIoU),
+		}
+		e = tta.validate()
+		if e != nil {
+			return nil, e
+		}
+	}
+	e = initEnvironment(f.session.onnxruntimeLibPath)
+	if e != nil {
+		return nil, e
+	}
+	info, e := inspectModel(&f.session)
+	if e != nil {
+		return nil, e
+	}
+	if tta != nil {
+		// 融合时只合并检测框的坐标
+		_, isOBB := info.decoder.(yoloOBBDecoder)
+		if (info.numMasks != 0) || (info.numKeypoints != 0) || isOBB {
+			return nil, fmt.Errorf("Test-time augmentation only supports " +
+				"detection models")
+		}
+	}
+	modelSession, e := initSession(&f.session, info)
+	if e != nil {
+		return nil, fmt.Errorf("Error creating session and tensors: %w", e)
+	}
+	return &detector{
+		session:             modelSession,
+		mode:                mode,
+		filter:              filter,
+		confidenceThreshold: float32(f.confidenceThreshold),
+		nms: &nmsOptions{
+			iouThreshold:   float32(f.iouThreshold),
+			classAgnostic:  f.classAgnostic,
+			maxDetections:  f.maxDetections,
+			softMethod:     softMethod,
+			sigma:          float32(f.softNMSSigma),
+			scoreThreshold: float32(f.confidenceThreshold),
+		},
+		tiling: tiling,
+		tta:    tta,
+	}, nil
+}
+
+// Destroy 释放 detector 使用的会话和张量
+func (d *detector) Destroy() {
+	d.session.Destroy()
 }
 
 func main() {
@@ -32,59 +390,352 @@ func main() {
 }
 
 func run() int {
+	// 子命令使用各自的参数
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "eval":
+			return runEval(os.Args[2:])
+		case "serve":
+			return runServe(os.Args[2:])
+		case "stream":
+			return runStream(os.Args[2:])
+		}
+	}
 
-	// 计时器
-	timingStats := prettyTimer.NewTimingStats()
-
-	if os.Getenv("USE_COREML") == "true" {
-		useCoreML = true
+	var imagePatterns stringListFlag
+	var iterations int
+	var outputFormat string
+	var annotatedDir string
+	var annotatedFormat string
+	var maskDir string
+	var usePipeline bool
+	var pipelineSettings pipelineConfig
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] "+
+			"[images...]\n       %s eval [flags]\n       %s serve [flags]"+
+			"\n       %s stream [flags]\n\nFlags:\n", os.Args[0],
+			os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	detectorSettings := registerDetectorFlags(flag.CommandLine, 0.5, 300)
+	flag.Var(&imagePatterns, "image_path",
+		"An input image, a directory of images, or a glob pattern such as "+
+			"\"frames/*.jpg\". May be specified more than once. Any "+
+			"remaining command-line arguments are treated the same way. "+
+			"Defaults to ./car.png.")
+	flag.IntVar(&iterations, "iterations", 5,
+		"The number of times to run detection on each image, for timing.")
+	flag.IntVar(&detectorSettings.session.batchSize, "batch_size", 1,
+		"The number of images packed into each (N, 3, H, W) input and "+
+			"processed by a single Session.Run(). Values above 1 require "+
+			"a model exported with a dynamic batch dimension.")
+	flag.BoolVar(&usePipeline, "pipeline", false,
+		"If set, decode and preprocess images, run the model, and "+
+			"postprocess its output concurrently, connected by bounded "+
+			"queues. Each image is processed once, and -iterations is "+
+			"ignored.")
+	flag.IntVar(&pipelineSettings.preprocessWorkers, "preprocess_workers",
+		runtime.NumCPU(),
+		"The number of goroutines decoding and preprocessing images in "+
+			"pipeline mode.")
+	flag.IntVar(&pipelineSettings.sessions, "sessions", 1,
+		"The number of ORT sessions running the model in pipeline mode.")
+	flag.IntVar(&pipelineSettings.postprocessWorkers, "postprocess_workers",
+		runtime.NumCPU(),
+		"The number of goroutines decoding the model's output and running "+
+			"NMS in pipeline mode.")
+	flag.IntVar(&pipelineSettings.queueSize, "queue_size", 16,
+		"The capacity of the queues between pipeline stages, which limits "+
+			"the number of images held in memory.")
+	flag.StringVar(&outputFormat, "output_format", "text",
+		"The format in which detections are printed. \"text\" is meant "+
+			"for people, \"json\" prints one JSON document per image, and "+
+			"\"coco\" prints a single COCO results array.")
+	flag.StringVar(&annotatedDir, "annotated_dir", "",
+		"If set, a copy of each input image with the detections drawn on "+
+			"it is written to this directory.")
+	flag.StringVar(&annotatedFormat, "annotated_format", "png",
+		"The format of the annotated images. Must be \"png\" or \"jpeg\".")
+	flag.StringVar(&maskDir, "mask_dir", "",
+		"If set, and the model is a segmentation model, a transparent PNG "+
+			"containing each image's colored masks is written to this "+
+			"directory.")
+	flag.Parse()
+	imagePatterns = append(imagePatterns, flag.Args()...)
+	if len(imagePatterns) == 0 {
+		imagePatterns = append(imagePatterns, "./car.png")
+	}
+	if iterations < 1 {
+		fmt.Println("The number of iterations must be at least 1.")
+		return 1
 	}
-	// 读取输入图像到 image.Image 对象
-	pic, e := loadImageFile(imagePath)
+	if detectorSettings.session.batchSize < 1 {
+		fmt.Println("The batch size must be at least 1.")
+		return 1
+	}
+	writer, e := newResultWriter(outputFormat, os.Stdout)
 	if e != nil {
-		fmt.Printf("Error loading input image: %s\n", e)
+		fmt.Printf("%s\n", e)
+		return 1
+	}
+	if (annotatedFormat != "png") && (annotatedFormat != "jpeg") {
+		fmt.Printf("Unsupported annotated image format: %s\n",
+			annotatedFormat)
 		return 1
 	}
-	// 获取图片的宽度和高度
-	originalWidth := pic.Bounds().Canon().Dx()
-	originalHeight := pic.Bounds().Canon().Dy()
+	for _, dir := range []string{annotatedDir, maskDir} {
+		if dir == "" {
+			continue
+		}
+		e = os.MkdirAll(dir, 0755)
+		if e != nil {
+			fmt.Printf("Error creating %s: %s\n", dir, e)
+			return 1
+		}
+	}
+	imagePaths, e := collectImagePaths(imagePatterns)
+	if e != nil {
+		fmt.Printf("Error finding input images: %s\n", e)
+		return 1
+	}
+	// 在开始检测之前发现会互相覆盖的输出文件
+	if annotatedDir != "" {
+		e = checkOutputCollisions(imagePaths, func(p string) string {
+			return annotatedImagePath(annotatedDir, p, annotatedFormat)
+		})
+		if e != nil {
+			fmt.Printf("%s\n", e)
+			return 1
+		}
+	}
+	if maskDir != "" {
+		e = checkOutputCollisions(imagePaths, func(p string) string {
+			return outputImagePath(maskDir, p, "_masks", "png")
+		})
+		if e != nil {
+			fmt.Printf("%s\n", e)
+			return 1
+		}
+	}
+	// 机器可读的格式只把检测结果写入 stdout，其他信息写入 stderr
+	statusOutput := io.Writer(os.Stdout)
+	if outputFormat != "text" {
+		statusOutput = os.Stderr
+	}
+
+	zones, e := detectorSettings.loadZones(false)
+	if e != nil {
+		fmt.Fprintf(statusOutput, "%s\n", e)
+		return 1
+	}
+
+	// 计时器
+	timingStats := prettyTimer.NewTimingStats()
 
 	// 初始化模型会话
-	modelSession, e := initSession()
+	d, e := detectorSettings.newDetector()
 	if e != nil {
-		fmt.Printf("Error creating session and tensors: %s\n", e)
+		fmt.Fprintf(statusOutput, "%s\n", e)
 		return 1
 	}
-	defer modelSession.Destroy()
+	defer d.Destroy()
+	fmt.Fprintf(statusOutput, "Loaded %s: %s\n",
+		detectorSettings.session.modelPath, d.session.Info)
 
-	// 运行检测 5 次
-	for i := 0; i < 5; i++ {
-		// 准备输入
-		e = prepareInput(pic, modelSession.Input)
+	imageIDs := cocoImageIDs(imagePaths)
+
+	// handleResult 输出一张图片的检测结果，并按需保存标注图像和掩码
+	var handleResult resultHandler = func(imageIndex int, imagePath string,
+		pic image.Image, boxes []boundingBox, timing detectionTiming) error {
+		bounds := pic.Bounds().Canon()
+		var analytics *jsonAnalytics
+		if zones != nil {
+			analytics = zones.imageAnalytics(boxes)
+		}
+		e := writer.writeResult(&imageResult{
+			path:      imagePath,
+			imageID:   imageIDs[imageIndex],
+			width:     bounds.Dx(),
+			height:    bounds.Dy(),
+			boxes:     boxes,
+			timing:    timing,
+			analytics: analytics,
+		})
 		if e != nil {
-			fmt.Printf("Error converting image to network input: %s\n", e)
-			return 1
+			return fmt.Errorf("Error writing results: %w", e)
 		}
+		if annotatedDir != "" {
+			outputPath := annotatedImagePath(annotatedDir, imagePath,
+				annotatedFormat)
+			annotated := drawDetections(pic, boxes)
+			if zones != nil {
+				drawAnalytics(annotated, zones, analytics)
+			}
+			e = saveImage(annotated, outputPath)
+			if e != nil {
+				return fmt.Errorf("Error saving annotated image: %w", e)
+			}
+			fmt.Fprintf(statusOutput, "Saved annotated image to %s\n",
+				outputPath)
+		}
+		if (maskDir != "") && (d.session.Info.numMasks > 0) {
+			outputPath := outputImagePath(maskDir, imagePath, "_masks", "png")
+			e = saveImage(drawMaskOverlay(pic.Bounds(), boxes), outputPath)
+			if e != nil {
+				return fmt.Errorf("Error saving mask overlay: %w", e)
+			}
+			fmt.Fprintf(statusOutput, "Saved mask overlay to %s\n",
+				outputPath)
+		}
+		return nil
+	}
 
-		timingStats.Start()
-		// 运行模型
-		e = modelSession.Session.Run()
+	// 端到端吞吐量包括读取图片和输出结果的时间
+	processingStart := time.Now()
+	processedImages := 0
+	if usePipeline {
+		pipeline, e := newDetectionPipeline(d, &detectorSettings.session,
+			pipelineSettings)
 		if e != nil {
-			fmt.Printf("Error running ORT session: %s\n", e)
+			fmt.Fprintf(statusOutput, "Error creating pipeline: %s\n", e)
 			return 1
 		}
-		timingStats.Finish()
-		// 处理输出
-		boxes := processOutput(modelSession.Output.GetData(), originalWidth,
-			originalHeight)
-		for i, box := range boxes {
-			fmt.Printf("Box %d: %s\n", i, &box)
+		defer pipeline.Destroy()
+		e = pipeline.run(imagePaths, func(item *pipelineItem) error {
+			timingStats.RecordTiming(item.timing.inference)
+			return handleResult(item.index, item.path, item.pic, item.boxes,
+				item.timing)
+		})
+		if e != nil {
+			fmt.Fprintf(statusOutput, "%s\n", e)
+			return 1
 		}
+		processedImages = len(imagePaths)
+	} else {
+		e = detectSerially(d, imagePaths, iterations, timingStats,
+			handleResult)
+		if e != nil {
+			fmt.Fprintf(statusOutput, "%s\n", e)
+			return 1
+		}
+		processedImages = len(imagePaths) * iterations
+	}
+	e = writer.finish()
+	if e != nil {
+		fmt.Fprintf(statusOutput, "Error writing results: %s\n", e)
+		return 1
+	}
+	elapsed := time.Since(processingStart)
+	if outputFormat == "text" {
+		timingStats.PrintStats()
+	} else {
+		printTimingStats(statusOutput, timingStats)
 	}
-	timingStats.PrintStats()
+	printThroughput(statusOutput, processedImages, elapsed)
 	return 0
 }
 
+// resultHandler 处理输入列表中第 imageIndex 张图片的检测结果
+type resultHandler func(imageIndex int, imagePath string, pic image.Image,
+	boxes []boundingBox, timing detectionTiming) error
+
+// detectSerially 在当前 goroutine 中按批依次处理 imagePaths 中的图片。每批
+// 重复检测 iterations 次以统计耗时，只把最后一次的结果交给 handle。
+func detectSerially(d *detector, imagePaths []string, iterations int,
+	timingStats *prettyTimer.TimingStats, handle resultHandler) error {
+	batchSize := d.batchSize()
+	for batchStart := 0; batchStart < len(imagePaths); batchStart += batchSize {
+		batchPaths := imagePaths[batchStart:min(batchStart+batchSize,
+			len(imagePaths))]
+		// 读取输入图像到 image.Image 对象
+		pics := make([]image.Image, len(batchPaths))
+		for i, imagePath := range batchPaths {
+			var e error
+			pics[i], e = loadImageFile(imagePath)
+			if e != nil {
+				return fmt.Errorf("Error loading input image: %w", e)
+			}
+		}
+
+		// 整批只运行一次模型，每张图片的耗时按平均分摊计算
+		var batchBoxes [][]boundingBox
+		var totalTiming detectionTiming
+		for i := 0; i < iterations; i++ {
+			var timing detectionTiming
+			var e error
+			batchBoxes, timing, e = d.detectBatch(pics)
+			if e != nil {
+				return fmt.Errorf("Error running detection on %s: %w",
+					strings.Join(batchPaths, ", "), e)
+			}
+			timingStats.RecordTiming(timing.inference /
+				time.Duration(len(pics)))
+			totalTiming.add(&timing)
+		}
+		for i, imagePath := range batchPaths {
+			e := handle(batchStart+i, imagePath, pics[i], batchBoxes[i],
+				totalTiming.divide(iterations*len(pics)))
+			if e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// isImageFile 根据扩展名判断文件是否为支持的图片格式
+func isImageFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png", ".jpg", ".jpeg", ".gif":
+		return true
+	}
+	return false
+}
+
+// collectImagePaths 将命令行给出的文件、目录和通配符展开为图片路径列表
+// 目录中的图片按文件名排序，通配符匹配的结果由 filepath.Glob 排序。
+func collectImagePaths(patterns []string) ([]string, error) {
+	var toReturn []string
+	for _, pattern := range patterns {
+		info, e := os.Stat(pattern)
+		if e == nil {
+			if !info.IsDir() {
+				toReturn = append(toReturn, pattern)
+				continue
+			}
+			entries, e := os.ReadDir(pattern)
+			if e != nil {
+				return nil, fmt.Errorf("Error reading directory %s: %w",
+					pattern, e)
+			}
+			for _, entry := range entries {
+				if entry.IsDir() || !isImageFile(entry.Name()) {
+					continue
+				}
+				toReturn = append(toReturn, filepath.Join(pattern,
+					entry.Name()))
+			}
+			continue
+		}
+		matches, e := filepath.Glob(pattern)
+		if e != nil {
+			return nil, fmt.Errorf("Invalid pattern %s: %w", pattern, e)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("No images found matching %s", pattern)
+		}
+		for _, match := range matches {
+			if isImageFile(match) {
+				toReturn = append(toReturn, match)
+			}
+		}
+	}
+	if len(toReturn) == 0 {
+		return nil, fmt.Errorf("No input images were found")
+	}
+	return toReturn, nil
+}
+
 // loadImageFile 加载并解码图片文件
 func loadImageFile(filePath string) (image.Image, error) {
 	// 打开图片文件
@@ -101,48 +752,149 @@ func loadImageFile(filePath string) (image.Image, error) {
 	return pic, nil
 }
 
-// prepareInput 将输入图像预处理并填充到 YOLOv8 输入张量中
-// 1. 将图像调整为 640x640 大小
+// resizeMode 决定如何把原始图像缩放到网络输入的尺寸
+type resizeMode int
+
+const (
+	// resizeLetterbox 保持宽高比缩放，并用灰色 (114) 填充剩余区域，与
+	// Ultralytics 的预处理一致
+	resizeLetterbox resizeMode = iota
+	// resizeStretch 将图像直接拉伸到网络输入的尺寸，不保持宽高比
+	resizeStretch
+)
+
+// parseResizeMode 将命令行中的字符串转换为 resizeMode
+func parseResizeMode(s string) (resizeMode, error) {
+	switch s {
+	case "letterbox":
+		return resizeLetterbox, nil
+	case "stretch":
+		return resizeStretch, nil
+	}
+	return 0, fmt.Errorf("Unknown resize mode: %s", s)
+}
+
+// letterboxGray 是 letterbox 填充区域使用的灰度值
+const letterboxGray = 114
+
+// inputTransform 记录预处理时对原始图像做的缩放和填充，
+// 用于把网络输出的坐标还原到原始图像上
+type inputTransform struct {
+	scaleX, scaleY float32 // 原始图像到网络输入的缩放比例
+	padX, padY     float32 // 网络输入中左侧和上方的填充像素数
+}
+
+// toOriginal 将网络输入坐标系中的点转换回原始图像坐标系
+func (t *inputTransform) toOriginal(x, y float32) (float32, float32) {
+	return (x - t.padX) / t.scaleX, (y - t.padY) / t.scaleY
+}
+
+// newInputTransform 根据原始图像尺寸、网络输入尺寸和缩放模式计算
+// inputTransform，同时返回图像缩放后（不含填充）的宽度和高度
+func newInputTransform(width, height, inputWidth, inputHeight int,
+	mode resizeMode) (inputTransform, int, int) {
+	if mode == resizeStretch {
+		return inputTransform{
+			scaleX: float32(inputWidth) / float32(width),
+			scaleY: float32(inputHeight) / float32(height),
+		}, inputWidth, inputHeight
+	}
+	// 与 Ultralytics 的 LetterBox 相同：使用较小的缩放比例，
+	// 然后把剩余部分平均分到两侧
+	scale := min(float32(inputWidth)/float32(width),
+		float32(inputHeight)/float32(height))
+	newWidth := int(math.Round(float64(float32(width) * scale)))
+	newHeight := int(math.Round(float64(float32(height) * scale)))
+	padX := math.Round(float64(inputWidth-newWidth)/2 - 0.1)
+	padY := math.Round(float64(inputHeight-newHeight)/2 - 0.1)
+	return inputTransform{
+		scaleX: scale,
+		scaleY: scale,
+		padX:   float32(padX),
+		padY:   float32(padY),
+	}, newWidth, newHeight
+}
+
+// prepareInput 将输入图像预处理并填充到 YOLO 输入张量中第 index 张图片的
+// 位置，输入尺寸由 dst 的形状 (N, 3, H, W) 决定
+// 1. 按 mode 将图像调整为输入大小（拉伸或 letterbox），使用 filter 插值
 // 2. 将像素值归一化到 [0,1] 范围
 // 3. 分离 RGB 通道并填充到对应的张量通道中
-func prepareInput(pic image.Image, dst *ort.Tensor[float32]) error {
+// 返回的 inputTransform 可用于把检测框还原到原始图像坐标。
+func prepareInput(pic image.Image, dst *ort.Tensor[float32], index int,
+	mode resizeMode, filter resizeFilter) (inputTransform, error) {
 	// 获取数据
 	data := dst.GetData()
+	shape := dst.GetShape()
+	if len(shape) != 4 {
+		return inputTransform{}, fmt.Errorf("Expected a 4-dimensional "+
+			"input tensor, got shape %s", shape)
+	}
+	if (index < 0) || (int64(index) >= shape[0]) {
+		return inputTransform{}, fmt.Errorf("Invalid batch index %d for "+
+			"input shape %s", index, shape)
+	}
+	inputHeight, inputWidth := int(shape[2]), int(shape[3])
 	// 计算通道大小
-	channelSize := 640 * 640
+	channelSize := inputWidth * inputHeight
 	// 检查数据是否足够
-	if len(data) < (channelSize * 3) {
-		return fmt.Errorf("Destination tensor only holds %d floats, needs "+
-			"%d (make sure it's the right shape!)", len(data), channelSize*3)
+	if len(data) < (channelSize * 3 * int(shape[0])) {
+		return inputTransform{}, fmt.Errorf("Destination tensor only holds "+
+			"%d floats, needs %d (make sure it's the right shape!)",
+			len(data), channelSize*3*int(shape[0]))
 	}
+	// 只使用第 index 张图片的部分
+	data = data[index*channelSize*3 : (index+1)*channelSize*3]
+	return fillInput(pic, data, inputWidth, inputHeight, mode, filter), nil
+}
+
+// fillInput 完成 prepareInput 的实际工作，将 pic 预处理后写入 data。data
+// 的长度必须为 3*inputWidth*inputHeight，按 R、G、B 三个通道依次排列。
+func fillInput(pic image.Image, data []float32, inputWidth, inputHeight int,
+	mode resizeMode, filter resizeFilter) inputTransform {
+	channelSize := inputWidth * inputHeight
 	redChannel := data[0:channelSize]
 	greenChannel := data[channelSize : channelSize*2]
 	blueChannel := data[channelSize*2 : channelSize*3]
 
-	// 使用 Lanczos3 算法将图像调整为 640x640
-	pic = resize.Resize(640, 640, pic, resize.Lanczos3)
-	// 遍历图像
-	i := 0
-	for y := 0; y < 640; y++ {
-		for x := 0; x < 640; x++ {
-			// 获取像素值
-			r, g, b, _ := pic.At(x, y).RGBA()
-			// 归一化像素值
-			redChannel[i] = float32(r>>8) / 255.0
-			// 归一化像素值
-			greenChannel[i] = float32(g>>8) / 255.0
-			// 归一化像素值
-			blueChannel[i] = float32(b>>8) / 255.0
-			// 增加索引
-			i++
+	bounds := pic.Bounds().Canon()
+	transform, newWidth, newHeight := newInputTransform(bounds.Dx(),
+		bounds.Dy(), inputWidth, inputHeight, mode)
+
+	// letterbox 模式下先用灰色填充整个输入
+	if (newWidth != inputWidth) || (newHeight != inputHeight) {
+		gray := float32(letterboxGray) / 255.0
+		for i := range data[:channelSize*3] {
+			data[i] = gray
 		}
 	}
+	offsetX := int(transform.padX)
+	offsetY := int(transform.padY)
 
-	return nil
+	// 尺寸已经相同的图像不需要缩放
+	if (newWidth != bounds.Dx()) || (newHeight != bounds.Dy()) {
+		if filter == filterBilinear {
+			resizeBilinear(pic, redChannel, greenChannel, blueChannel,
+				inputWidth, offsetX, offsetY, newWidth, newHeight)
+			return transform
+		}
+		// 使用 Lanczos3 算法调整图像大小
+		pic = resize.Resize(uint(newWidth), uint(newHeight), pic,
+			resize.Lanczos3)
+	}
+	// 逐行把像素归一化并写入对应的通道
+	read := newRowReader(pic)
+	for y := 0; y < newHeight; y++ {
+		i := (y+offsetY)*inputWidth + offsetX
+		read(y, redChannel[i:i+newWidth], greenChannel[i:i+newWidth],
+			blueChannel[i:i+newWidth])
+	}
+
+	return transform
 }
 
-// getSharedLibPath 根据操作系统和架构返回对应的 ONNX Runtime 动态库路径
-func getSharedLibPath() string {
+// getDefaultSharedLibPath 根据操作系统和架构返回对应的 ONNX Runtime 动态库路径
+func getDefaultSharedLibPath() string {
 	if runtime.GOOS == "windows" {
 		if runtime.GOARCH == "amd64" {
 			return "../third_party/onnxruntime.dll"
@@ -155,7 +907,6 @@ func getSharedLibPath() string {
 		if runtime.GOARCH == "amd64" {
 			return "../third_party/onnxruntime_amd64.dylib"
 		}
-
 	}
 	if runtime.GOOS == "linux" {
 		if runtime.GOARCH == "arm64" {
@@ -163,90 +914,181 @@ func getSharedLibPath() string {
 		}
 		return "../third_party/onnxruntime.so"
 	}
-	panic("Unable to find a version of the onnxruntime library supporting this system.")
+	fmt.Printf("Unable to determine a path to the onnxruntime shared library"+
+		" for OS \"%s\" and architecture \"%s\".\n", runtime.GOOS,
+		runtime.GOARCH)
+	return ""
 }
 
-// initSession 初始化 ONNX Runtime 会话
-// 1. 设置动态库路径
-// 2. 初始化运行环境
-// 3. 创建输入输出张量
-// 4. 配置会话选项（如 CoreML 加速）
-// 5. 创建会话
-func initSession() (*ModelSession, error) {
+// sessionConfig 保存创建 ModelSession 所需的参数
+type sessionConfig struct {
+	onnxruntimeLibPath string // onnxruntime 动态库路径
+	modelPath          string // .onnx 模型路径
+	labelsPath         string // 类别名称文件，为空时从模型元数据中读取
+	defaultInputSize   int    // 模型输入尺寸是动态的时使用的尺寸
+	decoderName        string // 输出格式，"auto" 表示根据输出形状推断
+	keypointShape      string // 关键点形状，为空时从模型元数据中读取
+	batchSize          int    // 每次推理的图片数量，0 与 1 相同
+	useCoreML          bool   // 是否使用 CoreML 加速
+}
+
+// initEnvironment 设置动态库路径并初始化 ONNX Runtime 运行环境。环境已经
+// 初始化时不做任何事情，因此可以在创建多个会话前多次调用。
+func initEnvironment(onnxruntimeLibPath string) error {
+	if ort.IsInitialized() {
+		return nil
+	}
 	// 设置动态库路径
-	ort.SetSharedLibraryPath(getSharedLibPath())
+	ort.SetSharedLibraryPath(onnxruntimeLibPath)
 	// 初始化运行环境
 	err := ort.InitializeEnvironment()
 	if err != nil {
-		return nil, fmt.Errorf("Error initializing ORT environment: %w", err)
+		return fmt.Errorf("Error initializing ORT environment: %w", err)
 	}
-	// 创建输入输出张量
-	inputShape := ort.NewShape(1, 3, 640, 640)
+	return nil
+}
+
+// initSession 初始化 ONNX Runtime 会话，调用前必须先调用 initEnvironment
+// 1. 按 info 中的形状创建输入输出张量
+// 2. 配置会话选项（如 CoreML 加速）
+// 3. 创建会话
+func initSession(cfg *sessionConfig, info *modelInfo) (*ModelSession,
+	error) {
+	// 创建输入输出张量，第一维为批大小
+	batchSize := int64(max(cfg.batchSize, 1))
+	inputShape := ort.NewShape(batchSize, 3, int64(info.inputHeight),
+		int64(info.inputWidth))
 	inputTensor, err := ort.NewEmptyTensor[float32](inputShape)
 	if err != nil {
 		return nil, fmt.Errorf("Error creating input tensor: %w", err)
 	}
 	// 创建输出张量
-	outputShape := ort.NewShape(1, 84, 8400)
-	outputTensor, err := ort.NewEmptyTensor[float32](outputShape)
-	if err != nil {
+	outputTensors := make([]*ort.Tensor[float32], 0, len(info.outputShapes))
+	destroyTensors := func() {
 		inputTensor.Destroy()
-		return nil, fmt.Errorf("Error creating output tensor: %w", err)
+		for _, t := range outputTensors {
+			t.Destroy()
+		}
+	}
+	for _, shape := range info.outputShapes {
+		shape = shape.Clone()
+		shape[0] = batchSize
+		outputTensor, err := ort.NewEmptyTensor[float32](shape)
+		if err != nil {
+			destroyTensors()
+			return nil, fmt.Errorf("Error creating output tensor: %w", err)
+		}
+		outputTensors = append(outputTensors, outputTensor)
 	}
 	// 创建会话选项
 	options, err := ort.NewSessionOptions()
 	if err != nil {
-		inputTensor.Destroy()
-		outputTensor.Destroy()
+		destroyTensors()
 		return nil, fmt.Errorf("Error creating ORT session options: %w", err)
 	}
 	defer options.Destroy()
 
 	// 如果启用了 CoreML，则附加 CoreML 执行提供者
-	if useCoreML {
+	if cfg.useCoreML {
 		err = options.AppendExecutionProviderCoreML(0)
 		if err != nil {
-			inputTensor.Destroy()
-			outputTensor.Destroy()
+			destroyTensors()
 			return nil, fmt.Errorf("Error enabling CoreML: %w", err)
 		}
 	}
 	// 创建会话
-	session, err := ort.NewAdvancedSession(modelPath,
-		[]string{"images"}, []string{"output0"},
-		[]ort.ArbitraryTensor{inputTensor},  // 输入张量
-		[]ort.ArbitraryTensor{outputTensor}, // 输出张量
-		options)                             // 会话选项
+	outputs := make([]ort.ArbitraryTensor, len(outputTensors))
+	for i, t := range outputTensors {
+		outputs[i] = t
+	}
+	session, err := ort.NewAdvancedSession(cfg.modelPath,
+		[]string{info.inputName}, info.outputNames,
+		[]ort.ArbitraryTensor{inputTensor}, // 输入张量
+		outputs,                            // 输出张量
+		options)                            // 会话选项
 	if err != nil {
-		inputTensor.Destroy()
-		outputTensor.Destroy()
+		destroyTensors()
 		return nil, fmt.Errorf("Error creating ORT session: %w", err)
 	}
 
 	return &ModelSession{
 		Session: session,
 		Input:   inputTensor,
-		Output:  outputTensor,
+		Outputs: outputTensors,
+		Info:    info,
 	}, nil
 }
 
 func (m *ModelSession) Destroy() {
 	m.Session.Destroy()
 	m.Input.Destroy()
-	m.Output.Destroy()
+	for _, t := range m.Outputs {
+		t.Destroy()
+	}
+}
+
+// outputData 返回每个输出张量中属于批中第 index 张图片的数据
+func (m *ModelSession) outputData(index int) [][]float32 {
+	toReturn := make([][]float32, len(m.Outputs))
+	for i, t := range m.Outputs {
+		data := t.GetData()
+		size := len(data) / int(t.GetShape()[0])
+		toReturn[i] = data[index*size : (index+1)*size]
+	}
+	return toReturn
+}
+
+// clampFloat 将 v 限制在 [low, high] 范围内
+func clampFloat(v, low, high float32) float32 {
+	if v < low {
+		return low
+	}
+	if v > high {
+		return high
+	}
+	return v
 }
 
 // boundingBox 结构体表示检测到的目标边界框
 type boundingBox struct {
 	label      string  // 目标类别标签
+	classID    int     // 目标类别编号
 	confidence float32 // 检测置信度
 	x1, y1     float32 // 左上角坐标
 	x2, y2     float32 // 右下角坐标
+	// 分割模型输出的掩码系数，在 NMS 之后用于计算 mask
+	maskCoeffs []float32
+	// 分割模型的二值掩码，范围为检测框在原始图像中覆盖的像素，不是分割
+	// 模型时为 nil
+	mask *image.Alpha
+	// 姿态估计模型的关键点，不是姿态估计模型时为 nil
+	keypoints []keypoint
+	// 旋转框检测模型输出的有向边界框，此时 x1、y1、x2、y2 为包含它的最小
+	// 轴对齐矩形。不是旋转框检测模型时为 nil。
+	rotated *rotatedBox
+	// 跟踪器分配的轨迹编号，从 1 开始。没有跟踪时为 0。
+	trackID int
+}
+
+// keypoint 是姿态估计模型输出的一个关键点
+type keypoint struct {
+	x, y float32 // 关键点坐标
+	// 关键点可见的置信度，范围为 [0, 1]。模型不输出可见度时为 1。
+	visibility float32
 }
 
 func (b *boundingBox) String() string {
-	return fmt.Sprintf("Object %s (confidence %f): (%f, %f), (%f, %f)",
+	toReturn := fmt.Sprintf("Object %s (confidence %f): (%f, %f), (%f, %f)",
 		b.label, b.confidence, b.x1, b.y1, b.x2, b.y2)
+	if b.trackID != 0 {
+		toReturn = fmt.Sprintf("Track %d: %s", b.trackID, toReturn)
+	}
+	if r := b.rotated; r != nil {
+		toReturn += fmt.Sprintf(", rotated: center (%f, %f), size %fx%f, "+
+			"angle %.2f degrees", r.cx, r.cy, r.w, r.h,
+			float64(r.angle)*180/math.Pi)
+	}
+	return toReturn
 }
 
 // 这会丢失精度，但请记住，boundingBox 已经缩放到原始图像的维度。因此，它只会失去边缘附近的分数像素。
@@ -254,110 +1096,83 @@ func (b *boundingBox) toRect() image.Rectangle {
 	return image.Rect(int(b.x1), int(b.y1), int(b.x2), int(b.y2)).Canon()
 }
 
-// 返回 b 的面积（以像素为单位），在转换为 image.Rectangle 之后。
-func (b *boundingBox) rectArea() int {
-	size := b.toRect().Size()
-	return size.X * size.Y
+// 返回 b 的面积（以像素为单位），不做取整。
+func (b *boundingBox) area() float32 {
+	return max(b.x2-b.x1, 0) * max(b.y2-b.y1, 0)
 }
 
 func (b *boundingBox) intersection(other *boundingBox) float32 {
-	r1 := b.toRect()
-	r2 := other.toRect()
-	intersected := r1.Intersect(r2).Canon().Size()
-	return float32(intersected.X * intersected.Y)
+	w := min(b.x2, other.x2) - max(b.x1, other.x1)
+	h := min(b.y2, other.y2) - max(b.y1, other.y1)
+	if (w <= 0) || (h <= 0) {
+		return 0
+	}
+	return w * h
 }
 
 func (b *boundingBox) union(other *boundingBox) float32 {
-	intersectArea := b.intersection(other)
-	totalArea := float32(b.rectArea() + other.rectArea())
-	return totalArea - intersectArea
+	return b.area() + other.area() - b.intersection(other)
 }
 
-// 由于转换为 image.Image 库的积分矩形，这不会完全精确，但我们只使用它来估计哪些框重叠太多，所以一些不精确应该是可以的。
+// 使用浮点坐标计算交并比，两个框面积都为 0 时返回 0。
 func (b *boundingBox) iou(other *boundingBox) float32 {
-	return b.intersection(other) / b.union(other)
+	if (b.rotated != nil) && (other.rotated != nil) {
+		return rotatedIoU(b.rotated, other.rotated)
+	}
+	u := b.union(other)
+	if u <= 0 {
+		return 0
+	}
+	return b.intersection(other) / u
 }
 
 // processOutput 处理模型输出，生成目标检测结果
-// 1. 遍历所有可能的检测框（8400个）
-// 2. 对每个框计算80个类别的概率
-// 3. 筛选置信度大于0.5的检测框
-// 4. 将坐标转换回原始图像尺寸
-// 5. 使用非极大值抑制(NMS)去除重叠框
-func processOutput(output []float32, originalWidth,
-	originalHeight int) []boundingBox {
-	// 创建一个切片来保存所有检测框
-	boundingBoxes := make([]boundingBox, 0, 8400)
-
-	// 定义变量来保存当前类ID和概率
-	var classID int
-	// 定义变量来保存当前概率
-	var probability float32
-
-	// 遍历输出数组，考虑 8400 个索引
-	for idx := 0; idx < 8400; idx++ {
-		// 遍历 80 个类，找到概率最高的类
-		probability = -1e9
-		for col := 0; col < 80; col++ {
-			// 获取当前概率
-			currentProb := output[8400*(col+4)+idx]
-			// 如果当前概率大于当前概率，则更新当前概率和类ID
-			if currentProb > probability {
-				// 更新当前概率
-				probability = currentProb
-				// 更新当前类ID
-				classID = col
-			}
-		}
+// 1. 使用 info.decoder 解析 outputs[0] 中的所有候选框（info.numAnchors 个）
+// 2. 筛选置信度不低于 confidenceThreshold 的检测框
+// 3. 使用 transform 去除预处理的缩放和填充，将坐标转换回原始图像尺寸
+// 4. 按 nms 中的配置进行非极大值抑制(NMS)，去除重叠框
+// 5. 对于分割模型，使用 outputs[1] 中的掩码原型计算每个检测框的掩码
+// 返回的结果按置信度从高到低排序。
+func processOutput(outputs [][]float32, info *modelInfo,
+	transform inputTransform, originalWidth, originalHeight int,
+	confidenceThreshold float32, nms *nmsOptions) []boundingBox {
+	// 解析候选框，坐标位于网络输入的坐标系中
+	boundingBoxes := info.decoder.decode(outputs[0], info,
+		confidenceThreshold, make([]boundingBox, 0, 256))
 
-		// 如果概率小于 0.5，继续到下一个索引
-		if probability < 0.5 {
-			continue
+	for i := range boundingBoxes {
+		b := &boundingBoxes[i]
+		b.label = info.className(b.classID)
+		x1, y1 := transform.toOriginal(b.x1, b.y1)
+		x2, y2 := transform.toOriginal(b.x2, b.y2)
+		if b.rotated != nil {
+			// 旋转框本身不做裁剪，只裁剪包含它的轴对齐矩形
+			rotated := b.rotated.toOriginal(&transform)
+			b.rotated = &rotated
+			x1, y1, x2, y2 = rotated.bounds()
+		}
+		// 与 Ultralytics 一样，将坐标裁剪到原始图像范围内
+		b.x1 = clampFloat(x1, 0, float32(originalWidth))
+		b.y1 = clampFloat(y1, 0, float32(originalHeight))
+		b.x2 = clampFloat(x2, 0, float32(originalWidth))
+		b.y2 = clampFloat(y2, 0, float32(originalHeight))
+		for j := range b.keypoints {
+			k := &b.keypoints[j]
+			x, y := transform.toOriginal(k.x, k.y)
+			k.x = clampFloat(x, 0, float32(originalWidth))
+			k.y = clampFloat(y, 0, float32(originalHeight))
 		}
-
-		// 提取边界框的坐标和维度
-		xc, yc := output[idx], output[8400+idx]
-		w, h := output[2*8400+idx], output[3*8400+idx]
-		x1 := (xc - w/2) / 640 * float32(originalWidth)
-		y1 := (yc - h/2) / 640 * float32(originalHeight)
-		x2 := (xc + w/2) / 640 * float32(originalWidth)
-		y2 := (yc + h/2) / 640 * float32(originalHeight)
-
-		// 将边界框附加到结果
-		boundingBoxes = append(boundingBoxes, boundingBox{
-			label:      yoloClasses[classID],
-			confidence: probability,
-			x1:         x1,
-			y1:         y1,
-			x2:         x2,
-			y2:         y2,
-		})
 	}
 
-	// 按概率排序边界框
-	sort.Slice(boundingBoxes, func(i, j int) bool {
-		return boundingBoxes[i].confidence < boundingBoxes[j].confidence
-	})
-
-	// 定义一个切片来保存最终结果
-	mergedResults := make([]boundingBox, 0, len(boundingBoxes))
-
-	// 遍历排序的边界框，移除重叠
-	for _, candidateBox := range boundingBoxes {
-		overlapsExistingBox := false
-		for _, existingBox := range mergedResults {
-			if (&candidateBox).iou(&existingBox) > 0.7 {
-				overlapsExistingBox = true
-				break
-			}
-		}
-		if !overlapsExistingBox {
-			mergedResults = append(mergedResults, candidateBox)
+	boundingBoxes = nonMaxSuppression(boundingBoxes, nms)
+	// 掩码只需要为 NMS 之后保留下来的框计算
+	if info.numMasks > 0 {
+		for i := range boundingBoxes {
+			boundingBoxes[i].mask = computeMask(&boundingBoxes[i],
+				outputs[1], info, &transform)
 		}
 	}
-
-	// 这仍然会按置信度排序
-	return mergedResults
+	return boundingBoxes
 }
 
 // yoloClasses 定义 COCO 数据集的80个类别标签
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	ort "github.com/yalue/onnxruntime_go"
+)
+
+// modelInfo 保存启动时从 .onnx 文件中读取的输入输出信息，使不同分辨率和
+// 类别数量的模型无需修改代码即可运行
+type modelInfo struct {
+	inputName   string   // 输入张量名称
+	outputNames []string // 输出张量名称
+	// 网络输入的宽度和高度
+	inputWidth, inputHeight int
+	// 批大小为 1 时每个输出的形状，第一个为检测输出，例如 (1, 84, 8400)；
+	// 分割模型的第二个输出为掩码原型，例如 (1, 32, 160, 160)
+	outputShapes []ort.Shape
+	// 输出中候选框（anchor）的数量，例如 8400
+	numAnchors int
+	// 每个候选框的属性数量，例如 84 = 4 个坐标 + 80 个类别
+	numAttributes int
+	// 为 true 时输出布局为 [1, 候选框, 属性]，例如 YOLOv5 的 [1, N, 5+C]；
+	// 否则为 [1, 属性, 候选框]，例如 YOLOv8 的 [1, 4+C, N]
+	transposed bool
+	// 解析输出使用的解码器
+	decoder detectionDecoder
+	// 类别数量
+	numClasses int
+	// 每个类别的名称
+	classNames []string
+	// 分割模型中每个候选框的掩码系数数量，不是分割模型时为 0。掩码系数位于
+	// 每个候选框属性的末尾。
+	numMasks int
+	// 掩码原型的宽度和高度，例如 160x160
+	maskWidth, maskHeight int
+	// 姿态估计模型中每个候选框的关键点数量，例如 COCO 人体姿态的 17 个，
+	// 不是姿态估计模型时为 0。关键点位于类别分数之后。
+	numKeypoints int
+	// 每个关键点的属性数量，2 表示 (x, y)，3 表示 (x, y, 可见度)
+	keypointDims int
+}
+
+// keypointAttributes 返回每个候选框中关键点占用的属性数量
+func (m *modelInfo) keypointAttributes() int {
+	return m.numKeypoints * m.keypointDims
+}
+
+// attribute 返回第 anchor 个候选框的第 index 个属性
+func (m *modelInfo) attribute(output []float32, anchor, index int) float32 {
+	if m.transposed {
+		return output[anchor*m.numAttributes+index]
+	}
+	return output[index*m.numAnchors+anchor]
+}
+
+// className 返回类别名称，没有名称的类别使用编号
+func (m *modelInfo) className(classID int) string {
+	if (classID >= 0) && (classID < len(m.classNames)) {
+		return m.classNames[classID]
+	}
+	return fmt.Sprintf("class%d", classID)
+}
+
+// numAnchorsForInput 返回 YOLOv8 在给定输入尺寸下的候选框数量，即步长为 8、16
+// 和 32 的三个特征图的格子数之和
+func numAnchorsForInput(width, height int) int {
+	toReturn := 0
+	for _, stride := range []int{8, 16, 32} {
+		toReturn += (width / stride) * (height / stride)
+	}
+	return toReturn
+}
+
+// isTransposedOutput 返回形状为 (1, a, b) 的检测输出是否为
+// (1, 候选框数量, 属性数量) 的布局。候选框的数量是 expectedAnchors 的整数倍
+// （YOLOv5 每个格子有 3 个候选框），只有一维符合时按它判断；两维都符合或都
+// 不符合时（例如输入尺寸无法确定），认为候选框的数量总是大于属性数量。
+func isTransposedOutput(a, b, expectedAnchors int) bool {
+	isAnchors := func(n int) bool {
+		return (expectedAnchors > 0) && (n > 0) && (n%expectedAnchors == 0)
+	}
+	if isAnchors(a) != isAnchors(b) {
+		return isAnchors(a)
+	}
+	return a > b
+}
+
+// ultralyticsNamesPattern 匹配 Ultralytics 写入模型元数据的 names 字典中的
+// 一项，例如 0: 'person'
+var ultralyticsNamesPattern = regexp.MustCompile(
+	`(\d+)\s*:\s*(?:'((?:[^'\\]|\\.)*)'|"((?:[^"\\]|\\.)*)")`)
+
+// parseUltralyticsNames 解析 Ultralytics 导出的模型元数据中的 names 项，
+// 其内容是 Python 字典的字符串形式，例如 "{0: 'person', 1: 'bicycle'}"
+func parseUltralyticsNames(s string) ([]string, error) {
+	matches := ultralyticsNamesPattern.FindAllStringSubmatch(s, -1)
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("No class names found in %q", s)
+	}
+	names := make(map[int]string)
+	maxID := -1
+	for _, m := range matches {
+		id, e := strconv.Atoi(m[1])
+		if e != nil {
+			return nil, fmt.Errorf("Invalid class ID %s: %w", m[1], e)
+		}
+		name := m[2]
+		if name == "" {
+			name = m[3]
+		}
+		names[id] = strings.ReplaceAll(strings.ReplaceAll(name, `\'`, `'`),
+			`\"`, `"`)
+		maxID = max(maxID, id)
+	}
+	toReturn := make([]string, maxID+1)
+	for i := range toReturn {
+		name, ok := names[i]
+		if !ok {
+			name = fmt.Sprintf("class%d", i)
+		}
+		toReturn[i] = name
+	}
+	return toReturn, nil
+}
+
+// loadLabelsFile 读取每行一个类别名称的文本文件，忽略空行
+func loadLabelsFile(path string) ([]string, error) {
+	f, e := os.Open(path)
+	if e != nil {
+		return nil, fmt.Errorf("Error opening %s: %w", path, e)
+	}
+	defer f.Close()
+	var toReturn []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		toReturn = append(toReturn, line)
+	}
+	if e = scanner.Err(); e != nil {
+		return nil, fmt.Errorf("Error reading %s: %w", path, e)
+	}
+	return toReturn, nil
+}
+
+// imgszPattern 匹配 Ultralytics 元数据中 imgsz 项的两个数字，例如 [640, 640]。
+// kpt_shape 项（例如 [17, 3]）使用相同的格式。
+var imgszPattern = regexp.MustCompile(`(\d+)\D+(\d+)`)
+
+// modelMetadata 保存 Ultralytics 写入模型元数据的信息，对应的项不存在时为
+// 零值
+type modelMetadata struct {
+	names         []string // 类别名称
+	width, height int      // 输入尺寸
+	kptShape      string   // 姿态估计模型的关键点形状，例如 "[17, 3]"
+	task          string   // 模型的任务，例如 "detect"、"pose" 或 "obb"
+}
+
+// readModelMetadata 读取 Ultralytics 写入模型元数据的类别名称、输入尺寸、
+// 关键点形状和任务
+func readModelMetadata(modelPath string) (*modelMetadata, error) {
+	metadata, e := ort.GetModelMetadata(modelPath)
+	if e != nil {
+		return nil, fmt.Errorf("Error reading metadata: %w", e)
+	}
+	defer metadata.Destroy()
+	toReturn := &modelMetadata{}
+	value, ok, e := metadata.LookupCustomMetadataMap("names")
+	if e != nil {
+		return nil, fmt.Errorf("Error looking up names: %w", e)
+	}
+	if ok {
+		toReturn.names, e = parseUltralyticsNames(value)
+		if e != nil {
+			return nil, fmt.Errorf("Error parsing names: %w", e)
+		}
+	}
+	value, ok, e = metadata.LookupCustomMetadataMap("imgsz")
+	if e != nil {
+		return nil, fmt.Errorf("Error looking up imgsz: %w", e)
+	}
+	if ok {
+		m := imgszPattern.FindStringSubmatch(value)
+		if m != nil {
+			// imgsz 的顺序为 [高度, 宽度]
+			toReturn.height, _ = strconv.Atoi(m[1])
+			toReturn.width, _ = strconv.Atoi(m[2])
+		}
+	}
+	toReturn.kptShape, _, e = metadata.LookupCustomMetadataMap("kpt_shape")
+	if e != nil {
+		return nil, fmt.Errorf("Error looking up kpt_shape: %w", e)
+	}
+	toReturn.task, _, e = metadata.LookupCustomMetadataMap("task")
+	if e != nil {
+		return nil, fmt.Errorf("Error looking up task: %w", e)
+	}
+	return toReturn, nil
+}
+
+// parseKeypointShape 解析 "[17, 3]" 或 "17,3" 形式的关键点形状，返回关键点
+// 数量和每个关键点的维度。维度为 2 时只有坐标，为 3 时还包括可见度。
+func parseKeypointShape(s string) (int, int, error) {
+	m := imgszPattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, 0, fmt.Errorf("Invalid keypoint shape %q", s)
+	}
+	count, _ := strconv.Atoi(m[1])
+	dims, _ := strconv.Atoi(m[2])
+	if (count <= 0) || ((dims != 2) && (dims != 3)) {
+		return 0, 0, fmt.Errorf("Unsupported keypoint shape %q: expected "+
+			"[count, 2] or [count, 3]", s)
+	}
+	return count, dims, nil
+}
+
+// inspectModel 使用 ort.GetInputOutputInfo 读取模型的输入分辨率、输出布局、
+// 候选框数量和类别数量，选择解码器，并从标签文件或模型元数据中读取类别名称。
+// cfg.defaultInputSize 仅在模型输入尺寸是动态的且元数据中没有 imgsz 时使用。
+func inspectModel(cfg *sessionConfig) (*modelInfo, error) {
+	modelPath := cfg.modelPath
+	decoder, e := parseDecoderName(cfg.decoderName)
+	if e != nil {
+		return nil, e
+	}
+	inputs, outputs, e := ort.GetInputOutputInfo(modelPath)
+	if e != nil {
+		return nil, fmt.Errorf("Error getting input and output info for "+
+			"%s: %w", modelPath, e)
+	}
+	if len(inputs) != 1 {
+		return nil, fmt.Errorf("Expected 1 input to %s, got %d", modelPath,
+			len(inputs))
+	}
+	if len(outputs) < 1 {
+		return nil, fmt.Errorf("%s has no outputs", modelPath)
+	}
+	input := inputs[0]
+	if (len(input.Dimensions) != 4) || (input.Dimensions[1] != 3) {
+		return nil, fmt.Errorf("Expected an input of shape (N, 3, H, W), "+
+			"got %s", input.Dimensions)
+	}
+	if input.DataType != ort.TensorElementDataTypeFloat {
+		return nil, fmt.Errorf("Expected a float32 input, got %s",
+			input.DataType)
+	}
+	// 固定批大小的模型只能按导出时的批大小运行，批大小为 -1 或符号时表示
+	// 动态批大小
+	batchSize := int64(max(cfg.batchSize, 1))
+	if (input.Dimensions[0] > 0) && (input.Dimensions[0] != batchSize) {
+		return nil, fmt.Errorf("%s was exported with a fixed batch size of "+
+			"%d, but a batch size of %d was requested. Export the model "+
+			"with a dynamic batch dimension (e.g. dynamic=True) to use "+
+			"batches.", modelPath, input.Dimensions[0], batchSize)
+	}
+	metadata, e := readModelMetadata(modelPath)
+	if e != nil {
+		return nil, fmt.Errorf("Error reading metadata from %s: %w",
+			modelPath, e)
+	}
+	names := metadata.names
+
+	toReturn := &modelInfo{
+		inputName:   input.Name,
+		inputHeight: int(input.Dimensions[2]),
+		inputWidth:  int(input.Dimensions[3]),
+	}
+	// 动态的输入尺寸使用元数据中的 imgsz，没有时使用 defaultInputSize
+	if toReturn.inputHeight <= 0 {
+		toReturn.inputHeight = cfg.defaultInputSize
+		if metadata.height > 0 {
+			toReturn.inputHeight = metadata.height
+		}
+	}
+	if toReturn.inputWidth <= 0 {
+		toReturn.inputWidth = cfg.defaultInputSize
+		if metadata.width > 0 {
+			toReturn.inputWidth = metadata.width
+		}
+	}
+	output := outputs[0]
+	if len(output.Dimensions) != 3 {
+		return nil, fmt.Errorf("Expected the first output to have 3 "+
+			"dimensions, got %s", output.Dimensions)
+	}
+	a, b := int(output.Dimensions[1]), int(output.Dimensions[2])
+	// 动态的候选框数量由输入尺寸计算
+	expectedAnchors := numAnchorsForInput(toReturn.inputWidth,
+		toReturn.inputHeight)
+	if a <= 0 {
+		a = expectedAnchors
+	}
+	if b <= 0 {
+		b = expectedAnchors
+	}
+	toReturn.transposed = isTransposedOutput(a, b, expectedAnchors)
+	if toReturn.transposed {
+		toReturn.numAttributes, toReturn.numAnchors = b, a
+	} else {
+		toReturn.numAttributes, toReturn.numAnchors = a, b
+	}
+	toReturn.outputNames = []string{output.Name}
+	toReturn.outputShapes = []ort.Shape{ort.NewShape(1, int64(a), int64(b))}
+
+	// 分割模型的第二个输出是 (1, 掩码数量, 高度, 宽度) 的掩码原型，其大小为
+	// 输入的 1/4
+	if (len(outputs) >= 2) && (len(outputs[1].Dimensions) == 4) {
+		protos := outputs[1]
+		toReturn.numMasks = int(protos.Dimensions[1])
+		toReturn.maskHeight = int(protos.Dimensions[2])
+		toReturn.maskWidth = int(protos.Dimensions[3])
+		if toReturn.maskHeight <= 0 {
+			toReturn.maskHeight = toReturn.inputHeight / 4
+		}
+		if toReturn.maskWidth <= 0 {
+			toReturn.maskWidth = toReturn.inputWidth / 4
+		}
+		if toReturn.numMasks <= 0 {
+			return nil, fmt.Errorf("Unsupported mask prototype shape %s",
+				protos.Dimensions)
+		}
+		toReturn.outputNames = append(toReturn.outputNames, protos.Name)
+		toReturn.outputShapes = append(toReturn.outputShapes,
+			ort.NewShape(1, int64(toReturn.numMasks),
+				int64(toReturn.maskHeight), int64(toReturn.maskWidth)))
+	}
+
+	// 姿态估计模型的关键点位于类别分数之后，形状可以由参数指定，否则从元数据
+	// 中读取
+	kptShape := cfg.keypointShape
+	if kptShape == "" {
+		kptShape = metadata.kptShape
+	}
+	if kptShape != "" {
+		toReturn.numKeypoints, toReturn.keypointDims, e =
+			parseKeypointShape(kptShape)
+		if e != nil {
+			return nil, e
+		}
+	}
+
+	// 旋转框检测模型的输出形状与普通检测模型无法区分，只能通过元数据识别
+	if (decoder == nil) && (metadata.task == "obb") {
+		decoder = yoloOBBDecoder{}
+	}
+	if decoder == nil {
+		decoder = detectDecoder(toReturn.numAnchors, toReturn.inputWidth,
+			toReturn.inputHeight)
+	}
+	toReturn.decoder = decoder
+	toReturn.numClasses = decoder.numClasses(toReturn.numAttributes -
+		toReturn.numMasks - toReturn.keypointAttributes())
+	if toReturn.numClasses < 1 {
+		return nil, fmt.Errorf("Unsupported output shape %s",
+			output.Dimensions)
+	}
+
+	// 类别名称的优先级：标签文件、模型元数据、内置的 COCO 类别
+	if cfg.labelsPath != "" {
+		names, e = loadLabelsFile(cfg.labelsPath)
+		if e != nil {
+			return nil, fmt.Errorf("Error loading labels: %w", e)
+		}
+	}
+	if (names == nil) && (toReturn.numClasses == len(yoloClasses)) {
+		names = yoloClasses
+	}
+	if (names != nil) && (len(names) != toReturn.numClasses) {
+		return nil, fmt.Errorf("The model has %d classes, but %d class "+
+			"names were provided", toReturn.numClasses, len(names))
+	}
+	toReturn.classNames = names
+	return toReturn, nil
+}
+
+// String 返回 modelInfo 的简要描述
+func (m *modelInfo) String() string {
+	layout := "[1, attributes, anchors]"
+	if m.transposed {
+		layout = "[1, anchors, attributes]"
+	}
+	toReturn := fmt.Sprintf("input %q %dx%d, output %q %s (layout %s, %s), "+
+		"%d anchors, %d classes", m.inputName, m.inputWidth, m.inputHeight,
+		m.outputNames[0], m.outputShapes[0], layout, m.decoder, m.numAnchors,
+		m.numClasses)
+	if m.numMasks > 0 {
+		toReturn += fmt.Sprintf(", %d masks from %q %s", m.numMasks,
+			m.outputNames[1], m.outputShapes[1])
+	}
+	if m.numKeypoints > 0 {
+		toReturn += fmt.Sprintf(", %d keypoints", m.numKeypoints)
+	}
+	return toReturn
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseUltralyticsNames(t *testing.T) {
+	names, e := parseUltralyticsNames(`{0: 'person', 1: "driver's seat", ` +
+		`2: 'traffic light'}`)
+	if e != nil {
+		t.Fatalf("Error parsing names: %s", e)
+	}
+	expected := []string{"person", "driver's seat", "traffic light"}
+	if len(names) != len(expected) {
+		t.Fatalf("Expected %d names, got %d: %v", len(expected), len(names),
+			names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("Expected name %d to be %q, got %q", i, expected[i],
+				names[i])
+		}
+	}
+	_, e = parseUltralyticsNames("{}")
+	if e == nil {
+		t.Errorf("Expected an error for an empty dict")
+	}
+}
+
+func TestNumAnchorsForInput(t *testing.T) {
+	if n := numAnchorsForInput(640, 640); n != 8400 {
+		t.Errorf("Expected 8400 anchors for a 640x640 input, got %d", n)
+	}
+	if n := numAnchorsForInput(1280, 1280); n != 33600 {
+		t.Errorf("Expected 33600 anchors for a 1280x1280 input, got %d", n)
+	}
+}
+
+func TestIsTransposedOutput(t *testing.T) {
+	tests := []struct {
+		a, b, expectedAnchors int
+		transposed            bool
+	}{
+		{84, 8400, 8400, false},
+		{8400, 84, 8400, true},
+		// YOLOv5 每个格子有 3 个候选框
+		{25200, 85, 8400, true},
+		// 小输入尺寸时属性数量可能多于候选框数量
+		{116, 84, 84, false},
+		{84, 116, 84, true},
+		// 无法确定候选框数量时按大小判断
+		{84, 8400, 0, false},
+		{8400, 84, 0, true},
+	}
+	for _, test := range tests {
+		actual := isTransposedOutput(test.a, test.b, test.expectedAnchors)
+		if actual != test.transposed {
+			t.Errorf("Expected isTransposedOutput(%d, %d, %d) to be %v",
+				test.a, test.b, test.expectedAnchors, test.transposed)
+		}
+	}
+}
+
+// newTestOutput 返回只有第 anchor 个候选框非零的模型输出，按 info 中的布局
+// 排列
+func newTestOutput(info *modelInfo, anchor int,
+	attributes []float32) []float32 {
+	output := make([]float32, info.numAnchors*info.numAttributes)
+	for i, v := range attributes {
+		if info.transposed {
+			output[anchor*info.numAttributes+i] = v
+		} else {
+			output[i*info.numAnchors+anchor] = v
+		}
+	}
+	return output
+}
+
+func TestProcessOutputLayouts(t *testing.T) {
+	for _, transposed := range []bool{false, true} {
+		info := &modelInfo{
+			inputWidth:    320,
+			inputHeight:   320,
+			numAnchors:    numAnchorsForInput(320, 320),
+			numAttributes: 7,
+			numClasses:    3,
+			transposed:    transposed,
+			decoder:       yoloV8Decoder{},
+			classNames:    []string{"a", "b", "c"},
+		}
+		output := newTestOutput(info, 17, []float32{160, 160, 32, 64, 0.1,
+			0.2, 0.9})
+		transform, _, _ := newInputTransform(640, 640, 320, 320,
+			resizeLetterbox)
+		boxes := processOutput([][]float32{output}, info, transform, 640,
+			640, 0.5, &nmsOptions{iouThreshold: 0.7})
+		if len(boxes) != 1 {
+			t.Fatalf("Expected 1 box (transposed = %v), got %d", transposed,
+				len(boxes))
+		}
+		b := &boxes[0]
+		if (b.label != "c") || (b.classID != 2) || (b.confidence != 0.9) {
+			t.Errorf("Got incorrect box (transposed = %v): %s", transposed, b)
+		}
+		if (b.x1 != 288) || (b.y1 != 256) || (b.x2 != 352) || (b.y2 != 384) {
+			t.Errorf("Got incorrect coordinates (transposed = %v): %s",
+				transposed, b)
+		}
+	}
+}
+
+func TestYOLOv5Decoder(t *testing.T) {
+	numAnchors := 3 * numAnchorsForInput(320, 320)
+	decoder := detectDecoder(numAnchors, 320, 320)
+	if _, ok := decoder.(yoloV5Decoder); !ok {
+		t.Fatalf("Expected a YOLOv5 decoder for %d anchors, got %s",
+			numAnchors, decoder)
+	}
+	info := &modelInfo{
+		numAnchors:    numAnchors,
+		numAttributes: 7,
+		numClasses:    decoder.numClasses(7),
+		transposed:    true,
+		decoder:       decoder,
+	}
+	if info.numClasses != 2 {
+		t.Fatalf("Expected 2 classes, got %d", info.numClasses)
+	}
+	output := newTestOutput(info, 3, []float32{100, 100, 20, 20, 0.8, 0.1,
+		0.75})
+	// objectness 较高但与类别分数相乘后低于阈值的候选框应被丢弃
+	for i, v := range []float32{200, 200, 20, 20, 0.9, 0.5, 0.2} {
+		output[5*info.numAttributes+i] = v
+	}
+	boxes := decoder.decode(output, info, 0.5, nil)
+	if len(boxes) != 1 {
+		t.Fatalf("Expected 1 box, got %d: %v", len(boxes), boxes)
+	}
+	b := &boxes[0]
+	if (b.classID != 1) || (math.Abs(float64(b.confidence)-0.6) > 1e-6) {
+		t.Errorf("Expected class 1 with confidence 0.6, got %d, %f",
+			b.classID, b.confidence)
+	}
+	if (b.x1 != 90) || (b.y1 != 90) || (b.x2 != 110) || (b.y2 != 110) {
+		t.Errorf("Got incorrect coordinates: %s", b)
+	}
+	decoder = detectDecoder(numAnchorsForInput(320, 320), 320, 320)
+	if _, ok := decoder.(yoloV8Decoder); !ok {
+		t.Errorf("Expected a YOLOv8 decoder, got %s", decoder)
+	}
+}
+
+func TestParseKeypointShape(t *testing.T) {
+	count, dims, e := parseKeypointShape("[17, 3]")
+	if e != nil {
+		t.Fatalf("Error parsing keypoint shape: %s", e)
+	}
+	if (count != 17) || (dims != 3) {
+		t.Errorf("Expected 17 keypoints with 3 dims, got %d, %d", count, dims)
+	}
+	count, dims, e = parseKeypointShape("5,2")
+	if (e != nil) || (count != 5) || (dims != 2) {
+		t.Errorf("Expected 5 keypoints with 2 dims, got %d, %d (%v)", count,
+			dims, e)
+	}
+	for _, s := range []string{"", "17", "[17, 4]", "[0, 3]"} {
+		if _, _, e = parseKeypointShape(s); e == nil {
+			t.Errorf("Expected an error for keypoint shape %q", s)
+		}
+	}
+}
+
+func TestProcessOutputKeypoints(t *testing.T) {
+	info := &modelInfo{
+		inputWidth:    320,
+		inputHeight:   320,
+		numAnchors:    numAnchorsForInput(320, 320),
+		numAttributes: 11,
+		numKeypoints:  2,
+		keypointDims:  3,
+		decoder:       yoloV8Decoder{},
+	}
+	info.numClasses = info.decoder.numClasses(info.numAttributes -
+		info.keypointAttributes())
+	if info.numClasses != 1 {
+		t.Fatalf("Expected 1 class, got %d", info.numClasses)
+	}
+	output := newTestOutput(info, 5, []float32{160, 160, 32, 64, 0.8,
+		150, 140, 0.9, 0, 400, 0.2})
+	transform, _, _ := newInputTransform(640, 640, 320, 320, resizeLetterbox)
+	boxes := processOutput([][]float32{output}, info, transform, 640, 640,
+		0.5, &nmsOptions{iouThreshold: 0.7})
+	if len(boxes) != 1 {
+		t.Fatalf("Expected 1 box, got %d", len(boxes))
+	}
+	keypoints := boxes[0].keypoints
+	if len(keypoints) != 2 {
+		t.Fatalf("Expected 2 keypoints, got %d", len(keypoints))
+	}
+	// 坐标被映射回原始图像，超出图像的关键点被裁剪到边缘
+	expected := []keypoint{{300, 280, 0.9}, {0, 640, 0.2}}
+	for i := range expected {
+		if keypoints[i] != expected[i] {
+			t.Errorf("Expected keypoint %d to be %v, got %v", i, expected[i],
+				keypoints[i])
+		}
+	}
+	if n := numVisibleKeypoints(&boxes[0]); n != 1 {
+		t.Errorf("Expected 1 visible keypoint, got %d", n)
+	}
+	flat := cocoKeypoints(&boxes[0])
+	if (len(flat) != 6) || (flat[0] != 300) || (flat[5] != 0.2) {
+		t.Errorf("Got incorrect COCO keypoints: %v", flat)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// softNMSMethod 决定 Soft-NMS 如何衰减重叠框的置信度
+type softNMSMethod int
+
+const (
+	// softNMSNone 表示使用普通的贪心 NMS，直接丢弃重叠框
+	softNMSNone softNMSMethod = iota
+	// softNMSLinear 将重叠框的置信度乘以 (1 - IoU)
+	softNMSLinear
+	// softNMSGaussian 将重叠框的置信度乘以 exp(-IoU^2 / sigma)
+	softNMSGaussian
+)
+
+// parseSoftNMSMethod 将命令行中的字符串转换为 softNMSMethod
+func parseSoftNMSMethod(s string) (softNMSMethod, error) {
+	switch s {
+	case "none", "":
+		return softNMSNone, nil
+	case "linear":
+		return softNMSLinear, nil
+	case "gaussian":
+		return softNMSGaussian, nil
+	}
+	return 0, fmt.Errorf("Unknown Soft-NMS method: %s", s)
+}
+
+// nmsOptions 保存非极大值抑制的配置
+type nmsOptions struct {
+	// 重叠框之间的 IoU 超过该值时被抑制（或在 Soft-NMS 中被衰减）
+	iouThreshold float32
+	// 为 true 时不同类别的框也会互相抑制
+	classAgnostic bool
+	// 最多保留的检测框数量，<= 0 表示不限制
+	maxDetections int
+	// 使用的 Soft-NMS 方法，softNMSNone 表示普通 NMS
+	softMethod softNMSMethod
+	// 高斯 Soft-NMS 的 sigma 参数
+	sigma float32
+	// Soft-NMS 衰减后置信度低于该值的框会被丢弃
+	scoreThreshold float32
+}
+
+// sortByConfidence 按置信度从高到低对 boxes 进行稳定排序
+func sortByConfidence(boxes []boundingBox) {
+	sort.SliceStable(boxes, func(i, j int) bool {
+		return boxes[i].confidence > boxes[j].confidence
+	})
+}
+
+// canSuppress 判断 a 和 b 是否属于可以互相抑制的类别
+func (o *nmsOptions) canSuppress(a, b *boundingBox) bool {
+	return o.classAgnostic || (a.classID == b.classID)
+}
+
+// nonMaxSuppression 对 boxes 执行非极大值抑制，返回按置信度从高到低排序的
+// 结果。boxes 本身不会被修改。
+func nonMaxSuppression(boxes []boundingBox, o *nmsOptions) []boundingBox {
+	candidates := make([]boundingBox, len(boxes))
+	copy(candidates, boxes)
+	if o.softMethod != softNMSNone {
+		return o.softSuppress(candidates)
+	}
+	sortByConfidence(candidates)
+	kept := make([]boundingBox, 0, len(candidates))
+	for i := range candidates {
+		if (o.maxDetections > 0) && (len(kept) >= o.maxDetections) {
+			break
+		}
+		candidate := &candidates[i]
+		overlapsExistingBox := false
+		for j := range kept {
+			if !o.canSuppress(candidate, &kept[j]) {
+				continue
+			}
+			if candidate.iou(&kept[j]) > o.iouThreshold {
+				overlapsExistingBox = true
+				break
+			}
+		}
+		if !overlapsExistingBox {
+			kept = append(kept, *candidate)
+		}
+	}
+	return kept
+}
+
+// softSuppress 实现 Soft-NMS：每次选出剩余框中置信度最高的一个，然后衰减
+// 与它重叠的框的置信度，而不是直接丢弃它们。
+func (o *nmsOptions) softSuppress(candidates []boundingBox) []boundingBox {
+	kept := make([]boundingBox, 0, len(candidates))
+	for len(candidates) > 0 {
+		if (o.maxDetections > 0) && (len(kept) >= o.maxDetections) {
+			break
+		}
+		// 找到置信度最高的框，遇到相同置信度时保留靠前的框
+		best := 0
+		for i := range candidates {
+			if candidates[i].confidence > candidates[best].confidence {
+				best = i
+			}
+		}
+		chosen := candidates[best]
+		kept = append(kept, chosen)
+		candidates = append(candidates[:best], candidates[best+1:]...)
+
+		// 衰减剩余框的置信度，并丢弃低于阈值的框
+		remaining := candidates[:0]
+		for _, b := range candidates {
+			if o.canSuppress(&chosen, &b) {
+				b.confidence *= o.decay(chosen.iou(&b))
+			}
+			if b.confidence < o.scoreThreshold {
+				continue
+			}
+			remaining = append(remaining, b)
+		}
+		candidates = remaining
+	}
+	return kept
+}
+
+// decay 返回 Soft-NMS 中 IoU 为 iou 的框的置信度衰减系数
+func (o *nmsOptions) decay(iou float32) float32 {
+	switch o.softMethod {
+	case softNMSLinear:
+		if iou > o.iouThreshold {
+			return 1 - iou
+		}
+		return 1
+	case softNMSGaussian:
+		return float32(math.Exp(-float64(iou*iou) / float64(o.sigma)))
+	}
+	if iou > o.iouThreshold {
+		return 0
+	}
+	return 1
+}
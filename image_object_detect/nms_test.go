@@ -0,0 +1,158 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// newTestBox 返回一个用于测试的边界框
+func newTestBox(classID int, confidence, x1, y1, x2, y2 float32) boundingBox {
+	return boundingBox{
+		label:      yoloClasses[classID],
+		classID:    classID,
+		confidence: confidence,
+		x1:         x1,
+		y1:         y1,
+		x2:         x2,
+		y2:         y2,
+	}
+}
+
+func TestIOU(t *testing.T) {
+	a := newTestBox(0, 1, 0, 0, 10, 10)
+	b := newTestBox(0, 1, 5, 0, 15, 10)
+	// 交集 50，并集 150
+	if got := a.iou(&b); math.Abs(float64(got)-1.0/3.0) > 1e-6 {
+		t.Errorf("Expected IoU 1/3, got %f", got)
+	}
+	// 亚像素的框不应该因为取整而变成 0
+	c := newTestBox(0, 1, 0.2, 0.2, 0.8, 0.8)
+	if got := c.iou(&c); got != 1 {
+		t.Errorf("Expected IoU of a box with itself to be 1, got %f", got)
+	}
+	d := newTestBox(0, 1, 20, 20, 30, 30)
+	if got := a.iou(&d); got != 0 {
+		t.Errorf("Expected IoU of disjoint boxes to be 0, got %f", got)
+	}
+	empty := newTestBox(0, 1, 3, 3, 3, 3)
+	if got := empty.iou(&empty); got != 0 {
+		t.Errorf("Expected IoU of empty boxes to be 0, got %f", got)
+	}
+}
+
+func TestNMSKeepsHighestConfidence(t *testing.T) {
+	boxes := []boundingBox{
+		newTestBox(0, 0.6, 0, 0, 100, 100),
+		newTestBox(0, 0.9, 2, 2, 102, 102),
+		newTestBox(0, 0.7, 1, 1, 101, 101),
+		newTestBox(0, 0.8, 300, 300, 400, 400),
+	}
+	result := nonMaxSuppression(boxes, &nmsOptions{iouThreshold: 0.7})
+	if len(result) != 2 {
+		t.Fatalf("Expected 2 boxes, got %d: %v", len(result), result)
+	}
+	if result[0].confidence != 0.9 {
+		t.Errorf("Expected the strongest box of the cluster to be kept, "+
+			"got %s", &result[0])
+	}
+	if result[1].confidence != 0.8 {
+		t.Errorf("Expected the isolated box second, got %s", &result[1])
+	}
+	// 输入不应该被修改
+	if boxes[0].confidence != 0.6 {
+		t.Errorf("The input slice was reordered")
+	}
+}
+
+func TestNMSPerClassAndAgnostic(t *testing.T) {
+	boxes := []boundingBox{
+		newTestBox(0, 0.9, 0, 0, 100, 100),
+		newTestBox(2, 0.8, 0, 0, 100, 100),
+	}
+	result := nonMaxSuppression(boxes, &nmsOptions{iouThreshold: 0.5})
+	if len(result) != 2 {
+		t.Errorf("Expected boxes of different classes to both be kept, "+
+			"got %d", len(result))
+	}
+	result = nonMaxSuppression(boxes, &nmsOptions{
+		iouThreshold:  0.5,
+		classAgnostic: true,
+	})
+	if len(result) != 1 {
+		t.Fatalf("Expected class-agnostic NMS to keep 1 box, got %d",
+			len(result))
+	}
+	if result[0].classID != 0 {
+		t.Errorf("Expected the class 0 box to be kept, got %s", &result[0])
+	}
+}
+
+func TestNMSMaxDetections(t *testing.T) {
+	var boxes []boundingBox
+	for i := 0; i < 10; i++ {
+		x := float32(i * 50)
+		boxes = append(boxes, newTestBox(0, float32(i)/10, x, 0, x+40, 40))
+	}
+	result := nonMaxSuppression(boxes, &nmsOptions{
+		iouThreshold:  0.5,
+		maxDetections: 3,
+	})
+	if len(result) != 3 {
+		t.Fatalf("Expected 3 boxes, got %d", len(result))
+	}
+	for i, expected := range []float32{0.9, 0.8, 0.7} {
+		if result[i].confidence != expected {
+			t.Errorf("Expected box %d to have confidence %f, got %f", i,
+				expected, result[i].confidence)
+		}
+	}
+}
+
+func TestSoftNMSLinear(t *testing.T) {
+	boxes := []boundingBox{
+		newTestBox(0, 0.9, 0, 0, 10, 10),
+		newTestBox(0, 0.8, 5, 0, 15, 10),
+		newTestBox(0, 0.7, 0, 0, 10, 10),
+	}
+	result := nonMaxSuppression(boxes, &nmsOptions{
+		iouThreshold:   0.3,
+		softMethod:     softNMSLinear,
+		scoreThreshold: 0.1,
+	})
+	// 第二个框的 IoU 为 1/3，衰减为 0.8 * 2/3；第三个框与第一个框完全重合，
+	// 衰减为 0，被丢弃。
+	if len(result) != 2 {
+		t.Fatalf("Expected 2 boxes, got %d: %v", len(result), result)
+	}
+	expected := float32(0.8 * 2.0 / 3.0)
+	if math.Abs(float64(result[1].confidence-expected)) > 1e-6 {
+		t.Errorf("Expected decayed confidence %f, got %f", expected,
+			result[1].confidence)
+	}
+}
+
+func TestSoftNMSGaussian(t *testing.T) {
+	boxes := []boundingBox{
+		newTestBox(0, 0.9, 0, 0, 10, 10),
+		newTestBox(0, 0.8, 5, 0, 15, 10),
+		newTestBox(1, 0.5, 5, 0, 15, 10),
+	}
+	result := nonMaxSuppression(boxes, &nmsOptions{
+		iouThreshold: 0.3,
+		softMethod:   softNMSGaussian,
+		sigma:        0.5,
+	})
+	if len(result) != 3 {
+		t.Fatalf("Expected 3 boxes, got %d: %v", len(result), result)
+	}
+	expected := 0.8 * math.Exp(-(1.0/9.0)/0.5)
+	if math.Abs(float64(result[1].confidence)-expected) > 1e-6 {
+		t.Errorf("Expected decayed confidence %f, got %f", expected,
+			result[1].confidence)
+	}
+	// 不同类别的框不受影响
+	if result[2].confidence != 0.5 {
+		t.Errorf("Expected the class 1 box to keep its confidence, got %f",
+			result[2].confidence)
+	}
+}
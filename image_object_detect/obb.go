@@ -0,0 +1,157 @@
+package main
+
+import (
+	"math"
+)
+
+// rotatedBox 是 YOLOv8-obb 等旋转框检测模型输出的有向边界框
+type rotatedBox struct {
+	cx, cy float32 // 中心点坐标
+	w, h   float32 // 宽度和高度
+	// 宽度方向相对于 x 轴的旋转角度（弧度），y 轴向下时顺时针为正。经过
+	// regularize 之后 w >= h，角度位于 [0, π)。
+	angle float32
+}
+
+// regularize 与 Ultralytics 的 regularize_rboxes 相同，交换宽度和高度使
+// w >= h，并把角度规范到 [0, π)，使同一个框只有一种表示方式。
+func (r *rotatedBox) regularize() {
+	angle := float64(r.angle)
+	if r.w < r.h {
+		r.w, r.h = r.h, r.w
+		angle += math.Pi / 2
+	}
+	angle = math.Mod(angle, math.Pi)
+	if angle < 0 {
+		angle += math.Pi
+	}
+	r.angle = float32(angle)
+}
+
+// corners 返回旋转框的四个顶点，顺序与 Ultralytics 的 xywhr2xyxyxyxy 相同，
+// 相邻的两个顶点之间是框的一条边。
+func (r *rotatedBox) corners() [4][2]float32 {
+	sin, cos := math.Sincos(float64(r.angle))
+	// 沿宽度和高度方向的半边向量
+	wx, wy := float32(cos)*r.w/2, float32(sin)*r.w/2
+	hx, hy := -float32(sin)*r.h/2, float32(cos)*r.h/2
+	return [4][2]float32{
+		{r.cx + wx + hx, r.cy + wy + hy},
+		{r.cx + wx - hx, r.cy + wy - hy},
+		{r.cx - wx - hx, r.cy - wy - hy},
+		{r.cx - wx + hx, r.cy - wy + hy},
+	}
+}
+
+// bounds 返回包含旋转框的最小轴对齐矩形的左上角和右下角坐标
+func (r *rotatedBox) bounds() (float32, float32, float32, float32) {
+	corners := r.corners()
+	x1, y1 := corners[0][0], corners[0][1]
+	x2, y2 := x1, y1
+	for _, c := range corners[1:] {
+		x1, y1 = min(x1, c[0]), min(y1, c[1])
+		x2, y2 = max(x2, c[0]), max(y2, c[1])
+	}
+	return x1, y1, x2, y2
+}
+
+// area 返回旋转框的面积
+func (r *rotatedBox) area() float32 {
+	return max(r.w, 0) * max(r.h, 0)
+}
+
+// toOriginal 将网络输入坐标系中的旋转框转换回原始图像坐标系。letterbox
+// 缩放在两个方向上的比例相同，转换是精确的；stretch 缩放会把矩形变为平行
+// 四边形，此时宽度和角度取自宽度方向的边，高度取自高度方向的边，结果是
+// 近似值。
+func (r *rotatedBox) toOriginal(t *inputTransform) rotatedBox {
+	sin, cos := math.Sincos(float64(r.angle))
+	cx, cy := t.toOriginal(r.cx, r.cy)
+	wx := float64(r.w) * cos / float64(t.scaleX)
+	wy := float64(r.w) * sin / float64(t.scaleY)
+	hx := -float64(r.h) * sin / float64(t.scaleX)
+	hy := float64(r.h) * cos / float64(t.scaleY)
+	toReturn := rotatedBox{
+		cx:    cx,
+		cy:    cy,
+		w:     float32(math.Hypot(wx, wy)),
+		h:     float32(math.Hypot(hx, hy)),
+		angle: float32(math.Atan2(wy, wx)),
+	}
+	toReturn.regularize()
+	return toReturn
+}
+
+// polygonArea 返回多边形的有向面积，顶点按 x 轴向 y 轴旋转的方向排列时为正
+func polygonArea(polygon [][2]float64) float64 {
+	var toReturn float64
+	for i := range polygon {
+		a, b := polygon[i], polygon[(i+1)%len(polygon)]
+		toReturn += a[0]*b[1] - b[0]*a[1]
+	}
+	return toReturn / 2
+}
+
+// clipPolygon 使用 Sutherland–Hodgman 算法返回 subject 与凸多边形 clip 的
+// 交集。clip 的有向面积必须为正。
+func clipPolygon(subject, clip [][2]float64) [][2]float64 {
+	// cross 返回 p 在有向线段 a->b 左侧（即 clip 内侧）时为正的值
+	cross := func(a, b, p [2]float64) float64 {
+		return (b[0]-a[0])*(p[1]-a[1]) - (b[1]-a[1])*(p[0]-a[0])
+	}
+	toReturn := subject
+	for i := range clip {
+		if len(toReturn) == 0 {
+			break
+		}
+		a, b := clip[i], clip[(i+1)%len(clip)]
+		input := toReturn
+		toReturn = make([][2]float64, 0, len(input)+1)
+		for j := range input {
+			p, q := input[j], input[(j+1)%len(input)]
+			cp, cq := cross(a, b, p), cross(a, b, q)
+			if cp >= 0 {
+				toReturn = append(toReturn, p)
+			}
+			// p 和 q 位于边的两侧时，加入线段 pq 与边的交点
+			if (cp >= 0) != (cq >= 0) {
+				s := cp / (cp - cq)
+				toReturn = append(toReturn, [2]float64{
+					p[0] + s*(q[0]-p[0]),
+					p[1] + s*(q[1]-p[1]),
+				})
+			}
+		}
+	}
+	return toReturn
+}
+
+// polygonOf 返回 r 的顶点，按有向面积为正的顺序排列
+func polygonOf(r *rotatedBox) [][2]float64 {
+	corners := r.corners()
+	toReturn := make([][2]float64, len(corners))
+	for i, c := range corners {
+		toReturn[i] = [2]float64{float64(c[0]), float64(c[1])}
+	}
+	if polygonArea(toReturn) < 0 {
+		for i, j := 0, len(toReturn)-1; i < j; i, j = i+1, j-1 {
+			toReturn[i], toReturn[j] = toReturn[j], toReturn[i]
+		}
+	}
+	return toReturn
+}
+
+// rotatedIoU 返回两个旋转框的 IoU，交集为两个矩形相交得到的凸多边形
+func rotatedIoU(a, b *rotatedBox) float32 {
+	union := float64(a.area()) + float64(b.area())
+	if union <= 0 {
+		return 0
+	}
+	intersection := math.Abs(polygonArea(clipPolygon(polygonOf(a),
+		polygonOf(b))))
+	union -= intersection
+	if union <= 0 {
+		return 0
+	}
+	return float32(intersection / union)
+}
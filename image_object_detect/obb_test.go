@@ -0,0 +1,148 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRotatedBoxRegularize(t *testing.T) {
+	r := rotatedBox{w: 2, h: 4, angle: -math.Pi / 4}
+	r.regularize()
+	if (r.w != 4) || (r.h != 2) {
+		t.Errorf("Expected a 4x2 box, got %fx%f", r.w, r.h)
+	}
+	if math.Abs(float64(r.angle)-math.Pi/4) > 1e-6 {
+		t.Errorf("Expected an angle of pi/4, got %f", r.angle)
+	}
+}
+
+func TestRotatedBoxBounds(t *testing.T) {
+	r := rotatedBox{cx: 10, cy: 20, w: 4, h: 2, angle: math.Pi / 2}
+	x1, y1, x2, y2 := r.bounds()
+	expected := []float32{9, 18, 11, 22}
+	for i, v := range []float32{x1, y1, x2, y2} {
+		if math.Abs(float64(v-expected[i])) > 1e-5 {
+			t.Errorf("Expected bounds %v, got %v", expected,
+				[]float32{x1, y1, x2, y2})
+			break
+		}
+	}
+}
+
+func TestRotatedIoU(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     rotatedBox
+		expected float32
+	}{
+		{
+			name:     "identical",
+			a:        rotatedBox{cx: 5, cy: 5, w: 4, h: 2, angle: 0.3},
+			b:        rotatedBox{cx: 5, cy: 5, w: 4, h: 2, angle: 0.3},
+			expected: 1,
+		},
+		{
+			name:     "square rotated by 90 degrees",
+			a:        rotatedBox{cx: 0, cy: 0, w: 2, h: 2},
+			b:        rotatedBox{cx: 0, cy: 0, w: 2, h: 2, angle: math.Pi / 2},
+			expected: 1,
+		},
+		{
+			// 交集为 2x2 的正方形，并集为 8 + 8 - 4
+			name:     "cross",
+			a:        rotatedBox{cx: 0, cy: 0, w: 4, h: 2},
+			b:        rotatedBox{cx: 0, cy: 0, w: 4, h: 2, angle: math.Pi / 2},
+			expected: 4.0 / 12.0,
+		},
+		{
+			// 正方形旋转 45 度后与原正方形的交集是正八边形
+			name: "square rotated by 45 degrees",
+			a:    rotatedBox{cx: 0, cy: 0, w: 2, h: 2},
+			b:    rotatedBox{cx: 0, cy: 0, w: 2, h: 2, angle: math.Pi / 4},
+			expected: float32((8 * (math.Sqrt2 - 1)) /
+				(8 - 8*(math.Sqrt2-1))),
+		},
+		{
+			name:     "disjoint",
+			a:        rotatedBox{cx: 0, cy: 0, w: 2, h: 2, angle: 0.5},
+			b:        rotatedBox{cx: 10, cy: 0, w: 2, h: 2, angle: 0.5},
+			expected: 0,
+		},
+	}
+	for _, test := range tests {
+		iou := rotatedIoU(&test.a, &test.b)
+		if math.Abs(float64(iou-test.expected)) > 1e-5 {
+			t.Errorf("%s: expected IoU %f, got %f", test.name, test.expected,
+				iou)
+		}
+	}
+}
+
+// newTestRotatedBox 返回置信度为 confidence 的旋转框检测结果
+func newTestRotatedBox(confidence float32, r rotatedBox) boundingBox {
+	b := boundingBox{confidence: confidence, rotated: &r}
+	b.x1, b.y1, b.x2, b.y2 = r.bounds()
+	return b
+}
+
+func TestNMSRotated(t *testing.T) {
+	// 两个细长的交叉旋转框的轴对齐外接矩形几乎重合，但旋转框本身的 IoU
+	// 很低，不应互相抑制
+	a := newTestRotatedBox(0.9, rotatedBox{cx: 50, cy: 50, w: 100, h: 10,
+		angle: math.Pi / 4})
+	b := newTestRotatedBox(0.8, rotatedBox{cx: 50, cy: 50, w: 100, h: 10,
+		angle: 3 * math.Pi / 4})
+	c := newTestRotatedBox(0.7, rotatedBox{cx: 51, cy: 50, w: 100, h: 10,
+		angle: math.Pi / 4})
+	if (a.union(&b) <= 0) || (a.intersection(&b)/a.union(&b) < 0.9) {
+		t.Fatalf("Expected the axis-aligned bounds to overlap")
+	}
+	kept := nonMaxSuppression([]boundingBox{a, b, c},
+		&nmsOptions{iouThreshold: 0.5})
+	if len(kept) != 2 {
+		t.Fatalf("Expected 2 boxes to be kept, got %d", len(kept))
+	}
+	if (kept[0].confidence != 0.9) || (kept[1].confidence != 0.8) {
+		t.Errorf("Got incorrect boxes: %s, %s", &kept[0], &kept[1])
+	}
+}
+
+func TestProcessOutputOBB(t *testing.T) {
+	info := &modelInfo{
+		inputWidth:    320,
+		inputHeight:   320,
+		numAnchors:    numAnchorsForInput(320, 320),
+		numAttributes: 7,
+		decoder:       yoloOBBDecoder{},
+	}
+	info.numClasses = info.decoder.numClasses(info.numAttributes)
+	if info.numClasses != 2 {
+		t.Fatalf("Expected 2 classes, got %d", info.numClasses)
+	}
+	// 宽度小于高度的框会被规范为宽度方向旋转 90 度
+	output := newTestOutput(info, 9, []float32{160, 160, 20, 40, 0.1, 0.8,
+		0})
+	transform, _, _ := newInputTransform(640, 640, 320, 320, resizeLetterbox)
+	boxes := processOutput([][]float32{output}, info, transform, 640, 640,
+		0.5, &nmsOptions{iouThreshold: 0.7})
+	if len(boxes) != 1 {
+		t.Fatalf("Expected 1 box, got %d", len(boxes))
+	}
+	b := &boxes[0]
+	r := b.rotated
+	if r == nil {
+		t.Fatalf("Expected a rotated box")
+	}
+	if (b.classID != 1) || (r.cx != 320) || (r.cy != 320) ||
+		(math.Abs(float64(r.w-80)) > 1e-4) ||
+		(math.Abs(float64(r.h-40)) > 1e-4) ||
+		(math.Abs(float64(r.angle)-math.Pi/2) > 1e-6) {
+		t.Errorf("Got incorrect rotated box: %s", b)
+	}
+	if (math.Abs(float64(b.x1-300)) > 1e-3) ||
+		(math.Abs(float64(b.y1-280)) > 1e-3) ||
+		(math.Abs(float64(b.x2-340)) > 1e-3) ||
+		(math.Abs(float64(b.y2-360)) > 1e-3) {
+		t.Errorf("Got incorrect bounds: %s", b)
+	}
+}
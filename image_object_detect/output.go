@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/8ff/prettyTimer"
+)
+
+// imageResult 保存一张图片的检测结果，用于按不同格式输出
+type imageResult struct {
+	path          string          // 输入图片路径
+	imageID       int             // COCO 格式中使用的图片编号
+	width, height int             // 原始图片尺寸
+	boxes         []boundingBox   // 检测结果
+	timing        detectionTiming // 各阶段的平均耗时
+	// 使用 -zones 时各区域（和 stream 子命令中线段）的统计结果
+	analytics *jsonAnalytics
+}
+
+// resultWriter 以某种格式输出每张图片的检测结果
+type resultWriter interface {
+	// writeResult 输出一张图片的检测结果
+	writeResult(r *imageResult) error
+	// finish 在所有图片处理完毕后调用，用于输出需要汇总的内容
+	finish() error
+}
+
+// newResultWriter 返回以 format 格式向 w 输出结果的 resultWriter，format 为
+// "text"、"json" 或 "coco"。
+func newResultWriter(format string, w io.Writer) (resultWriter, error) {
+	switch format {
+	case "text":
+		return &textResultWriter{w: w}, nil
+	case "json":
+		return &jsonResultWriter{encoder: json.NewEncoder(w)}, nil
+	case "coco":
+		return &cocoResultWriter{w: w}, nil
+	}
+	return nil, fmt.Errorf("Unsupported output format: %s", format)
+}
+
+// textResultWriter 以便于阅读的文本形式输出检测结果，内容可能会变化，
+// 不应被其他程序解析。
+type textResultWriter struct {
+	w io.Writer
+}
+
+func (t *textResultWriter) writeResult(r *imageResult) error {
+	_, e := fmt.Fprintf(t.w, "%s:\n", r.path)
+	if e != nil {
+		return e
+	}
+	for i := range r.boxes {
+		_, e := fmt.Fprintf(t.w, "Box %d: %s\n", i, &r.boxes[i])
+		if e != nil {
+			return e
+		}
+	}
+	if r.analytics == nil {
+		return nil
+	}
+	for _, zone := range r.analytics.Zones {
+		_, e := fmt.Fprintf(t.w, "Zone %s: %d objects\n", zone.Name,
+			zone.Count)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func (t *textResultWriter) finish() error {
+	return nil
+}
+
+// jsonBox 是检测框在 JSON 输出中的表示
+type jsonBox struct {
+	X1 float32 `json:"x1"`
+	Y1 float32 `json:"y1"`
+	X2 float32 `json:"x2"`
+	Y2 float32 `json:"y2"`
+}
+
+// jsonDetection 是单个检测结果在 JSON 输出中的表示
+type jsonDetection struct {
+	Label      string  `json:"label"`
+	ClassID    int     `json:"class_id"`
+	Confidence float32 `json:"confidence"`
+	Box        jsonBox `json:"box"`
+	// 分割模型的掩码，覆盖整张图像
+	Segmentation *cocoRLE `json:"segmentation,omitempty"`
+	// 姿态估计模型的关键点，格式与 COCO 相同，为 [x1, y1, v1, x2, ...]
+	Keypoints    []float32 `json:"keypoints,omitempty"`
+	NumKeypoints int       `json:"num_keypoints,omitempty"`
+	// 旋转框检测模型的有向边界框及其四个顶点 [x1, y1, ..., x4, y4]，此时
+	// box 为包含它的最小轴对齐矩形
+	RotatedBox *jsonRotatedBox `json:"rotated_box,omitempty"`
+	Polygon    []float32       `json:"polygon,omitempty"`
+	// 跟踪时检测框所属轨迹的编号
+	TrackID int `json:"track_id,omitempty"`
+}
+
+// jsonRotatedBox 是旋转框在 JSON 输出中的表示，angle 的单位为弧度
+type jsonRotatedBox struct {
+	CX    float32 `json:"cx"`
+	CY    float32 `json:"cy"`
+	W     float32 `json:"w"`
+	H     float32 `json:"h"`
+	Angle float32 `json:"angle"`
+}
+
+// rotatedPolygon 返回旋转框的四个顶点 [x1, y1, ..., x4, y4]
+func rotatedPolygon(r *rotatedBox) []float32 {
+	toReturn := make([]float32, 0, 8)
+	for _, c := range r.corners() {
+		toReturn = append(toReturn, c[0], c[1])
+	}
+	return toReturn
+}
+
+// jsonTiming 以毫秒为单位记录各阶段耗时
+type jsonTiming struct {
+	PreprocessMs  float64 `json:"preprocess_ms"`
+	InferenceMs   float64 `json:"inference_ms"`
+	PostprocessMs float64 `json:"postprocess_ms"`
+}
+
+// jsonImageResult 是 "json" 格式中每张图片对应的文档
+type jsonImageResult struct {
+	File       string          `json:"file"`
+	ImageID    int             `json:"image_id"`
+	Width      int             `json:"width"`
+	Height     int             `json:"height"`
+	Timing     jsonTiming      `json:"timing"`
+	Detections []jsonDetection `json:"detections"`
+	// 使用 -zones 时各区域和线段的统计结果
+	Analytics *jsonAnalytics `json:"analytics,omitempty"`
+}
+
+// durationMs 将 d 转换为毫秒
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// newJSONImageResult 将 r 转换为 JSON 文档
+func newJSONImageResult(r *imageResult) *jsonImageResult {
+	detections := make([]jsonDetection, len(r.boxes))
+	for i := range r.boxes {
+		b := &r.boxes[i]
+		detections[i] = jsonDetection{
+			Label:      b.label,
+			ClassID:    b.classID,
+			Confidence: b.confidence,
+			Box:        jsonBox{X1: b.x1, Y1: b.y1, X2: b.x2, Y2: b.y2},
+			TrackID:    b.trackID,
+		}
+		if b.mask != nil {
+			detections[i].Segmentation = encodeRLE(b.mask, r.width, r.height)
+		}
+		if b.keypoints != nil {
+			detections[i].Keypoints = cocoKeypoints(b)
+			detections[i].NumKeypoints = numVisibleKeypoints(b)
+		}
+		if r := b.rotated; r != nil {
+			detections[i].RotatedBox = &jsonRotatedBox{
+				CX:    r.cx,
+				CY:    r.cy,
+				W:     r.w,
+				H:     r.h,
+				Angle: r.angle,
+			}
+			detections[i].Polygon = rotatedPolygon(r)
+		}
+	}
+	return &jsonImageResult{
+		File:    r.path,
+		ImageID: r.imageID,
+		Width:   r.width,
+		Height:  r.height,
+		Timing: jsonTiming{
+			PreprocessMs:  durationMs(r.timing.preprocess),
+			InferenceMs:   durationMs(r.timing.inference),
+			PostprocessMs: durationMs(r.timing.postprocess),
+		},
+		Detections: detections,
+		Analytics:  r.analytics,
+	}
+}
+
+// jsonResultWriter 每行输出一张图片的 JSON 文档 (JSON Lines)
+type jsonResultWriter struct {
+	encoder *json.Encoder
+}
+
+func (j *jsonResultWriter) writeResult(r *imageResult) error {
+	return j.encoder.Encode(newJSONImageResult(r))
+}
+
+func (j *jsonResultWriter) finish() error {
+	return nil
+}
+
+// cocoDetection 是 COCO results 格式中的一条检测结果
+type cocoDetection struct {
+	ImageID      int        `json:"image_id"`
+	CategoryID   int        `json:"category_id"`
+	BBox         [4]float32 `json:"bbox"`
+	Score        float32    `json:"score"`
+	Segmentation *cocoRLE   `json:"segmentation,omitempty"`
+	Keypoints    []float32  `json:"keypoints,omitempty"`
+	// 与 Ultralytics 相同，旋转框以 rbox [cx, cy, w, h, 角度] 和 poly
+	// [x1, y1, ..., x4, y4] 表示
+	RBox []float32 `json:"rbox,omitempty"`
+	Poly []float32 `json:"poly,omitempty"`
+}
+
+// cocoKeypoints 将 b 的关键点转换为 COCO 格式的 [x1, y1, v1, x2, ...]。与
+// Ultralytics 相同，v 为模型输出的可见度置信度。
+func cocoKeypoints(b *boundingBox) []float32 {
+	toReturn := make([]float32, 0, 3*len(b.keypoints))
+	for _, k := range b.keypoints {
+		toReturn = append(toReturn, k.x, k.y, k.visibility)
+	}
+	return toReturn
+}
+
+// numVisibleKeypoints 返回 b 中可见度不低于 keypointVisibilityThreshold 的
+// 关键点数量
+func numVisibleKeypoints(b *boundingBox) int {
+	toReturn := 0
+	for _, k := range b.keypoints {
+		if k.visibility >= keypointVisibilityThreshold {
+			toReturn++
+		}
+	}
+	return toReturn
+}
+
+// newCOCODetection 将 r 中的 b 转换为 COCO results 格式，bbox 为
+// [x, y, w, h]
+func newCOCODetection(r *imageResult, b *boundingBox) cocoDetection {
+	toReturn := cocoDetection{
+		ImageID:    r.imageID,
+		CategoryID: cocoCategoryID(b),
+		BBox:       [4]float32{b.x1, b.y1, b.x2 - b.x1, b.y2 - b.y1},
+		Score:      b.confidence,
+	}
+	if b.mask != nil {
+		toReturn.Segmentation = encodeRLE(b.mask, r.width, r.height)
+	}
+	if b.keypoints != nil {
+		toReturn.Keypoints = cocoKeypoints(b)
+	}
+	if r := b.rotated; r != nil {
+		toReturn.RBox = []float32{r.cx, r.cy, r.w, r.h, r.angle}
+		toReturn.Poly = rotatedPolygon(r)
+	}
+	return toReturn
+}
+
+// cocoResultWriter 将所有图片的检测结果汇总为一个 COCO results 数组，可以
+// 直接交给 pycocotools 等工具评估。
+type cocoResultWriter struct {
+	w          io.Writer
+	detections []cocoDetection
+}
+
+func (c *cocoResultWriter) writeResult(r *imageResult) error {
+	for i := range r.boxes {
+		c.detections = append(c.detections,
+			newCOCODetection(r, &r.boxes[i]))
+	}
+	return nil
+}
+
+func (c *cocoResultWriter) finish() error {
+	if c.detections == nil {
+		c.detections = []cocoDetection{}
+	}
+	return json.NewEncoder(c.w).Encode(c.detections)
+}
+
+// cocoImageIDs 返回 paths 中每张图片在 COCO 格式中的编号。COCO 数据集的
+// 文件名（例如 000000397133.jpg）本身就是编号，因此所有文件名都是互不相同的
+// 非负整数时使用文件名；否则所有图片都使用它在输入列表中的位置，从 1 开始，
+// 避免两种编号混用时重复。
+func cocoImageIDs(paths []string) []int {
+	toReturn := make([]int, len(paths))
+	used := make(map[int]bool)
+	for i, path := range paths {
+		base := filepath.Base(path)
+		base = strings.TrimSuffix(base, filepath.Ext(base))
+		id, e := strconv.Atoi(base)
+		if (e != nil) || (id < 0) || used[id] {
+			for j := range toReturn {
+				toReturn[j] = j + 1
+			}
+			return toReturn
+		}
+		used[id] = true
+		toReturn[i] = id
+	}
+	return toReturn
+}
+
+// coco80To91 将 YOLO 使用的 80 个连续类别编号映射到 COCO 原始的 91 个类别
+// 编号
+var coco80To91 = []int{
+	1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 14, 15, 16, 17, 18, 19, 20, 21,
+	22, 23, 24, 25, 27, 28, 31, 32, 33, 34, 35, 36, 37, 38, 39, 40, 41, 42,
+	43, 44, 46, 47, 48, 49, 50, 51, 52, 53, 54, 55, 56, 57, 58, 59, 60, 61,
+	62, 63, 64, 65, 67, 70, 72, 73, 74, 75, 76, 77, 78, 79, 80, 81, 82, 84,
+	85, 86, 87, 88, 89, 90,
+}
+
+// cocoCategoryID 返回检测框对应的 COCO category_id。只有使用 COCO 类别的
+// 模型才会映射到 91 类编号，其他模型直接使用类别编号。
+func cocoCategoryID(b *boundingBox) int {
+	classID := b.classID
+	if (classID >= 0) && (classID < len(coco80To91)) &&
+		(yoloClasses[classID] == b.label) {
+		return coco80To91[classID]
+	}
+	return classID
+}
+
+// printTimingStats 与 prettyTimer 的 PrintStats 输出相同的统计信息，但写入
+// w 并且不使用终端颜色，以免混入机器可读的输出。
+func printTimingStats(w io.Writer, stats *prettyTimer.TimingStats) {
+	if stats.Count == 0 {
+		fmt.Fprintln(w, "No timings recorded")
+		return
+	}
+	average := stats.TotalTime / time.Duration(stats.Count)
+	fmt.Fprintf(w, "Min Time: %s, Max Time: %s, Avg Time: %s, Count: %d\n",
+		stats.MinTime, stats.MaxTime, average, stats.Count)
+	fmt.Fprintf(w, "50th: %s, 90th: %s, 99th: %s\n",
+		stats.CalculatePercentile(50), stats.CalculatePercentile(90),
+		stats.CalculatePercentile(99))
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCocoImageIDs(t *testing.T) {
+	tests := []struct {
+		paths    []string
+		expected []int
+	}{
+		{[]string{"val/000000397133.jpg", "val/000000000139.jpg"},
+			[]int{397133, 139}},
+		// 2.jpg 的编号会与 a.jpg 的位置编号重复，因此都使用位置
+		{[]string{"a.jpg", "2.jpg", "b.jpg"}, []int{1, 2, 3}},
+		// 不同扩展名的同名文件也会得到重复的编号
+		{[]string{"5.jpg", "5.png"}, []int{1, 2}},
+		{[]string{"-1.jpg"}, []int{1}},
+		{nil, []int{}},
+	}
+	for _, test := range tests {
+		actual := cocoImageIDs(test.paths)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Expected the image IDs of %v to be %v, got %v",
+				test.paths, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"sync"
+	"time"
+)
+
+// pipelineConfig 保存流水线模式中各阶段的并发数量
+type pipelineConfig struct {
+	// 解码和预处理图片的 goroutine 数量
+	preprocessWorkers int
+	// 运行模型的会话数量，每个会话由一个 goroutine 使用
+	sessions int
+	// 运行 processOutput 的 goroutine 数量
+	postprocessWorkers int
+	// 各阶段之间的通道容量，限制同时在内存中的图片数量
+	queueSize int
+}
+
+// validate 检查 c 中的数量是否有效
+func (c *pipelineConfig) validate() error {
+	if (c.preprocessWorkers < 1) || (c.sessions < 1) ||
+		(c.postprocessWorkers < 1) {
+		return fmt.Errorf("Each pipeline stage needs at least 1 worker")
+	}
+	if c.queueSize < 1 {
+		return fmt.Errorf("The pipeline queue size must be at least 1")
+	}
+	return nil
+}
+
+// pipelineItem 是流水线中一张图片在各阶段之间传递的状态
+type pipelineItem struct {
+	index     int            // 图片在输入列表中的位置
+	path      string         // 图片路径
+	pic       image.Image    // 解码后的图片
+	input     *[]float32     // 预处理后的网络输入，(3, H, W)
+	transform inputTransform // 预处理时的缩放和填充
+	outputs   [][]float32    // 属于这张图片的模型输出
+	boxes     []boundingBox  // 检测结果
+	timing    detectionTiming
+}
+
+// detectionPipeline 将图片解码和预处理、模型推理、后处理分为三个阶段，由
+// 有界通道连接，使各阶段可以在不同的 goroutine 中同时运行
+type detectionPipeline struct {
+	detector *detector
+	// 推理阶段使用的会话，其中第一个为 detector 自己的会话
+	sessions []*ModelSession
+	config   pipelineConfig
+	// 回收预处理使用的输入缓冲区，保存 *[]float32 以避免 Put 时分配
+	inputBuffers sync.Pool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// 第一个发生的错误
+	errorOnce sync.Once
+	e         error
+}
+
+// newDetectionPipeline 为 d 创建流水线，除了 d 自己的会话，还会额外创建
+// config.sessions - 1 个会话。返回的流水线在不再需要时必须调用 Destroy()。
+func newDetectionPipeline(d *detector, cfg *sessionConfig,
+	config pipelineConfig) (*detectionPipeline, error) {
+	e := config.validate()
+	if e != nil {
+		return nil, e
+	}
+	// 流水线的各阶段每张图片只运行一次模型
+	if (d.tiling != nil) || (d.tta != nil) {
+		return nil, fmt.Errorf("Sliced inference and test-time " +
+			"augmentation can't be used with the pipeline")
+	}
+	toReturn := &detectionPipeline{
+		detector: d,
+		sessions: []*ModelSession{d.session},
+		config:   config,
+	}
+	for len(toReturn.sessions) < config.sessions {
+		session, e := initSession(cfg, d.session.Info)
+		if e != nil {
+			toReturn.Destroy()
+			return nil, fmt.Errorf("Error creating session %d: %w",
+				len(toReturn.sessions), e)
+		}
+		toReturn.sessions = append(toReturn.sessions, session)
+	}
+	info := d.session.Info
+	inputSize := 3 * info.inputWidth * info.inputHeight
+	toReturn.inputBuffers.New = func() any {
+		buffer := make([]float32, inputSize)
+		return &buffer
+	}
+	return toReturn, nil
+}
+
+// Destroy 释放流水线额外创建的会话，detector 自己的会话不会被释放
+func (p *detectionPipeline) Destroy() {
+	for _, s := range p.sessions[1:] {
+		s.Destroy()
+	}
+	p.sessions = p.sessions[:1]
+}
+
+// fail 记录第一个错误并停止所有阶段
+func (p *detectionPipeline) fail(e error) {
+	p.errorOnce.Do(func() {
+		p.e = e
+		p.cancel()
+	})
+}
+
+// send 将 item 发送到 dst，流水线已经停止时返回 false
+func (p *detectionPipeline) send(dst chan<- *pipelineItem,
+	item *pipelineItem) bool {
+	select {
+	case dst <- item:
+		return true
+	case <-p.ctx.Done():
+		return false
+	}
+}
+
+// startStage 启动 workers 个 goroutine，第 i 个运行 work(i)，全部结束后
+// 关闭 dst
+func startStage(workers int, dst chan *pipelineItem, work func(worker int)) {
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func(worker int) {
+			defer wg.Done()
+			work(worker)
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		close(dst)
+	}()
+}
+
+// run 处理 paths 中的所有图片，并按输入顺序对每张图片的结果调用 handle。
+// 任何一个阶段或 handle 返回错误时，流水线会停止并返回该错误。
+func (p *detectionPipeline) run(paths []string,
+	handle func(item *pipelineItem) error) error {
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	defer p.cancel()
+	p.e = nil
+	p.errorOnce = sync.Once{}
+
+	pending := make(chan *pipelineItem, p.config.queueSize)
+	prepared := make(chan *pipelineItem, p.config.queueSize)
+	inferred := make(chan *pipelineItem, p.config.queueSize)
+	finished := make(chan *pipelineItem, p.config.queueSize)
+	// 等待按顺序交给 handle 的图片也占用内存，因此限制已经开始处理但尚未交给
+	// handle 的图片数量。某张图片很慢时，之后的图片最多填满这个窗口，然后
+	// 所有阶段都会等待。
+	inFlight := make(chan struct{}, p.maxInFlight())
+	go func() {
+		defer close(pending)
+		for i, path := range paths {
+			select {
+			case inFlight <- struct{}{}:
+			case <-p.ctx.Done():
+				return
+			}
+			if !p.send(pending, &pipelineItem{index: i, path: path}) {
+				return
+			}
+		}
+	}()
+	startStage(p.config.preprocessWorkers, prepared, func(int) {
+		p.preprocess(pending, prepared)
+	})
+	// 每个会话只能由一个 goroutine 使用
+	startStage(len(p.sessions), inferred, func(worker int) {
+		p.infer(p.sessions[worker], prepared, inferred)
+	})
+	startStage(p.config.postprocessWorkers, finished, func(int) {
+		p.postprocess(inferred, finished)
+	})
+
+	// 后处理的结果可能乱序到达，按输入顺序交给 handle
+	waiting := make(map[int]*pipelineItem)
+	next := 0
+	for item := range finished {
+		waiting[item.index] = item
+		for {
+			item, ok := waiting[next]
+			if !ok {
+				break
+			}
+			delete(waiting, next)
+			next++
+			if p.ctx.Err() == nil {
+				e := handle(item)
+				if e != nil {
+					p.fail(e)
+				}
+			}
+			<-inFlight
+		}
+	}
+	return p.e
+}
+
+// maxInFlight 返回流水线中同时处理的图片数量上限，足以填满各阶段之间的
+// 通道并使每个 goroutine 都有图片可以处理
+func (p *detectionPipeline) maxInFlight() int {
+	toReturn := 4*p.config.queueSize + p.config.preprocessWorkers +
+		p.config.postprocessWorkers
+	for _, s := range p.sessions {
+		toReturn += int(s.Input.GetShape()[0])
+	}
+	return toReturn
+}
+
+// preprocess 解码 src 中的图片并写入输入缓冲区
+func (p *detectionPipeline) preprocess(src <-chan *pipelineItem,
+	dst chan<- *pipelineItem) {
+	info := p.detector.session.Info
+	for item := range src {
+		if p.ctx.Err() != nil {
+			return
+		}
+		start := time.Now()
+		pic, e := loadImageFile(item.path)
+		if e != nil {
+			p.fail(fmt.Errorf("Error loading input image: %w", e))
+			return
+		}
+		item.pic = pic
+		item.input = p.inputBuffers.Get().(*[]float32)
+		item.transform = fillInput(pic, *item.input, info.inputWidth,
+			info.inputHeight, p.detector.mode, p.detector.filter)
+		item.timing.preprocess = time.Since(start)
+		if !p.send(dst, item) {
+			return
+		}
+	}
+}
+
+// infer 使用 session 对 src 中的图片运行模型。已经就绪的图片会被尽量放入
+// 同一批，批的大小不超过会话的批大小。
+func (p *detectionPipeline) infer(session *ModelSession,
+	src <-chan *pipelineItem, dst chan<- *pipelineItem) {
+	batchSize := int(session.Input.GetShape()[0])
+	batch := make([]*pipelineItem, 0, batchSize)
+	for item := range src {
+		batch = append(batch[:0], item)
+		// 不等待尚未就绪的图片，避免增加延迟
+	gather:
+		for len(batch) < batchSize {
+			select {
+			case item, ok := <-src:
+				if !ok {
+					break gather
+				}
+				batch = append(batch, item)
+			default:
+				break gather
+			}
+		}
+		if p.ctx.Err() != nil {
+			return
+		}
+		inputData := session.Input.GetData()
+		for i, item := range batch {
+			input := *item.input
+			copy(inputData[i*len(input):], input)
+			p.inputBuffers.Put(item.input)
+			item.input = nil
+		}
+		start := time.Now()
+		e := session.Session.Run()
+		if e != nil {
+			p.fail(fmt.Errorf("Error running ORT session: %w", e))
+			return
+		}
+		inference := time.Since(start) / time.Duration(len(batch))
+		for i, item := range batch {
+			// 下一次 Run() 会覆盖输出张量，因此复制属于这张图片的输出
+			outputs := session.outputData(i)
+			item.outputs = make([][]float32, len(outputs))
+			for j, output := range outputs {
+				item.outputs[j] = append([]float32(nil), output...)
+			}
+			item.timing.inference = inference
+			if !p.send(dst, item) {
+				return
+			}
+		}
+	}
+}
+
+// postprocess 对 src 中的图片运行 processOutput
+func (p *detectionPipeline) postprocess(src <-chan *pipelineItem,
+	dst chan<- *pipelineItem) {
+	d := p.detector
+	for item := range src {
+		if p.ctx.Err() != nil {
+			return
+		}
+		start := time.Now()
+		bounds := item.pic.Bounds().Canon()
+		item.boxes = processOutput(item.outputs, d.session.Info,
+			item.transform, bounds.Dx(), bounds.Dy(), d.confidenceThreshold,
+			d.nms)
+		item.outputs = nil
+		item.timing.postprocess = time.Since(start)
+		if !p.send(dst, item) {
+			return
+		}
+	}
+}
+
+// printThroughput 输出在 elapsed 时间内完成 count 次检测的端到端吞吐量
+func printThroughput(w io.Writer, count int, elapsed time.Duration) {
+	if elapsed <= 0 {
+		return
+	}
+	fmt.Fprintf(w, "Throughput: %d images in %s, %.2f images/s\n", count,
+		elapsed, float64(count)/elapsed.Seconds())
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// resizeFilter 决定缩放图像时使用的插值算法
+type resizeFilter int
+
+const (
+	// filterLanczos3 使用 nfnt/resize 的 Lanczos3 插值，质量较高但较慢
+	filterLanczos3 resizeFilter = iota
+	// filterBilinear 使用与 OpenCV 的 INTER_LINEAR（Ultralytics 预处理使用的
+	// 插值方式）相同的双线性插值，直接写入输入张量，不依赖 nfnt/resize
+	filterBilinear
+)
+
+// parseResizeFilter 将命令行中的字符串转换为 resizeFilter
+func parseResizeFilter(s string) (resizeFilter, error) {
+	switch s {
+	case "lanczos":
+		return filterLanczos3, nil
+	case "bilinear":
+		return filterBilinear, nil
+	}
+	return 0, fmt.Errorf("Unknown resize filter: %s", s)
+}
+
+// byteToUnit 将 0 到 255 的像素值映射到 [0, 1]
+var byteToUnit = func() [256]float32 {
+	var toReturn [256]float32
+	for i := range toReturn {
+		toReturn[i] = float32(i) / 255.0
+	}
+	return toReturn
+}()
+
+// rowReader 将图像第 y 行（相对于图像左上角）的前 len(red) 个像素归一化
+// 到 [0, 1] 后分别写入 red、green 和 blue
+type rowReader func(y int, red, green, blue []float32)
+
+// newRowReader 返回读取 pic 中像素的 rowReader。*image.RGBA、*image.NRGBA
+// 和 *image.YCbCr 直接访问像素数据，避免 At() 在每个像素上的接口调用和内存
+// 分配；其他类型使用 At()。所有类型的结果都与 At().RGBA() 的高 8 位相同。
+func newRowReader(pic image.Image) rowReader {
+	origin := pic.Bounds().Min
+	switch p := pic.(type) {
+	case *image.RGBA:
+		return func(y int, red, green, blue []float32) {
+			i := p.PixOffset(origin.X, origin.Y+y)
+			for x := range red {
+				red[x] = byteToUnit[p.Pix[i]]
+				green[x] = byteToUnit[p.Pix[i+1]]
+				blue[x] = byteToUnit[p.Pix[i+2]]
+				i += 4
+			}
+		}
+	case *image.NRGBA:
+		// 与 color.NRGBA 的 RGBA() 相同，先乘以 alpha
+		premultiply := func(v, a uint8) uint8 {
+			c := uint32(v)
+			c |= c << 8
+			c *= uint32(a)
+			c /= 0xff
+			return uint8(c >> 8)
+		}
+		return func(y int, red, green, blue []float32) {
+			i := p.PixOffset(origin.X, origin.Y+y)
+			for x := range red {
+				a := p.Pix[i+3]
+				red[x] = byteToUnit[premultiply(p.Pix[i], a)]
+				green[x] = byteToUnit[premultiply(p.Pix[i+1], a)]
+				blue[x] = byteToUnit[premultiply(p.Pix[i+2], a)]
+				i += 4
+			}
+		}
+	case *image.YCbCr:
+		return func(y int, red, green, blue []float32) {
+			for x := range red {
+				yi := p.YOffset(origin.X+x, origin.Y+y)
+				ci := p.COffset(origin.X+x, origin.Y+y)
+				// 使用 color.YCbCr 值的 RGBA() 方法，与 At() 的结果相同，但
+				// 不会分配内存
+				r, g, b, _ := color.YCbCr{
+					Y:  p.Y[yi],
+					Cb: p.Cb[ci],
+					Cr: p.Cr[ci],
+				}.RGBA()
+				red[x] = byteToUnit[r>>8]
+				green[x] = byteToUnit[g>>8]
+				blue[x] = byteToUnit[b>>8]
+			}
+		}
+	}
+	return func(y int, red, green, blue []float32) {
+		for x := range red {
+			r, g, b, _ := pic.At(origin.X+x, origin.Y+y).RGBA()
+			red[x] = byteToUnit[r>>8]
+			green[x] = byteToUnit[g>>8]
+			blue[x] = byteToUnit[b>>8]
+		}
+	}
+}
+
+// bilinearSample 保存目标图像中一个坐标在原图中对应的两个相邻像素及第二个
+// 像素的权重
+type bilinearSample struct {
+	i0, i1 int
+	weight float32
+}
+
+// bilinearSamples 与 OpenCV 的 INTER_LINEAR 相同，按像素中心对齐计算目标
+// 图像中每个坐标的采样位置，超出原图的位置被限制到边缘
+func bilinearSamples(srcSize, dstSize int) []bilinearSample {
+	toReturn := make([]bilinearSample, dstSize)
+	scale := float64(srcSize) / float64(dstSize)
+	for i := range toReturn {
+		s := max((float64(i)+0.5)*scale-0.5, 0)
+		i0 := min(int(math.Floor(s)), srcSize-1)
+		toReturn[i] = bilinearSample{
+			i0:     i0,
+			i1:     min(i0+1, srcSize-1),
+			weight: float32(s - float64(i0)),
+		}
+	}
+	return toReturn
+}
+
+// resizeBilinear 将 pic 双线性缩放到 newWidth x newHeight，并写入宽度为
+// inputWidth 的输入张量通道中从 (offsetX, offsetY) 开始的区域。每个原图行
+// 只读取和转换一次。
+func resizeBilinear(pic image.Image, red, green, blue []float32,
+	inputWidth, offsetX, offsetY, newWidth, newHeight int) {
+	bounds := pic.Bounds()
+	srcWidth := bounds.Dx()
+	read := newRowReader(pic)
+	xSamples := bilinearSamples(srcWidth, newWidth)
+	ySamples := bilinearSamples(bounds.Dy(), newHeight)
+
+	// rows 缓存当前使用的上下两个原图行，每行依次为 R、G、B 三段。ySamples
+	// 是递增的，上一行的下方一行通常就是下一行的上方一行。
+	type cachedRow struct {
+		y    int
+		data []float32
+	}
+	rows := [2]cachedRow{
+		{y: -1, data: make([]float32, 3*srcWidth)},
+		{y: -1, data: make([]float32, 3*srcWidth)},
+	}
+	load := func(row *cachedRow, y int) {
+		row.y = y
+		read(y, row.data[:srcWidth], row.data[srcWidth:2*srcWidth],
+			row.data[2*srcWidth:])
+	}
+
+	channels := [3][]float32{red, green, blue}
+	for y, ys := range ySamples {
+		if rows[0].y != ys.i0 {
+			if rows[1].y == ys.i0 {
+				rows[0], rows[1] = rows[1], rows[0]
+			} else {
+				load(&rows[0], ys.i0)
+			}
+		}
+		if rows[1].y != ys.i1 {
+			load(&rows[1], ys.i1)
+		}
+		top, bottom := rows[0].data, rows[1].data
+		start := (y+offsetY)*inputWidth + offsetX
+		for c, channel := range channels {
+			t := top[c*srcWidth : (c+1)*srcWidth]
+			b := bottom[c*srcWidth : (c+1)*srcWidth]
+			dst := channel[start : start+newWidth]
+			for x, xs := range xSamples {
+				v0 := t[xs.i0] + (t[xs.i1]-t[xs.i0])*xs.weight
+				v1 := b[xs.i0] + (b[xs.i1]-b[xs.i0])*xs.weight
+				dst[x] = v0 + (v1-v0)*ys.weight
+			}
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+)
+
+// opaqueImage 隐藏图像的具体类型，使 newRowReader 只能使用 At()
+type opaqueImage struct {
+	image.Image
+}
+
+// newTestImages 返回内容相同的 RGBA、NRGBA 和 YCbCr 图像，NRGBA 图像带有
+// 半透明的像素，各图像的 Bounds() 都不从 (0, 0) 开始
+func newTestImages(width, height int) map[string]image.Image {
+	r := image.Rect(3, 5, 3+width, 5+height)
+	nrgba := image.NewNRGBA(r)
+	ycbcr := image.NewYCbCr(r, image.YCbCrSubsampleRatio420)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			nrgba.SetNRGBA(x, y, color.NRGBA{uint8(x * 7), uint8(y * 13),
+				uint8(x*y + 5), uint8(255 - (x+y)%3*60)})
+			yi := ycbcr.YOffset(x, y)
+			ci := ycbcr.COffset(x, y)
+			ycbcr.Y[yi] = uint8(x*11 + y)
+			ycbcr.Cb[ci] = uint8(x * 5)
+			ycbcr.Cr[ci] = uint8(255 - y*3)
+		}
+	}
+	rgba := image.NewRGBA(r)
+	draw.Draw(rgba, r, nrgba, r.Min, draw.Src)
+	return map[string]image.Image{
+		"RGBA":  rgba,
+		"NRGBA": nrgba,
+		"YCbCr": ycbcr,
+	}
+}
+
+func TestRowReaderFastPaths(t *testing.T) {
+	const width, height = 17, 9
+	for name, pic := range newTestImages(width, height) {
+		fast := newRowReader(pic)
+		generic := newRowReader(opaqueImage{pic})
+		expected := make([]float32, 3*width)
+		actual := make([]float32, 3*width)
+		for y := 0; y < height; y++ {
+			generic(y, expected[:width], expected[width:2*width],
+				expected[2*width:])
+			fast(y, actual[:width], actual[width:2*width], actual[2*width:])
+			for i := range expected {
+				if actual[i] != expected[i] {
+					t.Fatalf("%s: value %d of row %d differs from At(): "+
+						"%f vs %f", name, i, y, actual[i], expected[i])
+				}
+			}
+		}
+	}
+}
+
+func TestFillInputWithoutResizing(t *testing.T) {
+	pic := newTestImages(8, 4)["NRGBA"]
+	// 8x4 的图像在 8x8 的输入中 letterbox 后不需要缩放，上下各填充 2 行
+	data := make([]float32, 3*8*8)
+	transform := fillInput(pic, data, 8, 8, resizeLetterbox, filterBilinear)
+	if (transform.scaleX != 1) || (transform.padX != 0) ||
+		(transform.padY != 2) {
+		t.Fatalf("Got incorrect transform: %+v", transform)
+	}
+	gray := float32(letterboxGray) / 255.0
+	if (data[0] != gray) || (data[8*8-1] != gray) {
+		t.Errorf("Expected the padding to be gray, got %f and %f", data[0],
+			data[8*8-1])
+	}
+	r, _, _, _ := pic.At(3+5, 5+1).RGBA()
+	if data[(2+1)*8+5] != float32(r>>8)/255.0 {
+		t.Errorf("Expected pixel (5, 1) to be %f, got %f",
+			float32(r>>8)/255.0, data[(2+1)*8+5])
+	}
+}
+
+func TestResizeBilinear(t *testing.T) {
+	// 一行 4 个像素缩小到 2 个时，与 OpenCV 相同，每个输出为相邻两个像素的
+	// 平均值；放大到 8 个时，两端的像素被限制到边缘
+	pic := image.NewRGBA(image.Rect(0, 0, 4, 1))
+	for x, v := range []uint8{0, 100, 200, 250} {
+		pic.SetRGBA(x, 0, color.RGBA{v, v, v, 255})
+	}
+	tests := []struct {
+		width    int
+		expected []float32
+	}{
+		{2, []float32{50, 225}},
+		{8, []float32{0, 25, 75, 125, 175, 212.5, 237.5, 250}},
+	}
+	for _, test := range tests {
+		channel := make([]float32, test.width)
+		resizeBilinear(pic, channel, make([]float32, test.width),
+			make([]float32, test.width), test.width, 0, 0, test.width, 1)
+		for i, v := range test.expected {
+			if math.Abs(float64(channel[i]*255-v)) > 1e-3 {
+				t.Errorf("Expected pixel %d of the %d-pixel row to be %f, "+
+					"got %f", i, test.width, v, channel[i]*255)
+			}
+		}
+	}
+}
+
+func BenchmarkFillInput(b *testing.B) {
+	const inputSize = 640
+	images := newTestImages(1280, 720)
+	images["generic"] = opaqueImage{images["RGBA"]}
+	data := make([]float32, 3*inputSize*inputSize)
+	filters := []struct {
+		name   string
+		filter resizeFilter
+	}{
+		{"lanczos", filterLanczos3},
+		{"bilinear", filterBilinear},
+	}
+	for _, name := range []string{"generic", "RGBA", "NRGBA", "YCbCr"} {
+		for _, f := range filters {
+			b.Run(fmt.Sprintf("%s/%s", name, f.name), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					fillInput(images[name], data, inputSize, inputSize,
+						resizeLetterbox, f.filter)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkConvertPixels 只比较不同图像类型的像素转换，不包括缩放
+func BenchmarkConvertPixels(b *testing.B) {
+	const width, height = 640, 640
+	images := newTestImages(width, height)
+	images["generic"] = opaqueImage{images["RGBA"]}
+	data := make([]float32, 3*width*height)
+	for _, name := range []string{"generic", "RGBA", "NRGBA", "YCbCr"} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				fillInput(images[name], data, width, height, resizeLetterbox,
+					filterLanczos3)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// maskBounds 返回掩码在原始图像中覆盖的像素范围，即包含检测框的最小整数
+// 矩形
+func maskBounds(b *boundingBox) image.Rectangle {
+	return image.Rect(int(math.Floor(float64(b.x1))),
+		int(math.Floor(float64(b.y1))), int(math.Ceil(float64(b.x2))),
+		int(math.Ceil(float64(b.y2)))).Canon()
+}
+
+// computeMask 使用检测框的掩码系数和模型输出的掩码原型计算二值掩码。
+// 与 Ultralytics 相同，掩码为系数与原型的线性组合，双线性插值到原始图像
+// 坐标后以 0 为阈值（即 sigmoid 之后以 0.5 为阈值），并裁剪到检测框内。
+// 返回的掩码只覆盖检测框所在的区域。
+func computeMask(b *boundingBox, protos []float32, info *modelInfo,
+	t *inputTransform) *image.Alpha {
+	bounds := maskBounds(b)
+	toReturn := image.NewAlpha(bounds)
+	if bounds.Empty() || (len(b.maskCoeffs) != info.numMasks) {
+		return toReturn
+	}
+	mw, mh := info.maskWidth, info.maskHeight
+	// 掩码原型中一个格子对应的网络输入像素数，通常为 4
+	strideX := float32(info.inputWidth) / float32(mw)
+	strideY := float32(info.inputHeight) / float32(mh)
+	// toMask 将原始图像坐标转换为掩码原型中的坐标，格子的中心为整数坐标
+	toMask := func(x, y float32) (float32, float32) {
+		return (x*t.scaleX+t.padX)/strideX - 0.5,
+			(y*t.scaleY+t.padY)/strideY - 0.5
+	}
+
+	// 只计算检测框附近的掩码原型区域
+	mx1, my1 := toMask(b.x1, b.y1)
+	mx2, my2 := toMask(b.x2, b.y2)
+	region := image.Rect(int(math.Floor(float64(mx1))),
+		int(math.Floor(float64(my1))), int(math.Ceil(float64(mx2)))+1,
+		int(math.Ceil(float64(my2)))+1).Intersect(image.Rect(0, 0, mw, mh))
+	if region.Empty() {
+		return toReturn
+	}
+	logits := make([]float32, region.Dx()*region.Dy())
+	channelSize := mw * mh
+	for k, coeff := range b.maskCoeffs {
+		channel := protos[k*channelSize : (k+1)*channelSize]
+		i := 0
+		for y := region.Min.Y; y < region.Max.Y; y++ {
+			row := channel[y*mw : (y+1)*mw]
+			for x := region.Min.X; x < region.Max.X; x++ {
+				logits[i] += coeff * row[x]
+				i++
+			}
+		}
+	}
+
+	// logitAt 返回区域内 (x, y) 处的值，超出区域的坐标被限制到边缘
+	logitAt := func(x, y int) float32 {
+		x = max(min(x, region.Max.X-1), region.Min.X) - region.Min.X
+		y = max(min(y, region.Max.Y-1), region.Min.Y) - region.Min.Y
+		return logits[y*region.Dx()+x]
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		centerY := float32(y) + 0.5
+		if (centerY < b.y1) || (centerY > b.y2) {
+			continue
+		}
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			centerX := float32(x) + 0.5
+			if (centerX < b.x1) || (centerX > b.x2) {
+				continue
+			}
+			// 双线性插值
+			fx, fy := toMask(centerX, centerY)
+			x0 := int(math.Floor(float64(fx)))
+			y0 := int(math.Floor(float64(fy)))
+			wx := fx - float32(x0)
+			wy := fy - float32(y0)
+			top := logitAt(x0, y0)*(1-wx) + logitAt(x0+1, y0)*wx
+			bottom := logitAt(x0, y0+1)*(1-wx) + logitAt(x0+1, y0+1)*wx
+			if top*(1-wy)+bottom*wy > 0 {
+				toReturn.SetAlpha(x, y, color.Alpha{0xff})
+			}
+		}
+	}
+	return toReturn
+}
+
+// cocoRLE 是 COCO 格式的压缩游程编码 (RLE) 掩码
+type cocoRLE struct {
+	Size   [2]int `json:"size"`   // [高度, 宽度]
+	Counts string `json:"counts"` // 与 pycocotools 兼容的压缩字符串
+}
+
+// encodeRLE 将覆盖部分图像的 mask 编码为 width x height 整张图像的 COCO
+// RLE。与 pycocotools 相同，像素按列优先顺序排列，第一个游程总是 0 的数量。
+func encodeRLE(mask *image.Alpha, width, height int) *cocoRLE {
+	var counts []int64
+	current := uint8(0)
+	var run int64
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			v := uint8(0)
+			if mask.AlphaAt(x, y).A != 0 {
+				v = 1
+			}
+			if v != current {
+				counts = append(counts, run)
+				run = 0
+				current = v
+			}
+			run++
+		}
+	}
+	counts = append(counts, run)
+	return &cocoRLE{
+		Size:   [2]int{height, width},
+		Counts: compressRLECounts(counts),
+	}
+}
+
+// compressRLECounts 与 pycocotools 的 rleToString 相同：从第三个游程起存储
+// 与前面第二个游程的差值，每 5 位编码为一个可打印字符。
+func compressRLECounts(counts []int64) string {
+	var toReturn []byte
+	for i, count := range counts {
+		x := count
+		if i > 2 {
+			x -= counts[i-2]
+		}
+		more := true
+		for more {
+			c := byte(x & 0x1f)
+			x >>= 5
+			if (c & 0x10) != 0 {
+				more = x != -1
+			} else {
+				more = x != 0
+			}
+			if more {
+				c |= 0x20
+			}
+			toReturn = append(toReturn, c+48)
+		}
+	}
+	return string(toReturn)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestComputeMask(t *testing.T) {
+	info := &modelInfo{
+		inputWidth:  64,
+		inputHeight: 64,
+		numMasks:    1,
+		maskWidth:   16,
+		maskHeight:  16,
+	}
+	// 掩码原型的左半部分为正，右半部分为负
+	protos := make([]float32, 16*16)
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			protos[y*16+x] = -1
+			if x < 8 {
+				protos[y*16+x] = 1
+			}
+		}
+	}
+	transform := inputTransform{scaleX: 1, scaleY: 1}
+	b := newTestBox(0, 0.9, 0, 0, 64, 64)
+	b.maskCoeffs = []float32{1}
+	mask := computeMask(&b, protos, info, &transform)
+	for x := 0; x < 64; x++ {
+		expected := x < 32
+		if (mask.AlphaAt(x, 10).A != 0) != expected {
+			t.Errorf("Incorrect mask value at x = %d", x)
+		}
+	}
+
+	// 掩码应被裁剪到检测框内
+	b = newTestBox(0, 0.9, 0, 8, 20, 40)
+	b.maskCoeffs = []float32{1}
+	mask = computeMask(&b, protos, info, &transform)
+	if mask.Bounds() != image.Rect(0, 8, 20, 40) {
+		t.Errorf("Incorrect mask bounds: %s", mask.Bounds())
+	}
+	if (mask.AlphaAt(19, 20).A == 0) || (mask.AlphaAt(25, 20).A != 0) ||
+		(mask.AlphaAt(10, 50).A != 0) {
+		t.Errorf("The mask wasn't cropped to the box")
+	}
+}
+
+func TestEncodeRLE(t *testing.T) {
+	mask := image.NewAlpha(image.Rect(0, 0, 3, 2))
+	mask.SetAlpha(1, 0, color.Alpha{0xff})
+	// 按列优先顺序为 0, 0, 1, 0, 0, 0，游程为 [2, 1, 3]
+	rle := encodeRLE(mask, 3, 2)
+	if (rle.Size != [2]int{2, 3}) || (rle.Counts != "213") {
+		t.Errorf("Got incorrect RLE: %+v", rle)
+	}
+	// 与 pycocotools 的结果比较，包括多字节编码和负的差值
+	counts := compressRLECounts([]int64{100, 5, 200, 2})
+	if counts != "T35X6M" {
+		t.Errorf("Got incorrect compressed counts: %s", counts)
+	}
+	// 掩码以 1 开头时，第一个游程为 0
+	mask = image.NewAlpha(image.Rect(0, 0, 1, 2))
+	mask.SetAlpha(0, 0, color.Alpha{0xff})
+	rle = encodeRLE(mask, 1, 2)
+	if rle.Counts != "011" {
+		t.Errorf("Got incorrect RLE for a mask starting with 1: %+v", rle)
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// detectionServer 是 serve 子命令的 HTTP 服务，使用一组 detector 并发处理
+// 请求。每个 detector 拥有自己的 ModelSession，同一时间只被一个请求使用。
+type detectionServer struct {
+	// 空闲的 detector
+	pool chan *detector
+	// 创建的所有 detector，关闭时释放
+	detectors []*detector
+	// 第一个 detector，其阈值等设置作为请求参数的默认值
+	defaults *detector
+	// 所有会话创建完成且尚未开始关闭时为 true
+	ready atomic.Bool
+	// -zones 指定的区域配置，为 nil 时不统计区域
+	zones *analyticsConfig
+	// 请求正文的最大字节数
+	maxUploadBytes int64
+	mutex          sync.Mutex
+}
+
+// newDetectionServer 返回尚未加载模型的服务，最多可以容纳 sessions 个
+// detector
+func newDetectionServer(sessions int, maxUploadBytes int64) *detectionServer {
+	return &detectionServer{
+		pool:           make(chan *detector, sessions),
+		maxUploadBytes: maxUploadBytes,
+	}
+}
+
+// addDetector 将 d 加入空闲的 detector 中
+func (s *detectionServer) addDetector(d *detector) {
+	s.mutex.Lock()
+	s.detectors = append(s.detectors, d)
+	s.mutex.Unlock()
+	s.pool <- d
+}
+
+// loadSessions 创建 sessions 个 detector。第一个 detector 读取模型信息，
+// 其余的使用相同的模型信息只创建会话。全部创建完成后服务才会就绪。
+func (s *detectionServer) loadSessions(f *detectorFlags, sessions int) error {
+	first, e := f.newDetector()
+	if e != nil {
+		return e
+	}
+	s.defaults = first
+	s.addDetector(first)
+	for i := 1; i < sessions; i++ {
+		session, e := initSession(&f.session, first.session.Info)
+		if e != nil {
+			return fmt.Errorf("Error creating session %d: %w", i, e)
+		}
+		d := *first
+		d.session = session
+		s.addDetector(&d)
+	}
+	s.ready.Store(true)
+	return nil
+}
+
+// Destroy 等待所有 detector 回到空闲状态后将其释放。调用前必须确保不会再
+// 有新的检测请求。
+func (s *detectionServer) Destroy() {
+	s.ready.Store(false)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for range s.detectors {
+		<-s.pool
+	}
+	for _, d := range s.detectors {
+		d.Destroy()
+	}
+	s.detectors = nil
+}
+
+// handler 返回服务的路由
+func (s *detectionServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/detect", s.handleDetect)
+	mux.HandleFunc("/healthz", s.handleHealth)
+	mux.HandleFunc("/readyz", s.handleReady)
+	return mux
+}
+
+// writeJSON 以 status 状态码输出 JSON 格式的 v
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// jsonError 是请求失败时返回的 JSON 文档
+type jsonError struct {
+	Error string `json:"error"`
+}
+
+// writeError 以 status 状态码输出错误信息
+func writeError(w http.ResponseWriter, status int, format string,
+	args ...any) {
+	writeJSON(w, status, &jsonError{Error: fmt.Sprintf(format, args...)})
+}
+
+// handleHealth 只要进程能够响应请求就返回 200
+func (s *detectionServer) handleHealth(w http.ResponseWriter,
+	r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReady 在模型加载完成、可以处理检测请求时返回 200，否则返回 503
+func (s *detectionServer) handleReady(w http.ResponseWriter,
+	r *http.Request) {
+	if !s.ready.Load() {
+		writeJSON(w, http.StatusServiceUnavailable,
+			map[string]string{"status": "not ready"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// detectParams 是检测请求中可以通过查询参数覆盖的设置
+type detectParams struct {
+	confidence float32
+	nms        nmsOptions
+}
+
+// parseDetectParams 以 d 的设置为默认值解析查询参数 confidence、iou、
+// max_detections 和 class_agnostic
+func parseDetectParams(query map[string][]string,
+	d *detector) (*detectParams, error) {
+	toReturn := &detectParams{
+		confidence: d.confidenceThreshold,
+		nms:        *d.nms,
+	}
+	get := func(name string) (string, bool) {
+		values := query[name]
+		if len(values) == 0 {
+			return "", false
+		}
+		return values[0], true
+	}
+	parseUnit := func(name string, dst *float32) error {
+		v, ok := get(name)
+		if !ok {
+			return nil
+		}
+		f, e := strconv.ParseFloat(v, 32)
+		if (e != nil) || (f < 0) || (f > 1) {
+			return fmt.Errorf("%s must be a number between 0 and 1", name)
+		}
+		*dst = float32(f)
+		return nil
+	}
+	e := parseUnit("confidence", &toReturn.confidence)
+	if e != nil {
+		return nil, e
+	}
+	toReturn.nms.scoreThreshold = toReturn.confidence
+	e = parseUnit("iou", &toReturn.nms.iouThreshold)
+	if e != nil {
+		return nil, e
+	}
+	if v, ok := get("max_detections"); ok {
+		n, e := strconv.Atoi(v)
+		if (e != nil) || (n < 0) {
+			return nil, fmt.Errorf("max_detections must be a non-negative " +
+				"integer")
+		}
+		toReturn.nms.maxDetections = n
+	}
+	if v, ok := get("class_agnostic"); ok {
+		b, e := strconv.ParseBool(v)
+		if e != nil {
+			return nil, fmt.Errorf("class_agnostic must be true or false")
+		}
+		toReturn.nms.classAgnostic = b
+	}
+	return toReturn, nil
+}
+
+// readRequestImage 从 multipart 表单的 image 字段或整个请求正文中读取并
+// 解码图片，同时返回文件名（没有时为空）
+func readRequestImage(r *http.Request) (image.Image, string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var src io.Reader
+	filename := ""
+	if mediaType != "multipart/form-data" {
+		// 先读取整个正文，使超过大小限制的请求总是被发现，而不是在无法识别
+		// 格式时提前失败
+		data, e := io.ReadAll(r.Body)
+		if e != nil {
+			return nil, "", fmt.Errorf("Error reading request body: %w", e)
+		}
+		src = bytes.NewReader(data)
+	} else {
+		f, header, e := r.FormFile("image")
+		if e != nil {
+			return nil, "", fmt.Errorf("Error reading the \"image\" form "+
+				"field: %w", e)
+		}
+		defer f.Close()
+		src = f
+		filename = header.Filename
+	}
+	pic, _, e := image.Decode(src)
+	if e != nil {
+		return nil, "", fmt.Errorf("Error decoding image: %w", e)
+	}
+	return pic, filename, nil
+}
+
+// handleDetect 处理 POST /v1/detect，对请求中的图片运行检测并以 "json"
+// 输出格式相同的 JSON 文档返回结果
+func (s *detectionServer) handleDetect(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "Use POST to submit an "+
+			"image")
+		return
+	}
+	if !s.ready.Load() {
+		writeError(w, http.StatusServiceUnavailable, "The model isn't "+
+			"loaded yet")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxUploadBytes)
+	// 在占用会话之前完成所有可能失败的解析
+	start := time.Now()
+	pic, filename, e := readRequestImage(r)
+	if e != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(e, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "The image is "+
+				"larger than %d bytes", s.maxUploadBytes)
+			return
+		}
+		writeError(w, http.StatusBadRequest, "%s", e)
+		return
+	}
+	decodeTime := time.Since(start)
+	params, e := parseDetectParams(r.URL.Query(), s.defaults)
+	if e != nil {
+		writeError(w, http.StatusBadRequest, "%s", e)
+		return
+	}
+
+	// 等待空闲的会话
+	var d *detector
+	select {
+	case d = <-s.pool:
+	case <-r.Context().Done():
+		return
+	}
+	// 使用请求的阈值，但共享 d 的会话
+	requestDetector := *d
+	requestDetector.confidenceThreshold = params.confidence
+	requestDetector.nms = &params.nms
+	boxes, timing, e := requestDetector.detect(pic)
+	s.pool <- d
+	if e != nil {
+		writeError(w, http.StatusInternalServerError, "%s", e)
+		return
+	}
+	timing.preprocess += decodeTime
+	bounds := pic.Bounds().Canon()
+	var analytics *jsonAnalytics
+	if s.zones != nil {
+		analytics = s.zones.imageAnalytics(boxes)
+	}
+	writeJSON(w, http.StatusOK, newJSONImageResult(&imageResult{
+		path:      filename,
+		width:     bounds.Dx(),
+		height:    bounds.Dy(),
+		boxes:     boxes,
+		timing:    timing,
+		analytics: analytics,
+	}))
+}
+
+// runServe 实现 serve 子命令，在收到 SIGINT 或 SIGTERM 后停止接受新的
+// 连接，等待正在处理的请求完成，然后释放所有会话
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	detectorSettings := registerDetectorFlags(fs, 0.5, 300)
+	var listenAddress string
+	var sessions int
+	var maxUploadMB int
+	var shutdownTimeout time.Duration
+	fs.StringVar(&listenAddress, "listen", ":8080",
+		"The address on which to serve HTTP requests.")
+	fs.IntVar(&sessions, "sessions", 2,
+		"The number of ORT sessions, which is the number of requests that "+
+			"can run detection at the same time.")
+	fs.IntVar(&maxUploadMB, "max_upload_mb", 32,
+		"The maximum size of an uploaded image, in megabytes.")
+	fs.DurationVar(&shutdownTimeout, "shutdown_timeout", 30*time.Second,
+		"How long to wait for in-flight requests when shutting down.")
+	fs.Parse(args)
+	if sessions < 1 {
+		fmt.Println("The number of sessions must be at least 1.")
+		return 1
+	}
+	if maxUploadMB < 1 {
+		fmt.Println("The maximum upload size must be at least 1 MB.")
+		return 1
+	}
+	zones, e := detectorSettings.loadZones(false)
+	if e != nil {
+		fmt.Printf("%s\n", e)
+		return 1
+	}
+
+	s := newDetectionServer(sessions, int64(maxUploadMB)<<20)
+	s.zones = zones
+	server := &http.Server{
+		Addr:    listenAddress,
+		Handler: s.handler(),
+	}
+	// 先开始监听，使 /healthz 和 /readyz 在加载模型时就可以访问
+	serveErrors := make(chan error, 1)
+	go func() {
+		serveErrors <- server.ListenAndServe()
+	}()
+	log.Printf("Listening on %s\n", listenAddress)
+	loadErrors := make(chan error, 1)
+	go func() {
+		loadErrors <- s.loadSessions(detectorSettings, sessions)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	exitCode := 0
+	waitLoop := true
+	for waitLoop {
+		select {
+		case e := <-loadErrors:
+			// 加载结束后不再等待 loadErrors
+			loadErrors = nil
+			if e != nil {
+				log.Printf("Error loading the model: %s\n", e)
+				exitCode = 1
+				waitLoop = false
+			} else {
+				log.Printf("Loaded %s with %d sessions: %s\n",
+					detectorSettings.session.modelPath, sessions,
+					s.defaults.session.Info)
+			}
+		case e := <-serveErrors:
+			log.Printf("Error serving HTTP requests: %s\n", e)
+			exitCode = 1
+			waitLoop = false
+		case sig := <-signals:
+			log.Printf("Received %s, shutting down\n", sig)
+			waitLoop = false
+		}
+	}
+
+	// 先停止接受新的检测请求，再等待正在处理的请求完成。超时后仍在运行
+	// 的检测会在 Destroy() 中等待其归还会话。
+	s.ready.Store(false)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	e = server.Shutdown(ctx)
+	if e != nil {
+		log.Printf("Error shutting down the HTTP server: %s\n", e)
+		exitCode = 1
+	}
+	// 模型仍在加载时需要等待加载结束，才能释放已经创建的会话
+	if loadErrors != nil {
+		<-loadErrors
+	}
+	s.Destroy()
+	return exitCode
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestServer 返回没有任何会话的服务。ready 为 true 时服务被标记为就绪，
+// 但检测请求在解析成功后会一直等待会话，因此只能测试失败的请求。
+func newTestServer(ready bool) *detectionServer {
+	s := newDetectionServer(1, 1024)
+	s.defaults = &detector{
+		confidenceThreshold: 0.5,
+		nms:                 &nmsOptions{iouThreshold: 0.7, maxDetections: 300},
+	}
+	s.ready.Store(ready)
+	return s
+}
+
+// doRequest 向 s 发送请求并返回响应和解析后的 JSON 正文
+func doRequest(t *testing.T, s *detectionServer,
+	r *http.Request) (*httptest.ResponseRecorder, map[string]any) {
+	w := httptest.NewRecorder()
+	s.handler().ServeHTTP(w, r)
+	var body map[string]any
+	e := json.Unmarshal(w.Body.Bytes(), &body)
+	if e != nil {
+		t.Fatalf("Error parsing response %q: %s", w.Body.String(), e)
+	}
+	return w, body
+}
+
+func TestServerHealthAndReadiness(t *testing.T) {
+	s := newTestServer(false)
+	w, _ := doRequest(t, s, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected /healthz to return 200, got %d", w.Code)
+	}
+	w, _ = doRequest(t, s, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz to return 503 before loading, got %d",
+			w.Code)
+	}
+	w, _ = doRequest(t, s, httptest.NewRequest("POST", "/v1/detect",
+		strings.NewReader("data")))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /v1/detect to return 503 before loading, got %d",
+			w.Code)
+	}
+	s.ready.Store(true)
+	w, _ = doRequest(t, s, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected /readyz to return 200, got %d", w.Code)
+	}
+}
+
+func TestServerDetectErrors(t *testing.T) {
+	s := newTestServer(true)
+	multipartBody := &bytes.Buffer{}
+	mw := multipart.NewWriter(multipartBody)
+	part, _ := mw.CreateFormFile("image", "car.png")
+	part.Write([]byte("not an image"))
+	mw.Close()
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		expected    int
+	}{
+		{"GET", "GET", "", "", http.StatusMethodNotAllowed},
+		{"raw body", "POST", "image/png", "not an image",
+			http.StatusBadRequest},
+		{"multipart", "POST", mw.FormDataContentType(),
+			multipartBody.String(), http.StatusBadRequest},
+		{"missing field", "POST", "multipart/form-data; boundary=x",
+			"--x--\r\n", http.StatusBadRequest},
+		{"too large", "POST", "image/png", strings.Repeat("x", 2048),
+			http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/v1/detect",
+			strings.NewReader(test.body))
+		if test.contentType != "" {
+			r.Header.Set("Content-Type", test.contentType)
+		}
+		w, body := doRequest(t, s, r)
+		if w.Code != test.expected {
+			t.Errorf("%s: expected status %d, got %d (%v)", test.name,
+				test.expected, w.Code, body)
+		}
+		if _, ok := body["error"]; !ok {
+			t.Errorf("%s: expected an error message, got %v", test.name,
+				body)
+		}
+	}
+}
+
+func TestParseDetectParams(t *testing.T) {
+	s := newTestServer(true)
+	params, e := parseDetectParams(map[string][]string{
+		"confidence":     {"0.25"},
+		"iou":            {"0.45"},
+		"max_detections": {"10"},
+		"class_agnostic": {"true"},
+	}, s.defaults)
+	if e != nil {
+		t.Fatalf("Error parsing parameters: %s", e)
+	}
+	if (params.confidence != 0.25) || (params.nms.scoreThreshold != 0.25) ||
+		(params.nms.iouThreshold != 0.45) || (params.nms.maxDetections != 10) ||
+		!params.nms.classAgnostic {
+		t.Errorf("Got incorrect parameters: %+v", params)
+	}
+	// 没有指定的参数使用默认值，且不修改默认值
+	params, e = parseDetectParams(nil, s.defaults)
+	if (e != nil) || (params.confidence != 0.5) ||
+		(params.nms.iouThreshold != 0.7) || (params.nms.maxDetections != 300) {
+		t.Errorf("Expected the default parameters, got %+v (%v)", params, e)
+	}
+	for _, query := range []map[string][]string{
+		{"confidence": {"1.5"}},
+		{"iou": {"abc"}},
+		{"max_detections": {"-1"}},
+		{"class_agnostic": {"maybe"}},
+	} {
+		_, e = parseDetectParams(query, s.defaults)
+		if e == nil {
+			t.Errorf("Expected an error for %v", query)
+		}
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/8ff/prettyTimer"
+)
+
+// maxMJPEGFrameSize 是 MJPEG 流中单帧的最大字节数，用于在输入损坏时避免
+// 无限制地占用内存
+const maxMJPEGFrameSize = 64 << 20
+
+// mjpegReader 从首尾相接的 JPEG 图片流（MJPEG）中逐帧读取 JPEG 数据。它按
+// JPEG 的段结构解析，因此 EXIF 缩略图中的 EOI 标记不会被误认为帧的结束；
+// 帧之间的其他数据（例如 HTTP multipart 的分隔符）会被跳过。
+type mjpegReader struct {
+	r *bufio.Reader
+}
+
+func newMJPEGReader(r io.Reader) *mjpegReader {
+	return &mjpegReader{r: bufio.NewReaderSize(r, 1<<16)}
+}
+
+// unexpectedEOF 将帧中间遇到的 io.EOF 转换为 io.ErrUnexpectedEOF
+func unexpectedEOF(e error) error {
+	if e == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return e
+}
+
+// readMarker 读取一个标记（跳过填充的 0xFF），将其追加到 frame 并返回标记
+// 的第二个字节
+func (m *mjpegReader) readMarker(frame *[]byte) (byte, error) {
+	b, e := m.r.ReadByte()
+	if e != nil {
+		return 0, unexpectedEOF(e)
+	}
+	if b != 0xff {
+		return 0, fmt.Errorf("Expected a JPEG marker, got 0x%02x", b)
+	}
+	for {
+		b, e = m.r.ReadByte()
+		if e != nil {
+			return 0, unexpectedEOF(e)
+		}
+		if b != 0xff {
+			break
+		}
+	}
+	*frame = append(*frame, 0xff, b)
+	return b, nil
+}
+
+// readEntropyCodedData 读取 SOS 段之后的压缩数据并追加到 frame，直到遇到
+// 下一个标记。数据中的 0xFF 00 和 RST 标记属于压缩数据本身。
+func (m *mjpegReader) readEntropyCodedData(frame *[]byte) error {
+	for {
+		next, e := m.r.Peek(1)
+		if e != nil {
+			return unexpectedEOF(e)
+		}
+		if next[0] != 0xff {
+			*frame = append(*frame, next[0])
+			m.r.Discard(1)
+			continue
+		}
+		next, e = m.r.Peek(2)
+		if e != nil {
+			return unexpectedEOF(e)
+		}
+		if (next[1] != 0x00) && ((next[1] < 0xd0) || (next[1] > 0xd7)) {
+			// 下一个标记由 readMarker 读取
+			return nil
+		}
+		*frame = append(*frame, next[0], next[1])
+		m.r.Discard(2)
+	}
+}
+
+// nextFrame 返回下一帧完整的 JPEG 数据。流结束时返回 io.EOF，帧不完整时
+// 返回 io.ErrUnexpectedEOF。
+func (m *mjpegReader) nextFrame() ([]byte, error) {
+	// 跳过 SOI 标记之前的数据
+	for {
+		b, e := m.r.ReadByte()
+		if e != nil {
+			return nil, e
+		}
+		if b != 0xff {
+			continue
+		}
+		next, e := m.r.Peek(1)
+		if e != nil {
+			return nil, e
+		}
+		if next[0] == 0xd8 {
+			m.r.Discard(1)
+			break
+		}
+	}
+	frame := []byte{0xff, 0xd8}
+	for {
+		if len(frame) > maxMJPEGFrameSize {
+			return nil, fmt.Errorf("JPEG frame is larger than %d bytes",
+				maxMJPEGFrameSize)
+		}
+		marker, e := m.readMarker(&frame)
+		if e != nil {
+			return nil, e
+		}
+		// EOI
+		if marker == 0xd9 {
+			return frame, nil
+		}
+		// 没有长度的独立标记：TEM 和 RST0 到 RST7
+		if (marker == 0x01) || ((marker >= 0xd0) && (marker <= 0xd7)) {
+			continue
+		}
+		var lengthBytes [2]byte
+		_, e = io.ReadFull(m.r, lengthBytes[:])
+		if e != nil {
+			return nil, unexpectedEOF(e)
+		}
+		length := int(lengthBytes[0])<<8 | int(lengthBytes[1])
+		if length < 2 {
+			return nil, fmt.Errorf("Invalid JPEG segment length %d", length)
+		}
+		frame = append(frame, lengthBytes[:]...)
+		start := len(frame)
+		frame = append(frame, make([]byte, length-2)...)
+		_, e = io.ReadFull(m.r, frame[start:])
+		if e != nil {
+			return nil, unexpectedEOF(e)
+		}
+		// SOS 段之后是压缩数据
+		if marker == 0xda {
+			e = m.readEntropyCodedData(&frame)
+			if e != nil {
+				return nil, e
+			}
+		}
+	}
+}
+
+// frameSource 依次返回视频中的每一帧
+type frameSource interface {
+	// next 返回下一帧及其来源（文件名或流的路径），没有更多帧时返回
+	// io.EOF
+	next() (image.Image, string, error)
+}
+
+// mjpegFrameSource 从 MJPEG 流中读取帧
+type mjpegFrameSource struct {
+	reader *mjpegReader
+	name   string
+}
+
+func (s *mjpegFrameSource) next() (image.Image, string, error) {
+	data, e := s.reader.nextFrame()
+	if e != nil {
+		if e != io.EOF {
+			e = fmt.Errorf("Error reading frame from %s: %w", s.name, e)
+		}
+		return nil, s.name, e
+	}
+	pic, e := jpeg.Decode(bytes.NewReader(data))
+	if e != nil {
+		return nil, s.name, fmt.Errorf("Error decoding frame from %s: %w",
+			s.name, e)
+	}
+	return pic, s.name, nil
+}
+
+// directoryFrameSource 按编号顺序读取目录中的帧图片
+type directoryFrameSource struct {
+	paths []string
+}
+
+func (s *directoryFrameSource) next() (image.Image, string, error) {
+	if len(s.paths) == 0 {
+		return nil, "", io.EOF
+	}
+	path := s.paths[0]
+	s.paths = s.paths[1:]
+	pic, e := loadImageFile(path)
+	return pic, path, e
+}
+
+// frameNumberPattern 匹配文件名中的最后一个数字，例如 frame_0012.jpg 中的
+// 0012
+var frameNumberPattern = regexp.MustCompile(`(\d+)\D*$`)
+
+// sortFramePaths 按文件名中的最后一个数字排序帧图片，使 frame_2.jpg 排在
+// frame_10.jpg 之前。没有数字的文件排在最后，并按文件名排序。
+func sortFramePaths(paths []string) {
+	number := func(path string) int64 {
+		m := frameNumberPattern.FindStringSubmatch(filepath.Base(path))
+		if m == nil {
+			return -1
+		}
+		n, e := strconv.ParseInt(m[1], 10, 64)
+		if e != nil {
+			return -1
+		}
+		return n
+	}
+	sort.SliceStable(paths, func(i, j int) bool {
+		a, b := number(paths[i]), number(paths[j])
+		if (a < 0) != (b < 0) {
+			return b < 0
+		}
+		if a != b {
+			return a < b
+		}
+		return filepath.Base(paths[i]) < filepath.Base(paths[j])
+	})
+}
+
+// openFrameSource 根据 input 返回帧的来源："-" 表示从 stdin 读取 MJPEG 流，
+// 目录表示按编号排列的帧图片，其他路径表示 MJPEG 文件。返回的 io.Closer
+// 不为 nil 时，必须在不再需要时关闭。
+func openFrameSource(input string) (frameSource, io.Closer, error) {
+	if input == "-" {
+		return &mjpegFrameSource{
+			reader: newMJPEGReader(os.Stdin),
+			name:   "stdin",
+		}, nil, nil
+	}
+	info, e := os.Stat(input)
+	if e != nil {
+		return nil, nil, fmt.Errorf("Error opening %s: %w", input, e)
+	}
+	if info.IsDir() {
+		paths, e := collectImagePaths([]string{input})
+		if e != nil {
+			return nil, nil, e
+		}
+		sortFramePaths(paths)
+		return &directoryFrameSource{paths: paths}, nil, nil
+	}
+	f, e := os.Open(input)
+	if e != nil {
+		return nil, nil, fmt.Errorf("Error opening %s: %w", input, e)
+	}
+	return &mjpegFrameSource{
+		reader: newMJPEGReader(f),
+		name:   input,
+	}, f, nil
+}
+
+// jsonFrameResult 是 stream 子命令为每一帧输出的 JSON 文档，在 "json" 格式
+// 的基础上增加了帧编号和时间戳
+type jsonFrameResult struct {
+	Frame int `json:"frame"`
+	// 帧在视频中的时间，单位为秒，由帧编号和 -fps 计算
+	Timestamp float64 `json:"timestamp"`
+	*jsonImageResult
+}
+
+// runStream 实现 stream 子命令，对视频的每一帧运行检测，每帧输出一行 JSON，
+// 并可以把标注后的帧写为 MJPEG 流
+func runStream(args []string) int {
+	fs := flag.NewFlagSet("stream", flag.ExitOnError)
+	detectorSettings := registerDetectorFlags(fs, 0.5, 300)
+	var input string
+	var fps float64
+	var maxFrames int
+	var annotatedOutput string
+	var jpegQuality int
+	var useTracking bool
+	var trackLowConfidence float64
+	var trackIoU float64
+	var trackBuffer int
+	fs.StringVar(&input, "input", "-",
+		"An MJPEG file (concatenated JPEG frames), a directory of numbered "+
+			"frame images, or \"-\" to read an MJPEG stream from stdin.")
+	fs.Float64Var(&fps, "fps", 30,
+		"The frame rate used to compute each frame's timestamp.")
+	fs.IntVar(&maxFrames, "max_frames", 0,
+		"If positive, stop after this many frames.")
+	fs.StringVar(&annotatedOutput, "annotated_output", "",
+		"If set, an MJPEG stream of the frames with their detections drawn "+
+			"is written to this file.")
+	fs.IntVar(&jpegQuality, "jpeg_quality", 80,
+		"The JPEG quality of the annotated frames, from 1 to 100.")
+	fs.BoolVar(&useTracking, "track", false,
+		"If set, track objects across frames with ByteTrack and report "+
+			"each detection's track ID. Detections with a confidence "+
+			"below -confidence don't start new tracks.")
+	fs.Float64Var(&trackLowConfidence, "track_low_confidence", 0.1,
+		"When tracking, detections with a confidence between this and "+
+			"-confidence can still continue existing tracks.")
+	fs.Float64Var(&trackIoU, "track_iou", 0.2,
+		"When tracking, the minimum IoU between a track's predicted box "+
+			"and a detection for them to be matched.")
+	fs.IntVar(&trackBuffer, "track_buffer", 30,
+		"When tracking, the number of frames for which a lost track is "+
+			"kept, so that it keeps its ID if the object reappears.")
+	fs.Parse(args)
+	if fps <= 0 {
+		fmt.Fprintln(os.Stderr, "The frame rate must be positive.")
+		return 1
+	}
+	if (jpegQuality < 1) || (jpegQuality > 100) {
+		fmt.Fprintln(os.Stderr, "The JPEG quality must be between 1 and 100.")
+		return 1
+	}
+	var tracker *byteTracker
+	if useTracking {
+		var e error
+		tracker, e = newByteTracker(trackerConfig{
+			highThreshold: float32(detectorSettings.confidenceThreshold),
+			lowThreshold:  float32(trackLowConfidence),
+			minIoU:        float32(trackIoU),
+			buffer:        trackBuffer,
+		})
+		if e != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", e)
+			return 1
+		}
+	}
+	var analytics *zoneAnalytics
+	config, e := detectorSettings.loadZones(true)
+	if e != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", e)
+		return 1
+	}
+	if config != nil {
+		if (len(config.Lines) != 0) && (tracker == nil) {
+			fmt.Fprintln(os.Stderr, "Counting line crossings requires -track.")
+			return 1
+		}
+		analytics = newZoneAnalytics(config, trackBuffer)
+	}
+	source, closer, e := openFrameSource(input)
+	if e != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", e)
+		return 1
+	}
+	if closer != nil {
+		defer closer.Close()
+	}
+	var annotatedFile *os.File
+	var annotated *bufio.Writer
+	if annotatedOutput != "" {
+		annotatedFile, e = os.Create(annotatedOutput)
+		if e != nil {
+			fmt.Fprintf(os.Stderr, "Error creating %s: %s\n", annotatedOutput,
+				e)
+			return 1
+		}
+		// 只用于提前返回的情况，正常结束时在下面检查关闭文件的错误
+		defer annotatedFile.Close()
+		annotated = bufio.NewWriter(annotatedFile)
+	}
+
+	d, e := detectorSettings.newDetector()
+	if e != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", e)
+		return 1
+	}
+	defer d.Destroy()
+	fmt.Fprintf(os.Stderr, "Loaded %s: %s\n",
+		detectorSettings.session.modelPath, d.session.Info)
+	if tracker != nil {
+		// 低置信度的检测框也需要交给跟踪器
+		d.confidenceThreshold = tracker.config.lowThreshold
+		d.nms.scoreThreshold = tracker.config.lowThreshold
+	}
+
+	// stdout 只包含每帧的检测结果
+	encoder := json.NewEncoder(os.Stdout)
+	timingStats := prettyTimer.NewTimingStats()
+	start := time.Now()
+	frameIndex := 0
+	for (maxFrames <= 0) || (frameIndex < maxFrames) {
+		pic, name, e := source.next()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+		if e != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", e)
+			return 1
+		}
+		boxes, timing, e := d.detect(pic)
+		if e != nil {
+			fmt.Fprintf(os.Stderr, "Error running detection on frame %d: "+
+				"%s\n", frameIndex, e)
+			return 1
+		}
+		if tracker != nil {
+			boxes = tracker.update(boxes)
+		}
+		var summary *jsonAnalytics
+		if analytics != nil {
+			summary = analytics.update(boxes)
+		}
+		timingStats.RecordTiming(timing.inference)
+		bounds := pic.Bounds().Canon()
+		e = encoder.Encode(&jsonFrameResult{
+			Frame:     frameIndex,
+			Timestamp: float64(frameIndex) / fps,
+			jsonImageResult: newJSONImageResult(&imageResult{
+				path:      name,
+				imageID:   frameIndex,
+				width:     bounds.Dx(),
+				height:    bounds.Dy(),
+				boxes:     boxes,
+				timing:    timing,
+				analytics: summary,
+			}),
+		})
+		if e != nil {
+			fmt.Fprintf(os.Stderr, "Error writing results: %s\n", e)
+			return 1
+		}
+		if annotated != nil {
+			frame := drawDetections(pic, boxes)
+			if analytics != nil {
+				drawAnalytics(frame, analytics.config, summary)
+			}
+			e = jpeg.Encode(annotated, frame,
+				&jpeg.Options{Quality: jpegQuality})
+			if e != nil {
+				fmt.Fprintf(os.Stderr, "Error writing annotated frame: %s\n",
+					e)
+				return 1
+			}
+		}
+		frameIndex++
+	}
+	if annotated != nil {
+		e = annotated.Flush()
+		if e != nil {
+			fmt.Fprintf(os.Stderr, "Error writing %s: %s\n", annotatedOutput,
+				e)
+			return 1
+		}
+		// 写入的数据可能在关闭时才报错，例如磁盘已满
+		e = annotatedFile.Close()
+		if e != nil {
+			fmt.Fprintf(os.Stderr, "Error closing %s: %s\n", annotatedOutput,
+				e)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Saved annotated frames to %s\n",
+			annotatedOutput)
+	}
+	printTimingStats(os.Stderr, timingStats)
+	printThroughput(os.Stderr, frameIndex, time.Since(start))
+	return 0
+}
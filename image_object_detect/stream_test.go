@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// newTestJPEG 返回一张纯色的 JPEG 图片。withThumbnail 为 true 时在 SOI 之后
+// 插入一个包含 EOI 标记的 APP1 段，模拟带有 EXIF 缩略图的帧。
+func newTestJPEG(t *testing.T, c color.Color, withThumbnail bool) []byte {
+	pic := image.NewRGBA(image.Rect(0, 0, 24, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 24; x++ {
+			pic.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	e := jpeg.Encode(&buf, pic, nil)
+	if e != nil {
+		t.Fatalf("Error encoding JPEG: %s", e)
+	}
+	data := buf.Bytes()
+	if !withThumbnail {
+		return data
+	}
+	app1 := []byte{0xff, 0xe1, 0x00, 0x08, 'E', 'x', 0xff, 0xd8, 0xff, 0xd9}
+	return append(append(append([]byte{}, data[:2]...), app1...),
+		data[2:]...)
+}
+
+func TestMJPEGReader(t *testing.T) {
+	frames := [][]byte{
+		newTestJPEG(t, color.RGBA{255, 0, 0, 255}, false),
+		newTestJPEG(t, color.RGBA{0, 255, 0, 255}, true),
+		newTestJPEG(t, color.RGBA{0, 0, 255, 255}, false),
+	}
+	// 帧之间插入 multipart 分隔符之类的数据
+	var stream bytes.Buffer
+	for i, frame := range frames {
+		if i == 1 {
+			stream.WriteString("--boundary\r\nContent-Type: image/jpeg\r\n\r\n")
+		}
+		stream.Write(frame)
+	}
+	stream.WriteString("\r\n--boundary--\r\n")
+
+	source := &mjpegFrameSource{reader: newMJPEGReader(&stream), name: "test"}
+	for i, frame := range frames {
+		data, e := newMJPEGReader(bytes.NewReader(frame)).nextFrame()
+		if (e != nil) || !bytes.Equal(data, frame) {
+			t.Fatalf("Frame %d wasn't read back unchanged (%v)", i, e)
+		}
+		pic, _, e := source.next()
+		if e != nil {
+			t.Fatalf("Error reading frame %d: %s", i, e)
+		}
+		r, g, b, _ := pic.At(5, 5).RGBA()
+		expected := []uint32{0, 0, 0}
+		expected[i] = 0xff
+		actual := []uint32{r >> 8, g >> 8, b >> 8}
+		for j := range expected {
+			if (actual[j] > expected[j]+8) || (actual[j]+8 < expected[j]) {
+				t.Errorf("Frame %d has the wrong color: %v", i, actual)
+				break
+			}
+		}
+	}
+	_, _, e := source.next()
+	if !errors.Is(e, io.EOF) {
+		t.Errorf("Expected io.EOF after the last frame, got %v", e)
+	}
+
+	// 截断的帧应返回 io.ErrUnexpectedEOF
+	truncated := frames[0][:len(frames[0])/2]
+	_, e = newMJPEGReader(bytes.NewReader(truncated)).nextFrame()
+	if !errors.Is(e, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected io.ErrUnexpectedEOF for a truncated frame, got %v",
+			e)
+	}
+}
+
+func TestSortFramePaths(t *testing.T) {
+	paths := []string{"frames/frame_10.jpg", "frames/notes.png",
+		"frames/frame_2.jpg", "frames/frame_001.jpg", "frames/cover.jpg"}
+	sortFramePaths(paths)
+	expected := []string{"frames/frame_001.jpg", "frames/frame_2.jpg",
+		"frames/frame_10.jpg", "frames/cover.jpg", "frames/notes.png"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Expected %v, got %v", expected, paths)
+	}
+}
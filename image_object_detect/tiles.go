@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"time"
+)
+
+// tilingConfig 保存切片推理（SAHI）的参数
+type tilingConfig struct {
+	// 每个切片的边长，单位为原始图像的像素
+	size int
+	// 相邻切片重叠部分占切片边长的比例，范围为 [0, 1)
+	overlap float64
+	// 为 true 时，除了各个切片，还会对缩放后的整张图像运行一次检测，用于
+	// 检测比切片更大的目标
+	fullImage bool
+}
+
+// validate 检查 c 中的参数是否有效
+func (c *tilingConfig) validate() error {
+	if c.size < 1 {
+		return fmt.Errorf("The tile size must be positive")
+	}
+	if (c.overlap < 0) || (c.overlap >= 1) {
+		return fmt.Errorf("The tile overlap must be at least 0 and less " +
+			"than 1")
+	}
+	return nil
+}
+
+// tileStarts 返回沿长度为 length 的一个方向切分时每个切片的起点。切片之间
+// 的步长为 size * (1 - overlap)，最后一个切片与图像的边缘对齐，因此与前一个
+// 切片的重叠可能更多。length 不超过 size 时只有一个从 0 开始的切片。
+func tileStarts(length, size int, overlap float64) []int {
+	if length <= size {
+		return []int{0}
+	}
+	step := max(int(float64(size)*(1-overlap)), 1)
+	var toReturn []int
+	for start := 0; ; start += step {
+		if start+size >= length {
+			toReturn = append(toReturn, length-size)
+			return toReturn
+		}
+		toReturn = append(toReturn, start)
+	}
+}
+
+// tileRects 返回将 width x height 的图像切分为的所有切片，坐标相对于图像
+// 的左上角，按行排列
+func (c *tilingConfig) tileRects(width, height int) []image.Rectangle {
+	var toReturn []image.Rectangle
+	for _, y := range tileStarts(height, c.size, c.overlap) {
+		for _, x := range tileStarts(width, c.size, c.overlap) {
+			toReturn = append(toReturn, image.Rect(x, y,
+				min(x+c.size, width), min(y+c.size, height)))
+		}
+	}
+	return toReturn
+}
+
+// cropImage 返回 pic 中 r 范围内的部分，r 的坐标与 pic.Bounds() 相同。标准
+// 库中的图像类型都支持 SubImage()，不需要复制像素。
+func cropImage(pic image.Image, r image.Rectangle) image.Image {
+	if s, ok := pic.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+	toReturn := image.NewRGBA(r)
+	draw.Draw(toReturn, r, pic, r.Min, draw.Src)
+	return toReturn
+}
+
+// translate 将 b 中的所有坐标平移 (dx, dy)
+func (b *boundingBox) translate(dx, dy float32) {
+	b.x1 += dx
+	b.y1 += dy
+	b.x2 += dx
+	b.y2 += dy
+	for i := range b.keypoints {
+		b.keypoints[i].x += dx
+		b.keypoints[i].y += dy
+	}
+	if b.rotated != nil {
+		r := *b.rotated
+		r.cx += dx
+		r.cy += dy
+		b.rotated = &r
+	}
+	if b.mask != nil {
+		// image.Alpha 的像素按 Rect.Min 寻址，只需要移动 Rect
+		mask := *b.mask
+		mask.Rect = mask.Rect.Add(image.Pt(int(dx), int(dy)))
+		b.mask = &mask
+	}
+}
+
+// detectTiled 将 pic 切分为相互重叠的切片，以 batchSize() 为一批对所有切片
+// 运行检测，将检测框映射回整张图像的坐标后，与整张图像的检测结果一起用
+// NMS 合并。图像不超过一个切片时与 runBatch 相同。
+func (d *detector) detectTiled(pic image.Image) ([]boundingBox,
+	detectionTiming, error) {
+	bounds := pic.Bounds().Canon()
+	config := d.tiling
+	if (bounds.Dx() <= config.size) && (bounds.Dy() <= config.size) {
+		boxes, timing, e := d.runBatch([]image.Image{pic})
+		if e != nil {
+			return nil, timing, e
+		}
+		return boxes[0], timing, nil
+	}
+	rects := config.tileRects(bounds.Dx(), bounds.Dy())
+	tiles := make([]image.Image, 0, len(rects)+1)
+	for _, r := range rects {
+		tiles = append(tiles, cropImage(pic, r.Add(bounds.Min)))
+	}
+	if config.fullImage {
+		rects = append(rects, image.Rectangle{})
+		tiles = append(tiles, pic)
+	}
+
+	var timing detectionTiming
+	var merged []boundingBox
+	batchSize := d.batchSize()
+	for start := 0; start < len(tiles); start += batchSize {
+		end := min(start+batchSize, len(tiles))
+		batchBoxes, batchTiming, e := d.runBatch(tiles[start:end])
+		if e != nil {
+			return nil, timing, fmt.Errorf("Error running detection on "+
+				"tiles %d to %d: %w", start, end-1, e)
+		}
+		timing.add(&batchTiming)
+		for i, boxes := range batchBoxes {
+			origin := rects[start+i].Min
+			for j := range boxes {
+				boxes[j].translate(float32(origin.X), float32(origin.Y))
+			}
+			merged = append(merged, boxes...)
+		}
+	}
+	// 重叠区域中的目标会被多个切片检测到
+	start := time.Now()
+	merged = nonMaxSuppression(merged, d.nms)
+	timing.postprocess += time.Since(start)
+	return merged, timing, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestTileStarts(t *testing.T) {
+	tests := []struct {
+		length, size int
+		overlap      float64
+		expected     []int
+	}{
+		{500, 640, 0.2, []int{0}},
+		{640, 640, 0.2, []int{0}},
+		// 步长为 512，最后一个切片与边缘对齐
+		{1920, 640, 0.2, []int{0, 512, 1024, 1280}},
+		{1280, 640, 0, []int{0, 640}},
+		{1000, 400, 0.5, []int{0, 200, 400, 600}},
+	}
+	for _, test := range tests {
+		actual := tileStarts(test.length, test.size, test.overlap)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Expected tiles starting at %v for length %d, got %v",
+				test.expected, test.length, actual)
+		}
+	}
+}
+
+func TestTileRects(t *testing.T) {
+	config := &tilingConfig{size: 400, overlap: 0.25}
+	e := config.validate()
+	if e != nil {
+		t.Fatalf("Invalid config: %s", e)
+	}
+	const width, height = 1000, 300
+	rects := config.tileRects(width, height)
+	expected := []image.Rectangle{
+		image.Rect(0, 0, 400, 300),
+		image.Rect(300, 0, 700, 300),
+		image.Rect(600, 0, 1000, 300),
+	}
+	if !reflect.DeepEqual(rects, expected) {
+		t.Fatalf("Expected tiles %v, got %v", expected, rects)
+	}
+	for _, overlap := range []float64{-0.1, 1} {
+		config.overlap = overlap
+		if config.validate() == nil {
+			t.Errorf("Didn't get an error for overlap %f", overlap)
+		}
+	}
+}
+
+func TestCropImage(t *testing.T) {
+	pic := image.NewRGBA(image.Rect(10, 10, 50, 50))
+	pic.SetRGBA(30, 20, color.RGBA{200, 0, 0, 255})
+	r := image.Rect(25, 15, 45, 35)
+	for _, src := range []image.Image{pic, opaqueImage{pic}} {
+		tile := cropImage(src, r)
+		if tile.Bounds() != r {
+			t.Fatalf("Expected the tile's bounds to be %v, got %v", r,
+				tile.Bounds())
+		}
+		red, _, _, _ := tile.At(30, 20).RGBA()
+		if red>>8 != 200 {
+			t.Errorf("Expected the tile to contain the original pixels")
+		}
+	}
+}
+
+func TestTranslateBox(t *testing.T) {
+	b := newTestBox(0, 0.9, 10, 20, 30, 40)
+	b.keypoints = []keypoint{{x: 15, y: 25, visibility: 1}}
+	rotated := rotatedBox{cx: 20, cy: 30, w: 10, h: 10}
+	b.rotated = &rotated
+	b.mask = image.NewAlpha(image.Rect(10, 20, 30, 40))
+	b.mask.SetAlpha(12, 22, color.Alpha{255})
+	original := b.mask
+
+	b.translate(100, 200)
+	if (b.x1 != 110) || (b.y1 != 220) || (b.x2 != 130) || (b.y2 != 240) {
+		t.Errorf("Got incorrect box after translating: %s", b.String())
+	}
+	if (b.keypoints[0].x != 115) || (b.keypoints[0].y != 225) {
+		t.Errorf("Got incorrect keypoint after translating: %+v",
+			b.keypoints[0])
+	}
+	if (b.rotated.cx != 120) || (b.rotated.cy != 230) || (rotated.cx != 20) {
+		t.Errorf("Got incorrect rotated box after translating: %+v",
+			b.rotated)
+	}
+	if (b.mask.Rect != image.Rect(110, 220, 130, 240)) ||
+		(b.mask.AlphaAt(112, 222).A != 255) || (original.Rect.Min.X != 10) {
+		t.Errorf("Got incorrect mask after translating: %v", b.mask.Rect)
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ByteTrack 卡尔曼滤波器中位置和速度噪声相对于框高度的比例
+const (
+	kalmanPositionWeight = 1.0 / 20
+	kalmanVelocityWeight = 1.0 / 160
+)
+
+// kalmanFilter 以 (cx, cy, a, h) 及其速度为状态，使用匀速模型跟踪一个框，
+// 其中 a 为宽高比，h 为高度，与 SORT 和 ByteTrack 相同。由于运动模型和观测
+// 模型在四个坐标之间互不影响，且噪声矩阵都是对角矩阵，协方差矩阵始终由四个
+// 独立的 2x2 块组成，因此每个坐标分别保存其位置和速度的协方差。
+type kalmanFilter struct {
+	position [4]float64
+	velocity [4]float64
+	// 每个坐标的协方差 [位置方差, 位置与速度的协方差, 速度方差]
+	covariance [4][3]float64
+}
+
+// boxMeasurement 将框转换为卡尔曼滤波器的观测值 (cx, cy, a, h)
+func boxMeasurement(b *boundingBox) [4]float64 {
+	w := float64(b.x2 - b.x1)
+	h := float64(b.y2 - b.y1)
+	// 避免退化的框导致除以 0
+	h = max(h, 1e-3)
+	return [4]float64{float64(b.x1) + w/2, float64(b.y1) + h/2, w / h, h}
+}
+
+// newKalmanFilter 以观测值 z 初始化滤波器，速度为 0
+func newKalmanFilter(z [4]float64) *kalmanFilter {
+	h := z[3]
+	positionStd := [4]float64{2 * kalmanPositionWeight * h,
+		2 * kalmanPositionWeight * h, 1e-2, 2 * kalmanPositionWeight * h}
+	velocityStd := [4]float64{10 * kalmanVelocityWeight * h,
+		10 * kalmanVelocityWeight * h, 1e-5, 10 * kalmanVelocityWeight * h}
+	toReturn := &kalmanFilter{position: z}
+	for i := range toReturn.covariance {
+		toReturn.covariance[i] = [3]float64{positionStd[i] * positionStd[i], 0,
+			velocityStd[i] * velocityStd[i]}
+	}
+	return toReturn
+}
+
+// predict 将状态向前推进一帧
+func (k *kalmanFilter) predict() {
+	h := k.position[3]
+	positionStd := [4]float64{kalmanPositionWeight * h,
+		kalmanPositionWeight * h, 1e-2, kalmanPositionWeight * h}
+	velocityStd := [4]float64{kalmanVelocityWeight * h,
+		kalmanVelocityWeight * h, 1e-5, kalmanVelocityWeight * h}
+	for i := range k.position {
+		k.position[i] += k.velocity[i]
+		// P = F P F^T + Q，其中 F = [[1, 1], [0, 1]]
+		c := &k.covariance[i]
+		c[0] += 2*c[1] + c[2] + positionStd[i]*positionStd[i]
+		c[1] += c[2]
+		c[2] += velocityStd[i] * velocityStd[i]
+	}
+}
+
+// update 使用观测值 z 校正状态
+func (k *kalmanFilter) update(z [4]float64) {
+	h := k.position[3]
+	measurementStd := [4]float64{kalmanPositionWeight * h,
+		kalmanPositionWeight * h, 1e-1, kalmanPositionWeight * h}
+	for i := range k.position {
+		c := &k.covariance[i]
+		s := c[0] + measurementStd[i]*measurementStd[i]
+		gainPosition := c[0] / s
+		gainVelocity := c[1] / s
+		innovation := z[i] - k.position[i]
+		k.position[i] += gainPosition * innovation
+		k.velocity[i] += gainVelocity * innovation
+		// P = (I - K H) P
+		c[2] -= gainVelocity * c[1]
+		c[1] -= gainPosition * c[1]
+		c[0] -= gainPosition * c[0]
+	}
+}
+
+// box 返回当前状态对应的框，只设置坐标
+func (k *kalmanFilter) box() boundingBox {
+	w := k.position[2] * k.position[3]
+	h := k.position[3]
+	return boundingBox{
+		x1: float32(k.position[0] - w/2),
+		y1: float32(k.position[1] - h/2),
+		x2: float32(k.position[0] + w/2),
+		y2: float32(k.position[1] + h/2),
+	}
+}
+
+// linearAssignment 使用匈牙利算法求 cost 中总代价最小的行列匹配，代价大于
+// threshold 的配对不会被匹配。与 ByteTrack 使用的 lapjv 的 cost_limit 相同，
+// 矩阵被扩展为每一行和每一列都可以以 threshold / 2 的代价保持未匹配，因此
+// 结果中每个配对的代价都不超过 threshold。返回匹配的 [行, 列] 以及未匹配的
+// 行和列，均按编号递增排列。
+func linearAssignment(cost [][]float64, rows, columns int,
+	threshold float64) (matches [][2]int, unmatchedRows,
+	unmatchedColumns []int) {
+	n := rows + columns
+	extended := func(i, j int) float64 {
+		if (i < rows) && (j < columns) {
+			return cost[i][j]
+		}
+		if (i >= rows) && (j >= columns) {
+			return 0
+		}
+		return threshold / 2
+	}
+
+	// 使用势函数的 O(n^3) 匈牙利算法，下标从 1 开始，assigned[j] 为匹配到
+	// 第 j 列的行
+	u := make([]float64, n+1)
+	v := make([]float64, n+1)
+	assigned := make([]int, n+1)
+	way := make([]int, n+1)
+	minimum := make([]float64, n+1)
+	used := make([]bool, n+1)
+	for i := 1; i <= n; i++ {
+		assigned[0] = i
+		j0 := 0
+		for j := range minimum {
+			minimum[j] = math.Inf(1)
+			used[j] = false
+		}
+		for {
+			used[j0] = true
+			i0 := assigned[j0]
+			delta := math.Inf(1)
+			j1 := 0
+			for j := 1; j <= n; j++ {
+				if used[j] {
+					continue
+				}
+				current := extended(i0-1, j-1) - u[i0] - v[j]
+				if current < minimum[j] {
+					minimum[j] = current
+					way[j] = j0
+				}
+				if minimum[j] < delta {
+					delta = minimum[j]
+					j1 = j
+				}
+			}
+			for j := 0; j <= n; j++ {
+				if used[j] {
+					u[assigned[j]] += delta
+					v[j] -= delta
+				} else {
+					minimum[j] -= delta
+				}
+			}
+			j0 = j1
+			if assigned[j0] == 0 {
+				break
+			}
+		}
+		for j0 != 0 {
+			j1 := way[j0]
+			assigned[j0] = assigned[j1]
+			j0 = j1
+		}
+	}
+
+	rowMatched := make([]bool, rows)
+	columnMatched := make([]bool, columns)
+	for j := 1; j <= columns; j++ {
+		i := assigned[j] - 1
+		if (i >= rows) || (cost[i][j-1] > threshold) {
+			continue
+		}
+		matches = append(matches, [2]int{i, j - 1})
+		rowMatched[i] = true
+		columnMatched[j-1] = true
+	}
+	sort.Slice(matches, func(a, b int) bool {
+		return matches[a][0] < matches[b][0]
+	})
+	for i, matched := range rowMatched {
+		if !matched {
+			unmatchedRows = append(unmatchedRows, i)
+		}
+	}
+	for j, matched := range columnMatched {
+		if !matched {
+			unmatchedColumns = append(unmatchedColumns, j)
+		}
+	}
+	return matches, unmatchedRows, unmatchedColumns
+}
+
+// trackState 是轨迹在 ByteTrack 中的状态
+type trackState int
+
+const (
+	// trackTracked 表示轨迹在最近一帧中匹配到了检测框
+	trackTracked trackState = iota
+	// trackLost 表示轨迹暂时没有匹配到检测框，但仍可能被重新找回
+	trackLost
+	// trackRemoved 表示轨迹已经被删除
+	trackRemoved
+)
+
+// track 是跨帧跟踪的一个目标
+type track struct {
+	id     int
+	state  trackState
+	kalman *kalmanFilter
+	// 最近一次匹配到的检测框
+	box boundingBox
+	// 除第一帧外，新轨迹在下一帧再次匹配到检测框后才被确认
+	activated bool
+	// 轨迹开始的帧和最近一次匹配到检测框的帧
+	startFrame, lastFrame int
+}
+
+// predicted 返回卡尔曼滤波器预测的当前帧中的框
+func (t *track) predicted() boundingBox {
+	return t.kalman.box()
+}
+
+// trackerConfig 保存 ByteTrack 的参数
+type trackerConfig struct {
+	// 置信度不低于该值的检测框参与第一轮匹配，并可以创建新的轨迹
+	highThreshold float32
+	// 置信度在 lowThreshold 和 highThreshold 之间的检测框只在第二轮中与
+	// 第一轮没有匹配到的轨迹匹配，低于 lowThreshold 的检测框被忽略
+	lowThreshold float32
+	// 第一轮匹配要求预测框与检测框的 IoU 至少为该值
+	minIoU float32
+	// 丢失的轨迹最多保留的帧数，在这期间重新匹配到时保持原来的编号
+	buffer int
+}
+
+// validate 检查 c 中的参数是否有效
+func (c *trackerConfig) validate() error {
+	if (c.lowThreshold < 0) || (c.lowThreshold > c.highThreshold) {
+		return fmt.Errorf("The low tracking confidence must be between 0 " +
+			"and the detection confidence threshold")
+	}
+	if (c.minIoU <= 0) || (c.minIoU > 1) {
+		return fmt.Errorf("The tracking IoU must be in (0, 1]")
+	}
+	if c.buffer < 0 {
+		return fmt.Errorf("The track buffer can't be negative")
+	}
+	return nil
+}
+
+// ByteTrack 中第二轮匹配和未确认轨迹匹配允许的最大 1 - IoU
+const (
+	secondMatchThreshold      = 0.5
+	unconfirmedMatchThreshold = 0.7
+	// 正在跟踪和已丢失的轨迹重叠时，1 - IoU 小于该值的视为重复
+	duplicateTrackThreshold = 0.15
+)
+
+// byteTracker 使用 ByteTrack 算法为连续帧中的检测框分配稳定的轨迹编号。
+// 每帧的检测框先与轨迹的卡尔曼预测按 IoU 进行匈牙利匹配；置信度较低的检测
+// 框不会创建新的轨迹，但可以在第二轮中延续被遮挡的目标的轨迹。匹配不考虑
+// 类别，轨迹的类别为最近一次匹配到的检测框的类别。
+type byteTracker struct {
+	config trackerConfig
+	// 正在跟踪（包括未确认）和已丢失的轨迹
+	tracked []*track
+	lost    []*track
+	frame   int
+	nextID  int
+}
+
+// newByteTracker 返回使用 config 的跟踪器
+func newByteTracker(config trackerConfig) (*byteTracker, error) {
+	e := config.validate()
+	if e != nil {
+		return nil, e
+	}
+	return &byteTracker{config: config, nextID: 1}, nil
+}
+
+// iouCosts 返回 tracks 的预测框与 boxes 之间的 1 - IoU 矩阵
+func iouCosts(tracks []*track, boxes []*boundingBox) [][]float64 {
+	toReturn := make([][]float64, len(tracks))
+	for i, t := range tracks {
+		predicted := t.predicted()
+		toReturn[i] = make([]float64, len(boxes))
+		for j, b := range boxes {
+			box := boundingBox{x1: b.x1, y1: b.y1, x2: b.x2, y2: b.y2}
+			toReturn[i][j] = 1 - float64(predicted.iou(&box))
+		}
+	}
+	return toReturn
+}
+
+// associate 将 tracks 与 boxes 匹配，匹配到的轨迹用对应的检测框更新。返回
+// 未匹配的轨迹和检测框。
+func (t *byteTracker) associate(tracks []*track, boxes []*boundingBox,
+	threshold float64) ([]*track, []*boundingBox) {
+	matches, unmatchedTracks, unmatchedBoxes := linearAssignment(
+		iouCosts(tracks, boxes), len(tracks), len(boxes), threshold)
+	for _, m := range matches {
+		t.updateTrack(tracks[m[0]], boxes[m[1]])
+	}
+	remainingTracks := make([]*track, len(unmatchedTracks))
+	for i, index := range unmatchedTracks {
+		remainingTracks[i] = tracks[index]
+	}
+	remainingBoxes := make([]*boundingBox, len(unmatchedBoxes))
+	for i, index := range unmatchedBoxes {
+		remainingBoxes[i] = boxes[index]
+	}
+	return remainingTracks, remainingBoxes
+}
+
+// updateTrack 用当前帧中匹配到的检测框 b 更新轨迹，已丢失的轨迹被重新找回
+func (t *byteTracker) updateTrack(tr *track, b *boundingBox) {
+	tr.kalman.update(boxMeasurement(b))
+	tr.box = *b
+	tr.state = trackTracked
+	tr.activated = true
+	tr.lastFrame = t.frame
+}
+
+// update 处理一帧的检测框，返回这一帧中已确认轨迹匹配到的检测框的副本，其
+// trackID 为轨迹编号，按编号排序。boxes 中置信度低于 lowThreshold 的检测
+// 框被忽略，boxes 本身不会被修改。
+func (t *byteTracker) update(boxes []boundingBox) []boundingBox {
+	t.frame++
+	var high, low []*boundingBox
+	for i := range boxes {
+		b := &boxes[i]
+		if b.confidence >= t.config.highThreshold {
+			high = append(high, b)
+		} else if b.confidence >= t.config.lowThreshold {
+			low = append(low, b)
+		}
+	}
+
+	// 已确认的轨迹和已丢失的轨迹一起参与第一轮匹配
+	var unconfirmed, pool []*track
+	for _, tr := range t.tracked {
+		if tr.activated {
+			pool = append(pool, tr)
+		} else {
+			unconfirmed = append(unconfirmed, tr)
+		}
+	}
+	pool = append(pool, t.lost...)
+	for _, tr := range pool {
+		// 与 ByteTrack 相同，丢失的轨迹不再预测高度的变化
+		if tr.state != trackTracked {
+			tr.kalman.velocity[3] = 0
+		}
+		tr.kalman.predict()
+	}
+
+	// 第一轮：高置信度的检测框
+	remaining, high := t.associate(pool, high,
+		1-float64(t.config.minIoU))
+	// 第二轮：低置信度的检测框只与上一帧仍在跟踪的轨迹匹配
+	var stillTracked []*track
+	for _, tr := range remaining {
+		if tr.state == trackTracked {
+			stillTracked = append(stillTracked, tr)
+		}
+	}
+	unmatched, _ := t.associate(stillTracked, low, secondMatchThreshold)
+	for _, tr := range unmatched {
+		tr.state = trackLost
+	}
+	// 未确认的轨迹只有一帧，与剩余的高置信度检测框匹配，匹配不到则删除
+	unmatched, high = t.associate(unconfirmed, high,
+		unconfirmedMatchThreshold)
+	for _, tr := range unmatched {
+		tr.state = trackRemoved
+	}
+	// 剩余的高置信度检测框创建新的轨迹。第一帧中的轨迹直接被确认。
+	for _, b := range high {
+		t.tracked = append(t.tracked, &track{
+			id:         t.nextID,
+			state:      trackTracked,
+			kalman:     newKalmanFilter(boxMeasurement(b)),
+			box:        *b,
+			activated:  t.frame == 1,
+			startFrame: t.frame,
+			lastFrame:  t.frame,
+		})
+		t.nextID++
+	}
+	// 删除丢失太久的轨迹
+	for _, tr := range t.lost {
+		if (tr.state == trackLost) && (t.frame-tr.lastFrame > t.config.buffer) {
+			tr.state = trackRemoved
+		}
+	}
+
+	// 按新的状态重新分组
+	all := append(t.tracked, t.lost...)
+	t.tracked, t.lost = nil, nil
+	for _, tr := range all {
+		switch tr.state {
+		case trackTracked:
+			t.tracked = append(t.tracked, tr)
+		case trackLost:
+			t.lost = append(t.lost, tr)
+		}
+	}
+	t.removeDuplicates()
+
+	var toReturn []boundingBox
+	for _, tr := range t.tracked {
+		if tr.activated {
+			b := tr.box
+			b.trackID = tr.id
+			toReturn = append(toReturn, b)
+		}
+	}
+	sort.Slice(toReturn, func(i, j int) bool {
+		return toReturn[i].trackID < toReturn[j].trackID
+	})
+	return toReturn
+}
+
+// removeDuplicates 与 ByteTrack 相同，当正在跟踪的轨迹与已丢失的轨迹几乎
+// 重合时，只保留存在时间较长的一个
+func (t *byteTracker) removeDuplicates() {
+	if (len(t.tracked) == 0) || (len(t.lost) == 0) {
+		return
+	}
+	removedTracked := make([]bool, len(t.tracked))
+	removedLost := make([]bool, len(t.lost))
+	for i, a := range t.tracked {
+		aBox := a.predicted()
+		for j, b := range t.lost {
+			bBox := b.predicted()
+			if 1-aBox.iou(&bBox) >= duplicateTrackThreshold {
+				continue
+			}
+			if a.startFrame < b.startFrame {
+				removedLost[j] = true
+			} else {
+				removedTracked[i] = true
+			}
+		}
+	}
+	filter := func(tracks []*track, removed []bool) []*track {
+		var toReturn []*track
+		for i, tr := range tracks {
+			if !removed[i] {
+				toReturn = append(toReturn, tr)
+			}
+		}
+		return toReturn
+	}
+	t.tracked = filter(t.tracked, removedTracked)
+	t.lost = filter(t.lost, removedLost)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestKalmanFilterConstantVelocity(t *testing.T) {
+	// 以每帧 (4, -2) 的速度移动的框，几帧之后预测应接近真实位置
+	b := newTestBox(0, 0.9, 100, 100, 140, 180)
+	k := newKalmanFilter(boxMeasurement(&b))
+	for i := 0; i < 20; i++ {
+		b.x1 += 4
+		b.x2 += 4
+		b.y1 -= 2
+		b.y2 -= 2
+		k.predict()
+		k.update(boxMeasurement(&b))
+	}
+	k.predict()
+	predicted := k.box()
+	expected := [4]float32{b.x1 + 4, b.y1 - 2, b.x2 + 4, b.y2 - 2}
+	actual := [4]float32{predicted.x1, predicted.y1, predicted.x2,
+		predicted.y2}
+	for i := range expected {
+		if math.Abs(float64(actual[i]-expected[i])) > 0.5 {
+			t.Fatalf("Expected the predicted box to be %v, got %v", expected,
+				actual)
+		}
+	}
+}
+
+func TestLinearAssignment(t *testing.T) {
+	tests := []struct {
+		name             string
+		cost             [][]float64
+		rows, columns    int
+		threshold        float64
+		matches          [][2]int
+		unmatchedRows    []int
+		unmatchedColumns []int
+	}{
+		{
+			name:      "greedy is not optimal",
+			cost:      [][]float64{{0.1, 0.2}, {0.15, 0.9}},
+			rows:      2,
+			columns:   2,
+			threshold: 1,
+			matches:   [][2]int{{0, 1}, {1, 0}},
+		},
+		{
+			name:             "more columns than rows",
+			cost:             [][]float64{{0.9, 0.3, 0.5}},
+			rows:             1,
+			columns:          3,
+			threshold:        0.8,
+			matches:          [][2]int{{0, 1}},
+			unmatchedColumns: []int{0, 2},
+		},
+		{
+			name:             "costs above the threshold",
+			cost:             [][]float64{{0.1, 0.85}, {0.9, 0.95}},
+			rows:             2,
+			columns:          2,
+			threshold:        0.8,
+			matches:          [][2]int{{0, 0}},
+			unmatchedRows:    []int{1},
+			unmatchedColumns: []int{1},
+		},
+		{
+			name:          "no columns",
+			cost:          [][]float64{{}, {}},
+			rows:          2,
+			columns:       0,
+			threshold:     0.8,
+			unmatchedRows: []int{0, 1},
+		},
+	}
+	for _, test := range tests {
+		matches, rows, columns := linearAssignment(test.cost, test.rows,
+			test.columns, test.threshold)
+		if !reflect.DeepEqual(matches, test.matches) ||
+			!reflect.DeepEqual(rows, test.unmatchedRows) ||
+			!reflect.DeepEqual(columns, test.unmatchedColumns) {
+			t.Errorf("%s: got matches %v, unmatched rows %v and columns %v",
+				test.name, matches, rows, columns)
+		}
+	}
+}
+
+// newTestTracker 返回使用默认参数的跟踪器
+func newTestTracker(t *testing.T) *byteTracker {
+	tracker, e := newByteTracker(trackerConfig{
+		highThreshold: 0.5,
+		lowThreshold:  0.1,
+		minIoU:        0.2,
+		buffer:        5,
+	})
+	if e != nil {
+		t.Fatalf("Error creating tracker: %s", e)
+	}
+	return tracker
+}
+
+// trackIDs 返回 boxes 的轨迹编号
+func trackIDs(boxes []boundingBox) []int {
+	toReturn := make([]int, len(boxes))
+	for i := range boxes {
+		toReturn[i] = boxes[i].trackID
+	}
+	return toReturn
+}
+
+func TestByteTrackerKeepsIDs(t *testing.T) {
+	tracker := newTestTracker(t)
+	// 两个相向移动的目标
+	for frame := 0; frame < 10; frame++ {
+		offset := float32(frame * 5)
+		boxes := []boundingBox{
+			newTestBox(0, 0.9, 300-offset, 50, 340-offset, 150),
+			newTestBox(0, 0.8, 10+offset, 60, 50+offset, 160),
+		}
+		tracked := tracker.update(boxes)
+		if !reflect.DeepEqual(trackIDs(tracked), []int{1, 2}) {
+			t.Fatalf("Got track IDs %v in frame %d", trackIDs(tracked), frame)
+		}
+		if (tracked[0].x1 != boxes[0].x1) || (tracked[1].x1 != boxes[1].x1) {
+			t.Fatalf("Tracks were matched to the wrong boxes in frame %d",
+				frame)
+		}
+		if boxes[0].trackID != 0 {
+			t.Fatalf("The input boxes were modified")
+		}
+	}
+}
+
+func TestByteTrackerLowConfidence(t *testing.T) {
+	tracker := newTestTracker(t)
+	for frame := 0; frame < 3; frame++ {
+		tracker.update([]boundingBox{newTestBox(0, 0.9, 10, 10, 50, 90)})
+	}
+	// 部分遮挡时置信度下降，第二轮匹配应延续原来的轨迹
+	tracked := tracker.update([]boundingBox{
+		newTestBox(0, 0.3, 11, 10, 51, 90),
+		// 不与任何轨迹重叠的低置信度检测框不会创建新的轨迹
+		newTestBox(0, 0.3, 200, 200, 240, 280),
+	})
+	if !reflect.DeepEqual(trackIDs(tracked), []int{1}) ||
+		(tracked[0].confidence != 0.3) {
+		t.Fatalf("Expected the low-confidence box to continue track 1, got "+
+			"%v", tracked)
+	}
+	// 低于 lowThreshold 的检测框被忽略
+	tracked = tracker.update([]boundingBox{newTestBox(0, 0.05, 11, 10, 51,
+		90)})
+	if len(tracked) != 0 {
+		t.Fatalf("Expected no tracked boxes, got %v", tracked)
+	}
+}
+
+func TestByteTrackerLostTracks(t *testing.T) {
+	tracker := newTestTracker(t)
+	box := newTestBox(0, 0.9, 10, 10, 50, 90)
+	tracker.update([]boundingBox{box})
+	tracker.update([]boundingBox{box})
+	// 在 buffer 帧之内重新出现时保持原来的编号
+	for i := 0; i < 3; i++ {
+		tracker.update(nil)
+	}
+	tracked := tracker.update([]boundingBox{box})
+	if !reflect.DeepEqual(trackIDs(tracked), []int{1}) {
+		t.Fatalf("Expected the object to keep track 1, got %v",
+			trackIDs(tracked))
+	}
+	// 超过 buffer 帧后，重新出现的目标成为新的轨迹，并在下一帧被确认
+	for i := 0; i < 7; i++ {
+		tracker.update(nil)
+	}
+	tracked = tracker.update([]boundingBox{box})
+	if len(tracked) != 0 {
+		t.Fatalf("Expected the new track to be unconfirmed, got %v",
+			trackIDs(tracked))
+	}
+	tracked = tracker.update([]boundingBox{box})
+	if !reflect.DeepEqual(trackIDs(tracked), []int{2}) {
+		t.Fatalf("Expected a new track 2, got %v", trackIDs(tracked))
+	}
+}
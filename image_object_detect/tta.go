@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ttaConfig 保存测试时增强（TTA）的参数
+type ttaConfig struct {
+	// 每个缩放比例都会运行一次检测。小于 1 时图像在输入中缩小，四周填充
+	// 灰色；大于 1 时图像被放大，只检测中间的部分。
+	scales []float64
+	// 为 true 时每个缩放比例还会对水平翻转的图像运行一次检测
+	flip bool
+	// 加权框融合中，与融合框的 IoU 超过该值的检测框被合并
+	iouThreshold float32
+}
+
+// parseTTAScales 解析以逗号分隔的缩放比例，例如 "0.83,1,1.2"
+func parseTTAScales(s string) ([]float64, error) {
+	var toReturn []float64
+	for _, field := range strings.Split(s, ",") {
+		scale, e := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if (e != nil) || (scale <= 0) || math.IsInf(scale, 0) {
+			return nil, fmt.Errorf("Invalid TTA scale: %q", field)
+		}
+		toReturn = append(toReturn, scale)
+	}
+	return toReturn, nil
+}
+
+// validate 检查 c 中的参数是否有效
+func (c *ttaConfig) validate() error {
+	if len(c.scales) == 0 {
+		return fmt.Errorf("At least one TTA scale is required")
+	}
+	if (c.iouThreshold <= 0) || (c.iouThreshold > 1) {
+		return fmt.Errorf("The TTA fusion IoU must be in (0, 1]")
+	}
+	return nil
+}
+
+// augmentation 是 TTA 中的一次检测
+type augmentation struct {
+	scale float64
+	flip  bool
+}
+
+// augmentations 返回每张图片需要运行的所有检测
+func (c *ttaConfig) augmentations() []augmentation {
+	var toReturn []augmentation
+	for _, scale := range c.scales {
+		toReturn = append(toReturn, augmentation{scale: scale})
+		if c.flip {
+			toReturn = append(toReturn, augmentation{scale: scale, flip: true})
+		}
+	}
+	return toReturn
+}
+
+// augmentationView 返回以 scale 缩放时送入网络的区域，坐标相对于原始图像
+// 的左上角。区域与图像的中心对齐，scale 小于 1 时大于图像，大于 1 时只包含
+// 图像中间的部分，因此 letterbox 之后图像中的目标被缩放了 scale 倍。
+func augmentationView(width, height int, scale float64) image.Rectangle {
+	w := max(int(math.Round(float64(width)/scale)), 1)
+	h := max(int(math.Round(float64(height)/scale)), 1)
+	x := int(math.Floor(float64(width-w) / 2))
+	y := int(math.Floor(float64(height-h) / 2))
+	return image.Rect(x, y, x+w, y+h)
+}
+
+// augmentImage 返回 pic 中 view 范围内的部分，原图以外的像素为 letterbox
+// 使用的灰色。flip 为 true 时结果被水平翻转。view 的坐标相对于 pic 的左
+// 上角，返回的图像从 (0, 0) 开始。
+func augmentImage(pic image.Image, view image.Rectangle,
+	flip bool) *image.RGBA {
+	bounds := pic.Bounds()
+	toReturn := image.NewRGBA(image.Rect(0, 0, view.Dx(), view.Dy()))
+	gray := color.RGBA{letterboxGray, letterboxGray, letterboxGray, 255}
+	draw.Draw(toReturn, toReturn.Bounds(), image.NewUniform(gray),
+		image.Point{}, draw.Src)
+	// view 在原图中的部分
+	visible := view.Add(bounds.Min).Intersect(bounds)
+	draw.Draw(toReturn, visible.Sub(bounds.Min).Sub(view.Min), pic,
+		visible.Min, draw.Src)
+	if !flip {
+		return toReturn
+	}
+	for y := 0; y < view.Dy(); y++ {
+		row := toReturn.Pix[y*toReturn.Stride : y*toReturn.Stride+4*view.Dx()]
+		for i, j := 0, len(row)-4; i < j; i, j = i+4, j-4 {
+			for k := 0; k < 4; k++ {
+				row[i+k], row[j+k] = row[j+k], row[i+k]
+			}
+		}
+	}
+	return toReturn
+}
+
+// mapAugmentedBox 将 augmentImage 返回的图像中的检测框 b 映射回 width x
+// height 的原始图像并限制在图像范围内。view 的边缘在原图内部时（scale 大于
+// 1），紧贴该边缘的检测框很可能只包含目标的一部分，此时返回 false，表示应
+// 丢弃这个检测框。
+func mapAugmentedBox(b *boundingBox, view image.Rectangle, flip bool,
+	width, height int) bool {
+	if flip {
+		w := float32(view.Dx())
+		b.x1, b.x2 = w-b.x2, w-b.x1
+	}
+	b.x1 += float32(view.Min.X)
+	b.x2 += float32(view.Min.X)
+	b.y1 += float32(view.Min.Y)
+	b.y2 += float32(view.Min.Y)
+	const edgeMargin = 1
+	if ((view.Min.X > 0) && (b.x1 <= float32(view.Min.X)+edgeMargin)) ||
+		((view.Min.Y > 0) && (b.y1 <= float32(view.Min.Y)+edgeMargin)) ||
+		((view.Max.X < width) && (b.x2 >= float32(view.Max.X)-edgeMargin)) ||
+		((view.Max.Y < height) && (b.y2 >= float32(view.Max.Y)-edgeMargin)) {
+		return false
+	}
+	b.x1 = min(max(b.x1, 0), float32(width))
+	b.x2 = min(max(b.x2, 0), float32(width))
+	b.y1 = min(max(b.y1, 0), float32(height))
+	b.y2 = min(max(b.y2, 0), float32(height))
+	return (b.x2 > b.x1) && (b.y2 > b.y1)
+}
+
+// fusedBox 是加权框融合中的一个簇
+type fusedBox struct {
+	// 按置信度加权平均后的框，confidence 为簇中置信度的平均值
+	box boundingBox
+	// 簇中检测框的置信度之和，以及按置信度加权的坐标之和
+	totalConfidence float32
+	x1, y1, x2, y2  float32
+	count           int
+}
+
+// add 将 b 加入簇并更新融合后的框
+func (f *fusedBox) add(b *boundingBox) {
+	f.count++
+	f.totalConfidence += b.confidence
+	f.x1 += b.confidence * b.x1
+	f.y1 += b.confidence * b.y1
+	f.x2 += b.confidence * b.x2
+	f.y2 += b.confidence * b.y2
+	f.box.x1 = f.x1 / f.totalConfidence
+	f.box.y1 = f.y1 / f.totalConfidence
+	f.box.x2 = f.x2 / f.totalConfidence
+	f.box.y2 = f.y2 / f.totalConfidence
+	f.box.confidence = f.totalConfidence / float32(f.count)
+}
+
+// weightedBoxFusion 使用加权框融合（WBF）合并 passes 中多次检测的结果。
+// 与 NMS 只保留置信度最高的框不同，WBF 将 IoU 超过 iouThreshold 的同类
+// 检测框的坐标按置信度加权平均。融合后的置信度为簇中置信度的平均值乘以
+// min(簇的大小, len(passes)) / len(passes)，因此只在少数几次检测中出现的
+// 目标置信度较低。结果按置信度从高到低排列，nms 的 classAgnostic 和
+// maxDetections 同样适用。
+func weightedBoxFusion(passes [][]boundingBox, iouThreshold float32,
+	o *nmsOptions) []boundingBox {
+	var all []boundingBox
+	for _, boxes := range passes {
+		all = append(all, boxes...)
+	}
+	sortByConfidence(all)
+	var clusters []*fusedBox
+	for i := range all {
+		b := &all[i]
+		var best *fusedBox
+		bestIoU := iouThreshold
+		for _, c := range clusters {
+			if !o.classAgnostic && (c.box.classID != b.classID) {
+				continue
+			}
+			iou := c.box.iou(b)
+			if iou > bestIoU {
+				best = c
+				bestIoU = iou
+			}
+		}
+		if best == nil {
+			// 簇的类别为其中置信度最高的检测框的类别
+			best = &fusedBox{box: boundingBox{
+				label:   b.label,
+				classID: b.classID,
+			}}
+			clusters = append(clusters, best)
+		}
+		best.add(b)
+	}
+	toReturn := make([]boundingBox, len(clusters))
+	for i, c := range clusters {
+		toReturn[i] = c.box
+		toReturn[i].confidence *= float32(min(c.count, len(passes))) /
+			float32(len(passes))
+	}
+	sortByConfidence(toReturn)
+	if (o.maxDetections > 0) && (len(toReturn) > o.maxDetections) {
+		toReturn = toReturn[:o.maxDetections]
+	}
+	return toReturn
+}
+
+// detectTTA 对 pic 的每个增强版本运行检测，以 batchSize() 为一批，将检测框
+// 映射回原始图像后用加权框融合合并
+func (d *detector) detectTTA(pic image.Image) ([]boundingBox,
+	detectionTiming, error) {
+	var timing detectionTiming
+	bounds := pic.Bounds().Canon()
+	width, height := bounds.Dx(), bounds.Dy()
+	augmentations := d.tta.augmentations()
+	start := time.Now()
+	views := make([]image.Rectangle, len(augmentations))
+	pics := make([]image.Image, len(augmentations))
+	for i, a := range augmentations {
+		views[i] = augmentationView(width, height, a.scale)
+		if (views[i] == image.Rect(0, 0, width, height)) && !a.flip {
+			pics[i] = pic
+		} else {
+			pics[i] = augmentImage(pic, views[i], a.flip)
+		}
+	}
+	timing.preprocess = time.Since(start)
+
+	passes := make([][]boundingBox, 0, len(augmentations))
+	batchSize := d.batchSize()
+	for start := 0; start < len(pics); start += batchSize {
+		end := min(start+batchSize, len(pics))
+		batchBoxes, batchTiming, e := d.runBatch(pics[start:end])
+		if e != nil {
+			return nil, timing, fmt.Errorf("Error running augmented "+
+				"detection: %w", e)
+		}
+		timing.add(&batchTiming)
+		for i, boxes := range batchBoxes {
+			a := augmentations[start+i]
+			kept := boxes[:0]
+			for j := range boxes {
+				if mapAugmentedBox(&boxes[j], views[start+i], a.flip, width,
+					height) {
+					kept = append(kept, boxes[j])
+				}
+			}
+			passes = append(passes, kept)
+		}
+	}
+	start = time.Now()
+	toReturn := weightedBoxFusion(passes, d.tta.iouThreshold, d.nms)
+	timing.postprocess += time.Since(start)
+	return toReturn, timing, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseTTAScales(t *testing.T) {
+	scales, e := parseTTAScales("0.83, 1,1.2")
+	if (e != nil) || !reflect.DeepEqual(scales, []float64{0.83, 1, 1.2}) {
+		t.Errorf("Got scales %v, error %v", scales, e)
+	}
+	for _, s := range []string{"", "1,,2", "0", "-1", "abc", "inf"} {
+		_, e = parseTTAScales(s)
+		if e == nil {
+			t.Errorf("Didn't get an error for scales %q", s)
+		}
+	}
+	config := &ttaConfig{scales: []float64{0.5, 1}, flip: true,
+		iouThreshold: 0.55}
+	if len(config.augmentations()) != 4 {
+		t.Errorf("Expected 4 augmentations, got %v", config.augmentations())
+	}
+}
+
+func TestAugmentationView(t *testing.T) {
+	tests := []struct {
+		scale    float64
+		expected image.Rectangle
+	}{
+		{1, image.Rect(0, 0, 200, 100)},
+		// 缩小时区域大于图像，图像位于中间
+		{0.8, image.Rect(-25, -13, 225, 112)},
+		// 放大时只包含图像中间的部分
+		{2, image.Rect(50, 25, 150, 75)},
+	}
+	for _, test := range tests {
+		actual := augmentationView(200, 100, test.scale)
+		if actual != test.expected {
+			t.Errorf("Expected the view for scale %f to be %v, got %v",
+				test.scale, test.expected, actual)
+		}
+	}
+}
+
+func TestAugmentImage(t *testing.T) {
+	const width, height = 40, 20
+	pic := image.NewRGBA(image.Rect(5, 5, 5+width, 5+height))
+	pic.SetRGBA(5+30, 5+10, color.RGBA{255, 0, 0, 255})
+	for _, flip := range []bool{false, true} {
+		view := augmentationView(width, height, 0.5)
+		augmented := augmentImage(pic, view, flip)
+		if augmented.Bounds() != image.Rect(0, 0, 80, 40) {
+			t.Fatalf("Got incorrect bounds %v", augmented.Bounds())
+		}
+		if augmented.RGBAAt(0, 0).R != letterboxGray {
+			t.Errorf("Expected the area outside the image to be gray")
+		}
+		// 原图中 (30, 10) 处的像素在 view 中位于 (50, 20)
+		x := 50
+		if flip {
+			x = 80 - 1 - x
+		}
+		if augmented.RGBAAt(x, 20) != (color.RGBA{255, 0, 0, 255}) {
+			t.Errorf("Expected the red pixel at (%d, 20) with flip = %v", x,
+				flip)
+		}
+
+		// 覆盖这个像素的检测框映射回原图后应覆盖原来的位置
+		b := newTestBox(0, 0.9, float32(x)-2, 18, float32(x)+3, 23)
+		if !mapAugmentedBox(&b, view, flip, width, height) {
+			t.Fatalf("The box was unexpectedly dropped")
+		}
+		if (b.x1 > 30) || (b.x2 < 31) || (b.y1 > 10) || (b.y2 < 11) {
+			t.Errorf("The mapped box %s doesn't cover (30, 10) with flip "+
+				"= %v", b.String(), flip)
+		}
+	}
+}
+
+func TestMapAugmentedBoxDropsTruncatedBoxes(t *testing.T) {
+	// 放大 2 倍时只检测图像中间的 (50, 25) 到 (150, 75)
+	view := augmentationView(200, 100, 2)
+	truncated := newTestBox(0, 0.9, 0, 10, 30, 40)
+	if mapAugmentedBox(&truncated, view, false, 200, 100) {
+		t.Errorf("Expected a box touching the view's edge to be dropped")
+	}
+	inside := newTestBox(0, 0.9, 10, 10, 30, 40)
+	if !mapAugmentedBox(&inside, view, false, 200, 100) ||
+		(inside.x1 != 60) || (inside.y2 != 65) {
+		t.Errorf("Got incorrect mapped box: %s", inside.String())
+	}
+	// 视图边缘与图像边缘重合时不丢弃
+	full := augmentationView(200, 100, 1)
+	atEdge := newTestBox(0, 0.9, 0, 0, 30, 40)
+	if !mapAugmentedBox(&atEdge, full, true, 200, 100) ||
+		(atEdge.x1 != 170) || (atEdge.x2 != 200) {
+		t.Errorf("Got incorrect flipped box: %s", atEdge.String())
+	}
+}
+
+func TestWeightedBoxFusion(t *testing.T) {
+	passes := [][]boundingBox{
+		{newTestBox(0, 0.9, 0, 0, 10, 10), newTestBox(2, 0.8, 50, 50, 60,
+			60)},
+		{newTestBox(0, 0.6, 1, 1, 11, 11)},
+		// 与第一个框重叠，但类别不同
+		{newTestBox(1, 0.3, 1, 1, 11, 11)},
+	}
+	options := &nmsOptions{}
+	fused := weightedBoxFusion(passes, 0.55, options)
+	if len(fused) != 3 {
+		t.Fatalf("Expected 3 fused boxes, got %d", len(fused))
+	}
+	// 坐标按置信度加权平均：(0.9 * 0 + 0.6 * 1) / 1.5 = 0.4
+	b := fused[0]
+	expected := []float32{0.4, 0.4, 10.4, 10.4}
+	actual := []float32{b.x1, b.y1, b.x2, b.y2}
+	for i := range expected {
+		if math.Abs(float64(actual[i]-expected[i])) > 1e-5 {
+			t.Fatalf("Expected fused box %v, got %v", expected, actual)
+		}
+	}
+	// 置信度为平均值 0.75 乘以 2 / 3
+	if (b.classID != 0) || (math.Abs(float64(b.confidence)-0.5) > 1e-6) {
+		t.Errorf("Got incorrect fused box: %s", b.String())
+	}
+	if (fused[1].classID != 2) || (fused[2].classID != 1) {
+		t.Errorf("Got incorrect order of fused boxes: %v", fused)
+	}
+
+	// 不区分类别时，第三次检测的框也被合并
+	options.classAgnostic = true
+	options.maxDetections = 1
+	fused = weightedBoxFusion(passes, 0.55, options)
+	if (len(fused) != 1) || (math.Abs(float64(fused[0].confidence)-0.6) >
+		1e-6) {
+		t.Errorf("Expected one fused box with confidence 0.6, got %v", fused)
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// analyticsConfig 是 -zones 指定的 JSON 配置文件，定义区域和越线计数用的
+// 线段。坐标均为原始图像中的像素坐标。
+type analyticsConfig struct {
+	// 判断目标位置时使用的点："bottom_center"（默认，检测框底边的中点，
+	// 适合站在地面上的人和车辆）或 "center"
+	Anchor string       `json:"anchor"`
+	Zones  []zoneConfig `json:"zones"`
+	Lines  []lineConfig `json:"lines"`
+}
+
+// zoneConfig 定义一个多边形区域
+type zoneConfig struct {
+	Name string `json:"name"`
+	// 多边形的顶点 [[x, y], ...]，至少 3 个
+	Polygon [][2]float64 `json:"polygon"`
+	// 只统计这些类别的目标，为空时统计所有类别
+	Classes []string `json:"classes"`
+}
+
+// lineConfig 定义一条用于越线计数的线段
+type lineConfig struct {
+	Name string `json:"name"`
+	// 线段的两个端点 [[x1, y1], [x2, y2]]。从第一个端点看向第二个端点时，
+	// 目标从左侧移动到右侧计为 left_to_right，反之计为 right_to_left。
+	Points [][2]float64 `json:"points"`
+	// 只统计这些类别的目标，为空时统计所有类别
+	Classes []string `json:"classes"`
+}
+
+// loadAnalyticsConfig 读取并检查 path 中的区域配置
+func loadAnalyticsConfig(path string) (*analyticsConfig, error) {
+	f, e := os.Open(path)
+	if e != nil {
+		return nil, fmt.Errorf("Error opening zone config %s: %w", path, e)
+	}
+	defer f.Close()
+	var toReturn analyticsConfig
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	e = decoder.Decode(&toReturn)
+	if e != nil {
+		return nil, fmt.Errorf("Error parsing zone config %s: %w", path, e)
+	}
+	e = toReturn.validate()
+	if e != nil {
+		return nil, fmt.Errorf("Invalid zone config %s: %w", path, e)
+	}
+	return &toReturn, nil
+}
+
+// validate 检查配置是否有效，并填充默认值
+func (c *analyticsConfig) validate() error {
+	switch c.Anchor {
+	case "":
+		c.Anchor = "bottom_center"
+	case "bottom_center", "center":
+	default:
+		return fmt.Errorf("Unknown anchor: %s", c.Anchor)
+	}
+	if (len(c.Zones) == 0) && (len(c.Lines) == 0) {
+		return fmt.Errorf("No zones or lines are defined")
+	}
+	names := make(map[string]bool)
+	checkName := func(name string) error {
+		if name == "" {
+			return fmt.Errorf("Every zone and line needs a name")
+		}
+		if names[name] {
+			return fmt.Errorf("Duplicate zone or line name: %s", name)
+		}
+		names[name] = true
+		return nil
+	}
+	for _, z := range c.Zones {
+		e := checkName(z.Name)
+		if e != nil {
+			return e
+		}
+		if len(z.Polygon) < 3 {
+			return fmt.Errorf("Zone %s needs at least 3 points", z.Name)
+		}
+	}
+	for _, l := range c.Lines {
+		e := checkName(l.Name)
+		if e != nil {
+			return e
+		}
+		if (len(l.Points) != 2) || (l.Points[0] == l.Points[1]) {
+			return fmt.Errorf("Line %s needs 2 distinct points", l.Name)
+		}
+	}
+	return nil
+}
+
+// anchorPoint 返回 b 中用于判断位置的点
+func (c *analyticsConfig) anchorPoint(b *boundingBox) [2]float64 {
+	x := float64(b.x1+b.x2) / 2
+	if c.Anchor == "center" {
+		return [2]float64{x, float64(b.y1+b.y2) / 2}
+	}
+	return [2]float64{x, float64(b.y2)}
+}
+
+// includesClass 返回 classes 是否包含 label，classes 为空时包含所有类别
+func includesClass(classes []string, label string) bool {
+	if len(classes) == 0 {
+		return true
+	}
+	for _, c := range classes {
+		if c == label {
+			return true
+		}
+	}
+	return false
+}
+
+// pointInPolygon 使用射线法判断 p 是否在多边形内
+func pointInPolygon(p [2]float64, polygon [][2]float64) bool {
+	inside := false
+	j := len(polygon) - 1
+	for i := range polygon {
+		a, b := polygon[i], polygon[j]
+		if ((a[1] > p[1]) != (b[1] > p[1])) &&
+			(p[0] < (b[0]-a[0])*(p[1]-a[1])/(b[1]-a[1])+a[0]) {
+			inside = !inside
+		}
+		j = i
+	}
+	return inside
+}
+
+// sideOfLine 返回 p 相对于从 a 指向 b 的直线的位置。在 y 轴向下的图像坐标
+// 中，结果为正表示 p 在右侧，为负表示在左侧，为 0 表示在直线上。
+func sideOfLine(a, b, p [2]float64) float64 {
+	return (b[0]-a[0])*(p[1]-a[1]) - (b[1]-a[1])*(p[0]-a[0])
+}
+
+// lineCrossing 判断目标从 from 移动到 to 时是否穿过线段 ab，返回 1 表示从
+// 左侧到右侧，-1 表示从右侧到左侧，0 表示没有穿过。from 不能在直线上。
+func lineCrossing(a, b, from, to [2]float64) int {
+	sideFrom := sideOfLine(a, b, from)
+	sideTo := sideOfLine(a, b, to)
+	if (sideFrom == 0) || (sideTo == 0) || ((sideFrom > 0) == (sideTo > 0)) {
+		return 0
+	}
+	// 还要求 a 和 b 在移动轨迹的两侧，即交点在线段 ab 上而不是其延长线上
+	if sideOfLine(from, to, a)*sideOfLine(from, to, b) > 0 {
+		return 0
+	}
+	if sideTo > 0 {
+		return 1
+	}
+	return -1
+}
+
+// jsonZoneResult 是一个区域在一帧中的统计结果
+type jsonZoneResult struct {
+	Name string `json:"name"`
+	// 区域内的目标数量及按类别的数量
+	Count  int            `json:"count"`
+	Counts map[string]int `json:"counts"`
+	// 区域内的目标在 detections 中的下标
+	Detections []int `json:"detections"`
+	// 跟踪时区域内目标的轨迹编号
+	TrackIDs []int `json:"track_ids,omitempty"`
+}
+
+// jsonDirectionCounts 是一条线段在两个方向上的越线次数
+type jsonDirectionCounts struct {
+	LeftToRight int `json:"left_to_right"`
+	RightToLeft int `json:"right_to_left"`
+}
+
+// jsonCrossing 是一次越线
+type jsonCrossing struct {
+	TrackID int    `json:"track_id"`
+	Label   string `json:"label"`
+	// "left_to_right" 或 "right_to_left"
+	Direction string `json:"direction"`
+}
+
+// jsonLineResult 是一条线段在一帧中的越线情况和从开始到这一帧为止的累计
+// 次数
+type jsonLineResult struct {
+	Name      string                          `json:"name"`
+	Crossings []jsonCrossing                  `json:"crossings"`
+	Totals    map[string]*jsonDirectionCounts `json:"totals"`
+}
+
+// jsonAnalytics 是一帧中所有区域和线段的统计结果
+type jsonAnalytics struct {
+	Zones []jsonZoneResult `json:"zones,omitempty"`
+	Lines []jsonLineResult `json:"lines,omitempty"`
+}
+
+// anchorPoints 返回每个检测框用于判断位置的点
+func (c *analyticsConfig) anchorPoints(boxes []boundingBox) [][2]float64 {
+	toReturn := make([][2]float64, len(boxes))
+	for i := range boxes {
+		toReturn[i] = c.anchorPoint(&boxes[i])
+	}
+	return toReturn
+}
+
+// countZones 统计每个区域内的目标，anchors 为 boxes 的锚点
+func (c *analyticsConfig) countZones(boxes []boundingBox,
+	anchors [][2]float64) []jsonZoneResult {
+	var toReturn []jsonZoneResult
+	for _, zone := range c.Zones {
+		result := jsonZoneResult{
+			Name:       zone.Name,
+			Counts:     make(map[string]int),
+			Detections: []int{},
+		}
+		for i := range boxes {
+			b := &boxes[i]
+			if !includesClass(zone.Classes, b.label) ||
+				!pointInPolygon(anchors[i], zone.Polygon) {
+				continue
+			}
+			result.Count++
+			result.Counts[b.label]++
+			result.Detections = append(result.Detections, i)
+			if b.trackID != 0 {
+				result.TrackIDs = append(result.TrackIDs, b.trackID)
+			}
+		}
+		toReturn = append(toReturn, result)
+	}
+	return toReturn
+}
+
+// imageAnalytics 统计单张图片中每个区域内的目标。单张图片没有轨迹，因此
+// 不统计越线次数。
+func (c *analyticsConfig) imageAnalytics(
+	boxes []boundingBox) *jsonAnalytics {
+	return &jsonAnalytics{Zones: c.countZones(boxes, c.anchorPoints(boxes))}
+}
+
+// trackPosition 是一条轨迹最近一次不在计数线上的锚点
+type trackPosition struct {
+	point [2]float64
+	frame int
+}
+
+// lineCounter 保存一条线段的越线计数状态
+type lineCounter struct {
+	config *lineConfig
+	// 每条轨迹上一次的位置
+	positions map[int]trackPosition
+	// 按类别累计的越线次数
+	totals map[string]*jsonDirectionCounts
+}
+
+// zoneAnalytics 对连续帧的检测结果统计区域内的目标和越线次数
+type zoneAnalytics struct {
+	config *analyticsConfig
+	lines  []lineCounter
+	// 超过这么多帧没有出现的轨迹不再记录位置
+	maxTrackGap int
+	frame       int
+}
+
+// newZoneAnalytics 返回使用 config 的统计器。maxTrackGap 应与跟踪器保留
+// 丢失轨迹的帧数相同，在这期间重新出现的目标仍然可以被计为越线。
+func newZoneAnalytics(config *analyticsConfig,
+	maxTrackGap int) *zoneAnalytics {
+	toReturn := &zoneAnalytics{
+		config:      config,
+		lines:       make([]lineCounter, len(config.Lines)),
+		maxTrackGap: maxTrackGap,
+	}
+	for i := range config.Lines {
+		toReturn.lines[i] = lineCounter{
+			config:    &config.Lines[i],
+			positions: make(map[int]trackPosition),
+			totals:    make(map[string]*jsonDirectionCounts),
+		}
+	}
+	return toReturn
+}
+
+// update 统计一帧的检测结果。越线计数需要轨迹编号，没有 trackID 的检测框
+// 只参与区域统计。
+func (z *zoneAnalytics) update(boxes []boundingBox) *jsonAnalytics {
+	z.frame++
+	anchors := z.config.anchorPoints(boxes)
+	toReturn := &jsonAnalytics{Zones: z.config.countZones(boxes, anchors)}
+
+	for i := range z.lines {
+		line := &z.lines[i]
+		a, b := line.config.Points[0], line.config.Points[1]
+		result := jsonLineResult{
+			Name:      line.config.Name,
+			Crossings: []jsonCrossing{},
+			Totals:    line.totals,
+		}
+		for j := range boxes {
+			box := &boxes[j]
+			if (box.trackID == 0) ||
+				!includesClass(line.config.Classes, box.label) {
+				continue
+			}
+			// 在直线上的位置无法判断方向，保留上一次的位置
+			if sideOfLine(a, b, anchors[j]) == 0 {
+				continue
+			}
+			previous, ok := line.positions[box.trackID]
+			line.positions[box.trackID] = trackPosition{
+				point: anchors[j],
+				frame: z.frame,
+			}
+			if !ok {
+				continue
+			}
+			direction := lineCrossing(a, b, previous.point, anchors[j])
+			if direction == 0 {
+				continue
+			}
+			counts := line.totals[box.label]
+			if counts == nil {
+				counts = &jsonDirectionCounts{}
+				line.totals[box.label] = counts
+			}
+			crossing := jsonCrossing{TrackID: box.trackID, Label: box.label}
+			if direction > 0 {
+				counts.LeftToRight++
+				crossing.Direction = "left_to_right"
+			} else {
+				counts.RightToLeft++
+				crossing.Direction = "right_to_left"
+			}
+			result.Crossings = append(result.Crossings, crossing)
+		}
+		for id, p := range line.positions {
+			if z.frame-p.frame > z.maxTrackGap {
+				delete(line.positions, id)
+			}
+		}
+		toReturn.Lines = append(toReturn.Lines, result)
+	}
+	return toReturn
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPointInPolygon(t *testing.T) {
+	// 凹多边形：缺少右上角的 L 形
+	polygon := [][2]float64{{0, 0}, {5, 0}, {5, 5}, {10, 5}, {10, 10},
+		{0, 10}}
+	tests := []struct {
+		p      [2]float64
+		inside bool
+	}{
+		{[2]float64{2, 2}, true},
+		{[2]float64{7, 7}, true},
+		{[2]float64{7, 2}, false},
+		{[2]float64{-1, 5}, false},
+		{[2]float64{5, 11}, false},
+	}
+	for _, test := range tests {
+		if pointInPolygon(test.p, polygon) != test.inside {
+			t.Errorf("Expected pointInPolygon(%v) to be %v", test.p,
+				test.inside)
+		}
+	}
+}
+
+func TestLineCrossing(t *testing.T) {
+	// 从左向右的水平线段，在图像坐标中其右侧是下方
+	a, b := [2]float64{0, 10}, [2]float64{20, 10}
+	tests := []struct {
+		from, to [2]float64
+		expected int
+	}{
+		{[2]float64{5, 5}, [2]float64{5, 15}, 1},
+		{[2]float64{5, 15}, [2]float64{6, 5}, -1},
+		{[2]float64{5, 5}, [2]float64{8, 8}, 0},
+		// 穿过直线，但在线段之外
+		{[2]float64{25, 5}, [2]float64{25, 15}, 0},
+		// 移动到直线上不算越线
+		{[2]float64{5, 5}, [2]float64{5, 10}, 0},
+	}
+	for _, test := range tests {
+		actual := lineCrossing(a, b, test.from, test.to)
+		if actual != test.expected {
+			t.Errorf("Expected moving from %v to %v to give %d, got %d",
+				test.from, test.to, test.expected, actual)
+		}
+	}
+}
+
+func TestLoadAnalyticsConfig(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name, content, errorText string
+	}{
+		{"valid", `{"zones": [{"name": "shelf", "polygon": [[0, 0], ` +
+			`[10, 0], [10, 10]]}], "lines": [{"name": "door", ` +
+			`"points": [[0, 5], [10, 5]], "classes": ["person"]}]}`, ""},
+		{"unknown field", `{"zone": []}`, "unknown field"},
+		{"empty", `{}`, "No zones or lines"},
+		{"bad anchor", `{"anchor": "top", "lines": [{"name": "a", ` +
+			`"points": [[0, 0], [1, 1]]}]}`, "Unknown anchor"},
+		{"duplicate name", `{"zones": [{"name": "a", "polygon": [[0, 0], ` +
+			`[1, 0], [1, 1]]}], "lines": [{"name": "a", "points": ` +
+			`[[0, 0], [1, 1]]}]}`, "Duplicate"},
+		{"short line", `{"lines": [{"name": "a", "points": [[1, 1], ` +
+			`[1, 1]]}]}`, "2 distinct points"},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, strings.ReplaceAll(test.name, " ", "_"))
+		e := os.WriteFile(path, []byte(test.content), 0644)
+		if e != nil {
+			t.Fatalf("Error writing %s: %s", path, e)
+		}
+		config, e := loadAnalyticsConfig(path)
+		if test.errorText == "" {
+			if e != nil {
+				t.Errorf("%s: unexpected error: %s", test.name, e)
+			} else if config.Anchor != "bottom_center" {
+				t.Errorf("%s: expected the default anchor, got %s",
+					test.name, config.Anchor)
+			}
+			continue
+		}
+		if (e == nil) || !strings.Contains(e.Error(), test.errorText) {
+			t.Errorf("%s: expected an error containing %q, got %v",
+				test.name, test.errorText, e)
+		}
+	}
+}
+
+func TestZoneAnalytics(t *testing.T) {
+	config := &analyticsConfig{
+		Zones: []zoneConfig{{
+			Name:    "left half",
+			Polygon: [][2]float64{{0, 0}, {50, 0}, {50, 100}, {0, 100}},
+		}},
+		Lines: []lineConfig{{
+			Name:    "door",
+			Points:  [][2]float64{{0, 60}, {100, 60}},
+			Classes: []string{"person"},
+		}},
+	}
+	e := config.validate()
+	if e != nil {
+		t.Fatalf("Invalid config: %s", e)
+	}
+	analytics := newZoneAnalytics(config, 5)
+	// 一个人向下走过计数线，一辆车也穿过但不在统计的类别中
+	person := func(y float32) boundingBox {
+		b := newTestBox(0, 0.9, 10, y-40, 30, y)
+		b.trackID = 1
+		return b
+	}
+	car := func(y float32) boundingBox {
+		b := newTestBox(2, 0.9, 60, y-40, 90, y)
+		b.trackID = 2
+		return b
+	}
+	frames := [][]boundingBox{
+		{person(40), car(50)},
+		{person(55), car(50)},
+		{person(70), car(100)},
+	}
+	var result *jsonAnalytics
+	for _, boxes := range frames {
+		result = analytics.update(boxes)
+	}
+	zone := result.Zones[0]
+	if (zone.Count != 1) || !reflect.DeepEqual(zone.TrackIDs, []int{1}) ||
+		(zone.Counts["person"] != 1) {
+		t.Errorf("Got incorrect zone result: %+v", zone)
+	}
+	line := result.Lines[0]
+	expected := []jsonCrossing{{TrackID: 1, Label: "person",
+		Direction: "left_to_right"}}
+	if !reflect.DeepEqual(line.Crossings, expected) {
+		t.Errorf("Expected crossings %v, got %v", expected, line.Crossings)
+	}
+	if (len(line.Totals) != 1) || (line.Totals["person"].LeftToRight != 1) {
+		t.Errorf("Got incorrect totals: %v", line.Totals)
+	}
+
+	// 同一个人走回去，累计两个方向各一次
+	result = analytics.update([]boundingBox{person(50)})
+	totals := result.Lines[0].Totals["person"]
+	if (totals.LeftToRight != 1) || (totals.RightToLeft != 1) {
+		t.Errorf("Expected one crossing in each direction, got %+v", totals)
+	}
+}
+
+func TestImageAnalytics(t *testing.T) {
+	config := &analyticsConfig{
+		Anchor: "center",
+		Zones: []zoneConfig{{
+			Name:    "cars",
+			Polygon: [][2]float64{{0, 0}, {100, 0}, {100, 50}, {0, 50}},
+			Classes: []string{"car"},
+		}},
+	}
+	boxes := []boundingBox{
+		newTestBox(2, 0.9, 10, 10, 30, 30),
+		newTestBox(2, 0.8, 10, 60, 30, 80),
+		newTestBox(0, 0.7, 50, 10, 60, 30),
+	}
+	result := newJSONImageResult(&imageResult{
+		path:      "a.jpg",
+		width:     100,
+		height:    100,
+		boxes:     boxes,
+		analytics: config.imageAnalytics(boxes),
+	})
+	if (result.Analytics == nil) || (len(result.Analytics.Zones) != 1) {
+		t.Fatalf("Expected the per-image result to contain one zone, "+
+			"got %+v", result.Analytics)
+	}
+	zone := result.Analytics.Zones[0]
+	if (zone.Count != 1) || !reflect.DeepEqual(zone.Detections, []int{0}) ||
+		(zone.TrackIDs != nil) {
+		t.Errorf("Got incorrect zone result: %+v", zone)
+	}
+	if len(result.Analytics.Lines) != 0 {
+		t.Errorf("Expected no line results, got %+v", result.Analytics.Lines)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// DefaultNegThresholdOffset 是 Silero VAD 参考实现中结束阈值相对于开始阈值的
+// 偏移：语音概率低于 threshold - 0.15 时才认为语音可能结束
+const DefaultNegThresholdOffset = 0.15
+
+// SegmenterConfig 语音分段参数
+type SegmenterConfig struct {
+	SampleRate   int     // 采样率
+	Threshold    float32 // 语音概率不低于该值时认为窗口包含语音
+	WindowSizeMs int     // 每个概率对应的窗口大小（毫秒）
+	SpeechPadMs  int     // 语音段前后的填充（毫秒）
+	MinSpeechMs  int     // 最小语音持续时间（毫秒）
+	MinSilenceMs int     // 最小静音持续时间（毫秒）
+	// 最大语音持续时间（秒），不大于 0 时不限制。更长的语音段会在其中的静音处
+	// 被切分。
+	MaxSpeechSec float32
+	// 语音概率低于 Threshold - NegThresholdOffset 时才开始计算静音，两个阈值
+	// 之间的概率不改变状态。注意零值表示没有滞后，与参考实现不同，因此应当从
+	// DefaultSegmenterConfig() 开始修改需要的参数。
+	NegThresholdOffset float32
+}
+
+// DefaultSegmenterConfig 返回 Silero VAD 参考实现的默认参数：16kHz 采样，每个
+// 窗口 32ms（512 个采样），不限制最大语音持续时间
+func DefaultSegmenterConfig() SegmenterConfig {
+	return SegmenterConfig{
+		SampleRate:         16000,
+		Threshold:          0.5,
+		WindowSizeMs:       32,
+		SpeechPadMs:        30,
+		MinSpeechMs:        250,
+		MinSilenceMs:       100,
+		NegThresholdOffset: DefaultNegThresholdOffset,
+	}
+}
+
+// Segmenter 语音分段状态机。它不依赖任何模型，每次传入一个窗口的语音概率，
+// 根据滞后判决确定语音段的开始和结束，因此也可以与其他 VAD 模型（例如
+// WebRTC 或基于能量的检测）一起使用。Segmenter 不能被多个 goroutine 同时使用。
+type Segmenter struct {
+	threshold          float32
+	negThreshold       float32
+	windowSizeSamples  int
+	speechPadSamples   int
+	minSpeechDuration  int
+	minSilenceDuration int
+	maxSpeechDuration  int
+	triggered          bool
+	tempEnd            int
+	currentSample      int
+	prevEnd            int
+	nextStart          int
+	speeches           []Timestamp
+	currentSpeech      Timestamp
+	// 事件回调，为 nil 时语音段保存在 speeches 中
+	onEvent func(SpeechEvent)
+}
+
+// NewSegmenter 创建语音分段状态机。onEvent 不为 nil 时，语音段的开始和结束
+// 在确定时立即通过 onEvent 通知，不再保存到 Speeches() 中，也不加填充。
+func NewSegmenter(config SegmenterConfig, onEvent func(SpeechEvent)) (*Segmenter, error) {
+	if (config.SampleRate <= 0) || (config.SampleRate%1000 != 0) {
+		return nil, fmt.Errorf("采样率必须是 1000 的正整数倍: %d", config.SampleRate)
+	}
+	if config.WindowSizeMs <= 0 {
+		return nil, fmt.Errorf("窗口大小必须为正数: %d", config.WindowSizeMs)
+	}
+	if (config.Threshold <= 0) || (config.Threshold > 1) {
+		return nil, fmt.Errorf("阈值必须在 (0, 1] 范围内: %f", config.Threshold)
+	}
+	if (config.NegThresholdOffset < 0) || (config.NegThresholdOffset > config.Threshold) {
+		return nil, fmt.Errorf("结束阈值偏移必须在 [0, %f] 范围内: %f", config.Threshold, config.NegThresholdOffset)
+	}
+	srPerMs := config.SampleRate / 1000
+	maxSpeechDuration := math.MaxInt
+	if config.MaxSpeechSec > 0 {
+		// 与参考实现相同，最大持续时间中不包括一个窗口和两侧的填充
+		maxSpeechDuration = int(float32(config.SampleRate)*config.MaxSpeechSec) - config.WindowSizeMs*srPerMs - 2*config.SpeechPadMs*srPerMs
+		if maxSpeechDuration <= 0 {
+			return nil, fmt.Errorf("最大语音持续时间 %f 秒必须大于一个窗口加上两倍的填充", config.MaxSpeechSec)
+		}
+	}
+	return &Segmenter{
+		threshold:          config.Threshold,
+		negThreshold:       config.Threshold - config.NegThresholdOffset,
+		windowSizeSamples:  config.WindowSizeMs * srPerMs,
+		speechPadSamples:   config.SpeechPadMs * srPerMs,
+		minSpeechDuration:  config.MinSpeechMs * srPerMs,
+		minSilenceDuration: config.MinSilenceMs * srPerMs,
+		maxSpeechDuration:  maxSpeechDuration,
+		onEvent:            onEvent,
+	}, nil
+}
+
+// WindowSizeSamples 返回每个概率对应的窗口大小（采样点）
+func (s *Segmenter) WindowSizeSamples() int {
+	return s.windowSizeSamples
+}
+
+// Push 传入下一个窗口的语音概率，并更新语音段的检测状态
+func (s *Segmenter) Push(speechProb float32) {
+	s.currentSample += s.windowSizeSamples
+	s.update(speechProb)
+}
+
+// Finish 在整段音频处理完后结束最后一个语音段，并为所有语音段加上填充。
+// audioLengthSamples 为音频的总采样数。
+func (s *Segmenter) Finish(audioLengthSamples int) {
+	s.finishStream(audioLengthSamples)
+	padSpeeches(s.speeches, s.speechPadSamples, audioLengthSamples)
+}
+
+// Speeches 返回 Finish 之后检测到的语音段
+func (s *Segmenter) Speeches() []Timestamp {
+	return s.speeches
+}
+
+// Reset 清空检测状态和已检测到的语音段，采样位置重新从 0 开始计算
+func (s *Segmenter) Reset() {
+	s.triggered = false
+	s.tempEnd = 0
+	s.currentSample = 0
+	s.prevEnd = 0
+	s.nextStart = 0
+	s.speeches = s.speeches[:0]
+	s.currentSpeech = Timestamp{}
+}
+
+// withEvents 返回参数与 s 相同、状态为初始状态的 Segmenter，产生的事件交给
+// onEvent
+func (s *Segmenter) withEvents(onEvent func(SpeechEvent)) *Segmenter {
+	toReturn := *s
+	toReturn.speeches = nil
+	toReturn.onEvent = onEvent
+	toReturn.Reset()
+	return &toReturn
+}
+
+// startSpeech 从采样点 start 开始一个新的语音段
+func (s *Segmenter) startSpeech(start int) {
+	s.triggered = true
+	s.currentSpeech.Start = start
+	s.emit(SpeechEvent{Type: SpeechStart, Sample: start})
+}
+
+// finishSpeech 以采样点 end 结束当前语音段并清空当前语音段
+func (s *Segmenter) finishSpeech(end int) {
+	s.currentSpeech.End = end
+	speech := s.currentSpeech
+	s.currentSpeech = Timestamp{}
+	if s.onEvent != nil {
+		s.emit(SpeechEvent{Type: SpeechEnd, Sample: end, Speech: speech})
+		return
+	}
+	s.speeches = append(s.speeches, speech)
+}
+
+// emit 将事件交给回调函数，没有回调函数时什么也不做
+func (s *Segmenter) emit(event SpeechEvent) {
+	if s.onEvent != nil {
+		s.onEvent(event)
+	}
+}
+
+// update 根据当前窗口的语音概率更新语音段的检测状态
+func (s *Segmenter) update(speechProb float32) {
+	if speechProb >= s.threshold {
+		if s.tempEnd != 0 {
+			s.tempEnd = 0
+			if s.nextStart < s.prevEnd {
+				s.nextStart = s.currentSample - s.windowSizeSamples
+			}
+		}
+		if !s.triggered {
+			s.startSpeech(s.currentSample - s.windowSizeSamples)
+		}
+		return
+	}
+
+	// 如果语音段太长
+	if s.triggered && ((s.currentSample - s.currentSpeech.Start) > s.maxSpeechDuration) {
+		if s.prevEnd > 0 {
+			nextStart := s.nextStart
+			s.finishSpeech(s.prevEnd)
+			if nextStart < s.prevEnd {
+				s.triggered = false
+			} else {
+				// 新的语音段从静音之后的位置开始
+				s.startSpeech(nextStart)
+			}
+			s.prevEnd = 0
+			s.nextStart = 0
+			s.tempEnd = 0
+		} else {
+			s.finishSpeech(s.currentSample)
+			s.prevEnd = 0
+			s.nextStart = 0
+			s.tempEnd = 0
+			s.triggered = false
+		}
+		return
+	}
+
+	if (speechProb >= s.negThreshold) && (speechProb < s.threshold) {
+		// 当语音概率暂时下降但仍然在语音中时，不改变状态
+		return
+	}
+
+	if (speechProb < s.negThreshold) && s.triggered {
+		if s.tempEnd == 0 {
+			s.tempEnd = s.currentSample
+		}
+		if s.currentSample-s.tempEnd > s.minSilenceDuration {
+			s.prevEnd = s.tempEnd
+		}
+		if (s.currentSample - s.tempEnd) >= s.minSilenceDuration {
+			if s.tempEnd-s.currentSpeech.Start > s.minSpeechDuration {
+				s.finishSpeech(s.tempEnd)
+				s.prevEnd = 0
+				s.nextStart = 0
+				s.tempEnd = 0
+				s.triggered = false
+			} else {
+				s.currentSpeech.End = s.tempEnd
+			}
+		}
+	}
+}
+
+// finishStream 在音频结束时结束仍在进行的语音段，end 为音频的总采样数。与参考
+// 实现相同，到音频结束时仍不长于最短语音时长的语音段被丢弃。
+func (s *Segmenter) finishStream(end int) {
+	if s.triggered {
+		if end-s.currentSpeech.Start > s.minSpeechDuration {
+			s.finishSpeech(end)
+		} else {
+			s.currentSpeech = Timestamp{}
+			s.emit(SpeechEvent{Type: SpeechDiscard, Sample: end})
+		}
+	}
+	s.prevEnd = 0
+	s.nextStart = 0
+	s.tempEnd = 0
+	s.triggered = false
+}
+
+// padSpeeches 与 Silero VAD 的参考实现相同，将每个语音段向前后各扩展
+// padSamples 个采样，并限制在 [0, audioLengthSamples] 范围内。相邻的两个语音段
+// 之间的静音短于 2*padSamples 时，静音被两个语音段平分，使它们不会重叠。
+func padSpeeches(speeches []Timestamp, padSamples int, audioLengthSamples int) {
+	for i := range speeches {
+		speech := &speeches[i]
+		if i == 0 {
+			speech.Start = max(0, speech.Start-padSamples)
+		}
+		if i == len(speeches)-1 {
+			speech.End = min(audioLengthSamples, speech.End+padSamples)
+			break
+		}
+		next := &speeches[i+1]
+		silence := next.Start - speech.End
+		if silence < 2*padSamples {
+			speech.End += silence / 2
+			next.Start = max(0, next.Start-silence/2)
+		} else {
+			speech.End = min(audioLengthSamples, speech.End+padSamples)
+			next.Start = max(0, next.Start-padSamples)
+		}
+	}
+}
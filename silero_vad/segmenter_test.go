@@ -0,0 +1,214 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testSegmenterConfig 返回 16kHz、窗口为 512 个采样的参数，最短语音 250ms，
+// 最短静音 100ms，不限制最长语音
+func testSegmenterConfig(speechPadMs int) SegmenterConfig {
+	config := DefaultSegmenterConfig()
+	config.SpeechPadMs = speechPadMs
+	return config
+}
+
+// newTestSegmenter 使用 config 创建 Segmenter，出错时结束测试
+func newTestSegmenter(t *testing.T, config SegmenterConfig,
+	onEvent func(SpeechEvent)) *Segmenter {
+	s, err := NewSegmenter(config, onEvent)
+	if err != nil {
+		t.Fatalf("Error creating segmenter: %s", err)
+	}
+	return s
+}
+
+// runProbabilities 将 probs 作为每个窗口的语音概率驱动 s 的状态机，返回
+// 加上填充后的语音段
+func runProbabilities(s *Segmenter, probs []float32) []Timestamp {
+	for _, p := range probs {
+		s.Push(p)
+	}
+	s.Finish(len(probs) * s.WindowSizeSamples())
+	return s.Speeches()
+}
+
+// repeatProbability 返回 n 个值为 p 的概率，用于拼接测试序列
+func repeatProbability(p float32, n int) []float32 {
+	toReturn := make([]float32, n)
+	for i := range toReturn {
+		toReturn[i] = p
+	}
+	return toReturn
+}
+
+func TestSpeechPadding(t *testing.T) {
+	// 两段各 20 个窗口的语音，之间隔 10 个窗口的静音
+	var probs []float32
+	probs = append(probs, repeatProbability(0, 10)...)
+	probs = append(probs, repeatProbability(0.9, 20)...)
+	probs = append(probs, repeatProbability(0, 10)...)
+	probs = append(probs, repeatProbability(0.9, 20)...)
+	probs = append(probs, repeatProbability(0, 10)...)
+
+	tests := []struct {
+		speechPadMs int
+		expected    []Timestamp
+	}{
+		{0, []Timestamp{{5120, 15872}, {20480, 31232}}},
+		{30, []Timestamp{{4640, 16352}, {20000, 31712}}},
+		// 静音只有 4608 个采样，不足两倍填充，由两个语音段平分
+		{150, []Timestamp{{2720, 18176}, {18176, 33632}}},
+	}
+	for _, test := range tests {
+		s := newTestSegmenter(t, testSegmenterConfig(test.speechPadMs), nil)
+		actual := runProbabilities(s, probs)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Expected speeches %v with %dms padding, got %v",
+				test.expected, test.speechPadMs, actual)
+		}
+	}
+}
+
+func TestSpeechPaddingClampedToAudio(t *testing.T) {
+	s := newTestSegmenter(t, testSegmenterConfig(100), nil)
+	actual := runProbabilities(s, repeatProbability(0.9, 10))
+	expected := []Timestamp{{0, 5120}}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected speeches %v, got %v", expected, actual)
+	}
+}
+
+func TestPadSpeeches(t *testing.T) {
+	tests := []struct {
+		name     string
+		speeches []Timestamp
+		pad      int
+		length   int
+		expected []Timestamp
+	}{
+		{"empty", nil, 100, 1000, nil},
+		{"single", []Timestamp{{200, 500}}, 100, 1000,
+			[]Timestamp{{100, 600}}},
+		{"clamped", []Timestamp{{50, 980}}, 100, 1000,
+			[]Timestamp{{0, 1000}}},
+		{"separate", []Timestamp{{200, 300}, {600, 700}}, 100, 1000,
+			[]Timestamp{{100, 400}, {500, 800}}},
+		{"split gap", []Timestamp{{200, 300}, {450, 700}}, 100, 1000,
+			[]Timestamp{{100, 375}, {375, 800}}},
+		{"three", []Timestamp{{100, 200}, {250, 400}, {420, 500}}, 50, 520,
+			[]Timestamp{{50, 225}, {225, 410}, {410, 520}}},
+	}
+	for _, test := range tests {
+		padSpeeches(test.speeches, test.pad, test.length)
+		if !reflect.DeepEqual(test.speeches, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected,
+				test.speeches)
+		}
+	}
+}
+
+func TestNegThresholdOffset(t *testing.T) {
+	// 语音中间有 10 个概率为 0.4 的窗口，介于两个阈值之间时不算静音
+	var probs []float32
+	probs = append(probs, repeatProbability(0.9, 10)...)
+	probs = append(probs, repeatProbability(0.4, 10)...)
+	probs = append(probs, repeatProbability(0.9, 10)...)
+	tests := []struct {
+		offset   float32
+		expected []Timestamp
+	}{
+		{DefaultNegThresholdOffset, []Timestamp{{0, 15360}}},
+		{0.05, []Timestamp{{0, 5632}, {10240, 15360}}},
+	}
+	for _, test := range tests {
+		config := testSegmenterConfig(0)
+		config.NegThresholdOffset = test.offset
+		s := newTestSegmenter(t, config, nil)
+		actual := runProbabilities(s, probs)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Expected speeches %v with offset %f, got %v",
+				test.expected, test.offset, actual)
+		}
+	}
+}
+
+func TestMaxSpeechDuration(t *testing.T) {
+	config := testSegmenterConfig(0)
+	config.MaxSpeechSec = 1
+	s := newTestSegmenter(t, config, nil)
+	// 超过最长语音时长后没有足够长的静音，语音段在第一个低概率的窗口之后
+	// 切分
+	var probs []float32
+	probs = append(probs, repeatProbability(0.9, 40)...)
+	probs = append(probs, repeatProbability(0, 1)...)
+	probs = append(probs, repeatProbability(0.9, 9)...)
+	actual := runProbabilities(s, probs)
+	expected := []Timestamp{{0, 20992}, {20992, 25600}}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected speeches %v, got %v", expected, actual)
+	}
+}
+
+func TestUnlimitedMaxSpeechDuration(t *testing.T) {
+	for _, maxSpeechSec := range []float32{0, -1} {
+		config := testSegmenterConfig(0)
+		config.MaxSpeechSec = maxSpeechSec
+		s := newTestSegmenter(t, config, nil)
+		// 很长的语音中偶尔有低概率的窗口，不会被切分
+		var probs []float32
+		for i := 0; i < 10; i++ {
+			probs = append(probs, repeatProbability(0.9, 40)...)
+			probs = append(probs, 0)
+		}
+		actual := runProbabilities(s, probs)
+		expected := []Timestamp{{0, len(probs) * 512}}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Expected speeches %v with MaxSpeechSec = %f, got %v",
+				expected, maxSpeechSec, actual)
+		}
+	}
+}
+
+func TestSegmenterEvents(t *testing.T) {
+	var events []SpeechEvent
+	s := newTestSegmenter(t, testSegmenterConfig(30), func(e SpeechEvent) {
+		events = append(events, e)
+	})
+	var probs []float32
+	probs = append(probs, repeatProbability(0, 10)...)
+	probs = append(probs, repeatProbability(0.9, 20)...)
+	probs = append(probs, repeatProbability(0, 10)...)
+	runProbabilities(s, probs)
+	// 事件中的位置不加填充，语音段也不再保存
+	expected := []SpeechEvent{
+		{Type: SpeechStart, Sample: 5120},
+		{Type: SpeechEnd, Sample: 15872, Speech: Timestamp{5120, 15872}},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected events %v, got %v", expected, events)
+	}
+	if len(s.Speeches()) != 0 {
+		t.Errorf("Expected no saved speeches, got %v", s.Speeches())
+	}
+}
+
+func TestNewSegmenterErrors(t *testing.T) {
+	tests := []func(c *SegmenterConfig){
+		func(c *SegmenterConfig) { c.SampleRate = 16500 },
+		func(c *SegmenterConfig) { c.WindowSizeMs = 0 },
+		func(c *SegmenterConfig) { c.Threshold = 0 },
+		func(c *SegmenterConfig) { c.NegThresholdOffset = -0.1 },
+		func(c *SegmenterConfig) { c.NegThresholdOffset = 0.6 },
+		// 不足一个窗口加上两倍的填充
+		func(c *SegmenterConfig) { c.MaxSpeechSec = 0.05 },
+	}
+	for i, modify := range tests {
+		config := testSegmenterConfig(30)
+		modify(&config)
+		_, err := NewSegmenter(config, nil)
+		if err == nil {
+			t.Errorf("Test %d: didn't get an error for config %+v", i, config)
+		}
+	}
+}
@@ -14,7 +14,8 @@ type Timestamp struct {
 	End   int // 结束时间（采样点）
 }
 
-// VadIterator 语音活动检测迭代器
+// VadIterator 语音活动检测迭代器，使用 Silero VAD 模型计算每个窗口的语音概率，
+// 由 Segmenter 将概率转换为语音段
 type VadIterator struct {
 	session             *onnx.AdvancedSession
 	input               *onnx.Tensor[float32]
@@ -27,42 +28,29 @@ type VadIterator struct {
 	stateNodeDims       []int64
 	srNodeDims          []int64
 	effectiveWindowSize int
-	windowSize          int
 	sampleRate          int
-	speechPadMs         int
-	speechPadSamples    int
-	threshold           float32
-	minSpeechDuration   int
-	minSilenceDuration  int
-	maxSpeechDuration   int
-	srPerMs             int
 	windowSizeSamples   int
 	contextSamples      int
 	context             []float32
-	triggered           bool
-	tempEnd             int
-	currentSample       int
-	prevEnd             int
-	nextStart           int
-	speeches            []Timestamp
-	currentSpeech       Timestamp
+	segmenter           *Segmenter
 	sr                  *onnx.Tensor[int64]
 }
 
-// NewVadIterator 创建新的语音活动检测迭代器
-func NewVadIterator(modelPath string, sampleRate int, threshold float32, windowSizeMs int, speechPadMs int, minSpeechMs int, minSilenceMs int, maxSpeechSec float32) (*VadIterator, error) {
+// NewVadIterator 创建新的语音活动检测迭代器。config 中的分段参数以前是单独的
+// 参数，通常应从 DefaultSegmenterConfig() 开始修改，以免 NegThresholdOffset 等
+// 字段的零值改变检测结果。
+func NewVadIterator(modelPath string, config SegmenterConfig) (*VadIterator, error) {
+	segmenter, err := NewSegmenter(config, nil)
+	if err != nil {
+		return nil, err
+	}
 	vad := &VadIterator{
-		sampleRate:         sampleRate,
-		threshold:          threshold,
-		speechPadMs:        speechPadMs,
-		minSpeechDuration:  minSpeechMs * sampleRate / 1000,
-		minSilenceDuration: minSilenceMs * sampleRate / 1000,
-		maxSpeechDuration:  int(float32(sampleRate)*maxSpeechSec) - windowSizeMs*sampleRate/1000 - 2*speechPadMs*sampleRate/1000,
+		sampleRate: config.SampleRate,
+		segmenter:  segmenter,
 	}
 
 	// 计算采样率相关参数
-	vad.srPerMs = sampleRate / 1000
-	vad.windowSizeSamples = windowSizeMs * vad.srPerMs
+	vad.windowSizeSamples = segmenter.WindowSizeSamples()
 	vad.contextSamples = 64
 	vad.effectiveWindowSize = vad.windowSizeSamples + vad.contextSamples
 
@@ -78,7 +66,6 @@ func NewVadIterator(modelPath string, sampleRate int, threshold float32, windowS
 	// 初始化采样率张量
 	vad.srData = make([]int64, 1)
 	vad.srData[0] = int64(vad.sampleRate)
-	var err error
 	vad.sr, err = onnx.NewTensor(vad.srNodeDims, vad.srData)
 	if err != nil {
 		return nil, fmt.Errorf("创建采样率张量失败: %w", err)
@@ -152,8 +139,14 @@ func (v *VadIterator) initSession(modelPath string) error {
 	return nil
 }
 
-// predict 执行一次推理
-func (v *VadIterator) predict(dataChunk []float32) (float32, error) {
+// Predict 对一个窗口（windowSizeSamples 个采样）运行模型并返回其中包含语音的
+// 概率。模型的循环状态和上下文会在调用之间保留，因此必须按顺序传入连续的
+// 窗口。Predict 只运行模型，不会更新语音段的检测状态。
+func (v *VadIterator) Predict(dataChunk []float32) (float32, error) {
+	if len(dataChunk) != v.windowSizeSamples {
+		return 0, fmt.Errorf("输入块长度错误: 期望 %d, 实际 %d", v.windowSizeSamples, len(dataChunk))
+	}
+
 	// 构建新的输入数据：前contextSamples个样本来自context，后面是当前块
 	newData := make([]float32, v.effectiveWindowSize)
 	copy(newData[:v.contextSamples], v.context)
@@ -182,86 +175,19 @@ func (v *VadIterator) predict(dataChunk []float32) (float32, error) {
 	}
 	copy(v.stateData, stateNData)
 
-	// 更新当前采样点
-	v.currentSample += v.windowSizeSamples
-
-	// 处理检测结果
-	speechProb := outputData[0]
-	if speechProb >= v.threshold {
-		if v.tempEnd != 0 {
-			v.tempEnd = 0
-			if v.nextStart < v.prevEnd {
-				v.nextStart = v.currentSample - v.windowSizeSamples
-			}
-		}
-		if !v.triggered {
-			v.triggered = true
-			v.currentSpeech.Start = v.currentSample - v.windowSizeSamples
-		}
-		// 更新上下文
-		copy(v.context, newData[len(newData)-v.contextSamples:])
-		return speechProb, nil
-	}
-
-	// 如果语音段太长
-	if v.triggered && ((v.currentSample - v.currentSpeech.Start) > v.maxSpeechDuration) {
-		if v.prevEnd > 0 {
-			v.currentSpeech.End = v.prevEnd
-			v.speeches = append(v.speeches, v.currentSpeech)
-			v.currentSpeech = Timestamp{}
-			if v.nextStart < v.prevEnd {
-				v.triggered = false
-			} else {
-				v.currentSpeech.Start = v.nextStart
-			}
-			v.prevEnd = 0
-			v.nextStart = 0
-			v.tempEnd = 0
-		} else {
-			v.currentSpeech.End = v.currentSample
-			v.speeches = append(v.speeches, v.currentSpeech)
-			v.currentSpeech = Timestamp{}
-			v.prevEnd = 0
-			v.nextStart = 0
-			v.tempEnd = 0
-			v.triggered = false
-		}
-		// 更新上下文
-		copy(v.context, newData[len(newData)-v.contextSamples:])
-		return speechProb, nil
-	}
-
-	if (speechProb >= (v.threshold - 0.15)) && (speechProb < v.threshold) {
-		// 当语音概率暂时下降但仍然在语音中时，只更新上下文
-		copy(v.context, newData[len(newData)-v.contextSamples:])
-		return speechProb, nil
-	}
+	// 更新上下文
+	copy(v.context, newData[len(newData)-v.contextSamples:])
+	return outputData[0], nil
+}
 
-	if speechProb < (v.threshold - 0.15) {
-		if v.triggered {
-			if v.tempEnd == 0 {
-				v.tempEnd = v.currentSample
-			}
-			if v.currentSample-v.tempEnd > v.minSilenceDuration {
-				v.prevEnd = v.tempEnd
-			}
-			if (v.currentSample - v.tempEnd) >= v.minSilenceDuration {
-				v.currentSpeech.End = v.tempEnd
-				if v.currentSpeech.End-v.currentSpeech.Start > v.minSpeechDuration {
-					v.speeches = append(v.speeches, v.currentSpeech)
-					v.currentSpeech = Timestamp{}
-					v.prevEnd = 0
-					v.nextStart = 0
-					v.tempEnd = 0
-					v.triggered = false
-				}
-			}
-		}
-		// 更新上下文
-		copy(v.context, newData[len(newData)-v.contextSamples:])
-		return speechProb, nil
+// predict 执行一次推理，并根据语音概率更新语音段的检测状态
+func (v *VadIterator) predict(dataChunk []float32) (float32, error) {
+	speechProb, err := v.Predict(dataChunk)
+	if err != nil {
+		return 0, err
 	}
 
+	v.segmenter.Push(speechProb)
 	return speechProb, nil
 }
 
@@ -282,37 +208,30 @@ func (v *VadIterator) Process(inputWav []float32) error {
 		}
 	}
 
-	// 处理最后一个语音段
-	if v.currentSpeech.Start >= 0 {
-		v.currentSpeech.End = audioLengthSamples
-		v.speeches = append(v.speeches, v.currentSpeech)
-		v.currentSpeech = Timestamp{}
-		v.prevEnd = 0
-		v.nextStart = 0
-		v.tempEnd = 0
-		v.triggered = false
-	}
-
+	v.segmenter.Finish(audioLengthSamples)
 	return nil
 }
 
 // GetSpeechTimestamps 获取检测到的语音时间戳
 func (v *VadIterator) GetSpeechTimestamps() []Timestamp {
-	return v.speeches
+	return v.segmenter.Speeches()
 }
 
 // resetStates 重置内部状态
 func (v *VadIterator) resetStates() {
+	v.resetModel()
+	v.segmenter.Reset()
+}
+
+// resetModel 重置模型的循环状态和上下文
+func (v *VadIterator) resetModel() {
 	for i := range v.stateData {
 		v.stateData[i] = 0
 	}
-	v.triggered = false
-	v.tempEnd = 0
-	v.currentSample = 0
-	v.prevEnd = 0
-	v.nextStart = 0
-	v.speeches = v.speeches[:0]
-	v.currentSpeech = Timestamp{}
+	// 模型的循环状态保存在 stateN 张量中
+	if v.stateN != nil {
+		clear(v.stateN.GetData())
+	}
 	for i := range v.context {
 		v.context[i] = 0
 	}
@@ -367,16 +286,9 @@ func main() {
 	}
 
 	// 创建 VAD 迭代器
-	vad, err := NewVadIterator(
-		"./model/silero_vad.onnx",
-		16000, // 采样率
-		0.5,   // 阈值
-		32,    // 窗口大小（毫秒）
-		30,    // 语音填充（毫秒）
-		250,   // 最小语音持续时间（毫秒）
-		100,   // 最小静音持续时间（毫秒）
-		30.0,  // 最大语音持续时间（秒）
-	)
+	config := DefaultSegmenterConfig()
+	config.MaxSpeechSec = 30.0 // 最大语音持续时间（秒）
+	vad, err := NewVadIterator("./model/silero_vad.onnx", config)
 	if err != nil {
 		log.Fatalf("创建 VAD 迭代器失败: %v", err)
 	}
@@ -397,6 +309,29 @@ func main() {
 		fmt.Printf("检测到语音从 %.1f 秒到 %.1f 秒\n", startSec, endSec)
 	}
 
-	// 重置内部状态
-	vad.resetStates()
+	// 以流式接口处理同一段音频，模拟从麦克风逐块读取数据
+	stream, err := vad.NewStream(func(event SpeechEvent) {
+		switch event.Type {
+		case SpeechStart:
+			fmt.Printf("流式检测：语音开始于 %.1f 秒\n", float64(event.Sample)/sampleRateFloat)
+		case SpeechEnd:
+			fmt.Printf("流式检测：语音结束于 %.1f 秒（%.1f 秒到 %.1f 秒）\n", float64(event.Sample)/sampleRateFloat,
+				float64(event.Speech.Start)/sampleRateFloat, float64(event.Speech.End)/sampleRateFloat)
+		case SpeechDiscard:
+			fmt.Printf("流式检测：音频结束，丢弃过短的语音段\n")
+		}
+	})
+	if err != nil {
+		log.Fatalf("创建流式检测器失败: %v", err)
+	}
+	data := wavReader.Data()
+	const chunkSize = 1000
+	for start := 0; start < len(data); start += chunkSize {
+		if err := stream.Write(data[start:min(start+chunkSize, len(data))]); err != nil {
+			log.Fatalf("流式处理音频失败: %v", err)
+		}
+	}
+	if err := stream.Flush(); err != nil {
+		log.Fatalf("流式处理音频失败: %v", err)
+	}
 }
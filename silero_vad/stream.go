@@ -0,0 +1,143 @@
+package main
+
+import "fmt"
+
+// SpeechEventType 语音事件类型
+type SpeechEventType int
+
+const (
+	// SpeechStart 表示检测到一个语音段的开始
+	SpeechStart SpeechEventType = iota
+	// SpeechEnd 表示一个语音段已经结束
+	SpeechEnd
+	// SpeechDiscard 表示音频流结束时，已经开始的语音段短于最短语音时长而被
+	// 丢弃，不会再有对应的 SpeechEnd 事件
+	SpeechDiscard
+)
+
+// String 返回事件类型的名称
+func (t SpeechEventType) String() string {
+	switch t {
+	case SpeechStart:
+		return "SpeechStart"
+	case SpeechEnd:
+		return "SpeechEnd"
+	case SpeechDiscard:
+		return "SpeechDiscard"
+	}
+	return fmt.Sprintf("SpeechEventType(%d)", int(t))
+}
+
+// SpeechEvent 流式检测产生的语音事件
+type SpeechEvent struct {
+	Type SpeechEventType
+	// 事件对应的位置（采样点），从流开始时计算。SpeechStart 为语音段的开始，
+	// SpeechEnd 为语音段的结束，SpeechDiscard 为音频流的结束。
+	Sample int
+	// SpeechEnd 事件对应的完整语音段，SpeechStart 事件中为空。流式检测在语音段
+	// 结束时无法知道下一个语音段的位置，因此事件中的位置不包含 SpeechPadMs 的
+	// 填充，需要时由调用者自行扩展。
+	Speech Timestamp
+}
+
+// VadStream 流式语音活动检测。调用者可以推入任意长度的音频块，VadStream 将其
+// 缓冲为 windowSizeSamples 大小的窗口逐个检测，并在滞后判决确定语音段的开始
+// 和结束时立即调用事件回调。VadStream 不能被多个 goroutine 同时使用。
+type VadStream struct {
+	// 计算一个窗口的语音概率
+	predict func(chunk []float32) (float32, error)
+	// 重置模型的循环状态和上下文
+	resetModel func()
+	// 流式检测器自己的分段状态机，不影响 Process 的结果
+	segmenter *Segmenter
+	// 不足一个窗口的剩余采样
+	buffer []float32
+	// 从流开始（或上一次 Flush）以来推入的采样总数
+	pushedSamples int
+}
+
+// NewStream 使用 v 的模型和参数创建流式检测器，onEvent 在产生事件时被调用，
+// 不能为 nil。如果需要通过通道接收事件，可以在 onEvent 中将事件发送到通道。
+// 流式检测器有自己的分段状态，但与 v 共享模型的循环状态，因此在使用流式检测器
+// 期间调用 v.Process 会重置模型状态，使流式检测的结果不准确。
+func (v *VadIterator) NewStream(onEvent func(SpeechEvent)) (*VadStream, error) {
+	if onEvent == nil {
+		return nil, fmt.Errorf("事件回调函数不能为空")
+	}
+	v.resetModel()
+	return newVadStream(v.Predict, v.resetModel, v.segmenter.withEvents(onEvent)), nil
+}
+
+// newVadStream 创建使用 predict 计算语音概率、由 segmenter 产生事件的流式检测器
+func newVadStream(predict func([]float32) (float32, error), resetModel func(), segmenter *Segmenter) *VadStream {
+	return &VadStream{
+		predict:    predict,
+		resetModel: resetModel,
+		segmenter:  segmenter,
+		buffer:     make([]float32, 0, segmenter.WindowSizeSamples()),
+	}
+}
+
+// process 检测一个完整的窗口
+func (s *VadStream) process(window []float32) error {
+	speechProb, err := s.predict(window)
+	if err != nil {
+		return err
+	}
+	s.segmenter.Push(speechProb)
+	return nil
+}
+
+// Write 推入任意长度的音频数据（与 Process 相同的 [-1, 1] 浮点采样）。每凑满
+// 一个窗口就运行一次模型，因此事件回调会在 Write 返回前被调用。
+func (s *VadStream) Write(samples []float32) error {
+	windowSize := s.segmenter.WindowSizeSamples()
+	s.pushedSamples += len(samples)
+
+	// 先补全上一次剩余的窗口
+	if len(s.buffer) > 0 {
+		n := min(windowSize-len(s.buffer), len(samples))
+		s.buffer = append(s.buffer, samples[:n]...)
+		samples = samples[n:]
+		if len(s.buffer) < windowSize {
+			return nil
+		}
+		err := s.process(s.buffer)
+		s.buffer = s.buffer[:0]
+		if err != nil {
+			return err
+		}
+	}
+
+	// 直接处理完整的窗口，不复制数据
+	for len(samples) >= windowSize {
+		err := s.process(samples[:windowSize])
+		if err != nil {
+			return err
+		}
+		samples = samples[windowSize:]
+	}
+	s.buffer = append(s.buffer, samples...)
+	return nil
+}
+
+// Flush 表示音频流结束：剩余不足一个窗口的采样补零后进行检测，仍在进行的语音
+// 段以推入的最后一个采样结束并产生 SpeechEnd 事件（短于最短语音时长时产生
+// SpeechDiscard 事件）。之后流式检测器被重置，可以用于新的音频流，采样位置
+// 重新从 0 开始计算。
+func (s *VadStream) Flush() error {
+	if len(s.buffer) > 0 {
+		padded := make([]float32, s.segmenter.WindowSizeSamples())
+		copy(padded, s.buffer)
+		s.buffer = s.buffer[:0]
+		err := s.process(padded)
+		if err != nil {
+			return err
+		}
+	}
+	s.segmenter.finishStream(s.pushedSamples)
+	s.pushedSamples = 0
+	s.segmenter.Reset()
+	s.resetModel()
+	return nil
+}
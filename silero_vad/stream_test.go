@@ -0,0 +1,168 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testStreamAudio 返回每个窗口的采样都等于该窗口语音概率的音频，之后再加上
+// tail 个值为 tailValue 的采样
+func testStreamAudio(windowSize int, probs []float32, tail int,
+	tailValue float32) []float32 {
+	var toReturn []float32
+	for _, p := range probs {
+		toReturn = append(toReturn, repeatProbability(p, windowSize)...)
+	}
+	return append(toReturn, repeatProbability(tailValue, tail)...)
+}
+
+// testStream 是使用桩概率来源的 VadStream，窗口的语音概率为窗口的第一个
+// 采样，并记录传给模型的每个窗口
+type testStream struct {
+	*VadStream
+	events  []SpeechEvent
+	windows [][]float32
+	resets  int
+}
+
+func newTestStream(t *testing.T, config SegmenterConfig) *testStream {
+	toReturn := &testStream{}
+	segmenter := newTestSegmenter(t, config, func(e SpeechEvent) {
+		toReturn.events = append(toReturn.events, e)
+	})
+	predict := func(chunk []float32) (float32, error) {
+		toReturn.windows = append(toReturn.windows, chunk)
+		return chunk[0], nil
+	}
+	toReturn.VadStream = newVadStream(predict, func() { toReturn.resets++ },
+		segmenter)
+	return toReturn
+}
+
+// writeInChunks 以每块 chunkSize 个采样推入 audio，最后调用 Flush
+func (s *testStream) writeInChunks(t *testing.T, audio []float32,
+	chunkSize int) {
+	for start := 0; start < len(audio); start += chunkSize {
+		err := s.Write(audio[start:min(start+chunkSize, len(audio))])
+		if err != nil {
+			t.Fatalf("Error writing samples: %s", err)
+		}
+	}
+	err := s.Flush()
+	if err != nil {
+		t.Fatalf("Error flushing the stream: %s", err)
+	}
+}
+
+func TestVadStreamChunkSizes(t *testing.T) {
+	var probs []float32
+	probs = append(probs, repeatProbability(0, 10)...)
+	probs = append(probs, repeatProbability(0.9, 20)...)
+	probs = append(probs, repeatProbability(0, 10)...)
+	probs = append(probs, repeatProbability(0.9, 20)...)
+	// 最后 300 个采样不足一个窗口，Flush 时补零
+	audio := testStreamAudio(512, probs, 300, 0.9)
+	expected := []SpeechEvent{
+		{Type: SpeechStart, Sample: 5120},
+		{Type: SpeechEnd, Sample: 15872, Speech: Timestamp{5120, 15872}},
+		{Type: SpeechStart, Sample: 20480},
+		{Type: SpeechEnd, Sample: len(audio),
+			Speech: Timestamp{20480, len(audio)}},
+	}
+	// 小于、等于、大于一个窗口，以及跨越窗口边界的块
+	for _, chunkSize := range []int{1, 100, 511, 512, 700, 1500, len(audio)} {
+		s := newTestStream(t, testSegmenterConfig(30))
+		s.writeInChunks(t, audio, chunkSize)
+		if !reflect.DeepEqual(s.events, expected) {
+			t.Errorf("Expected events %v with %d-sample chunks, got %v",
+				expected, chunkSize, s.events)
+		}
+		if len(s.windows) != len(probs)+1 {
+			t.Fatalf("Expected %d windows with %d-sample chunks, got %d",
+				len(probs)+1, chunkSize, len(s.windows))
+		}
+		last := s.windows[len(s.windows)-1]
+		if (len(last) != 512) || (last[299] != 0.9) || (last[300] != 0) {
+			t.Errorf("The final partial window wasn't padded with zeros")
+		}
+	}
+}
+
+func TestVadStreamZeroCopy(t *testing.T) {
+	s := newTestStream(t, testSegmenterConfig(0))
+	audio := testStreamAudio(512, []float32{0, 0, 0}, 100, 0)
+	err := s.Write(audio[:1124])
+	if err != nil {
+		t.Fatalf("Error writing samples: %s", err)
+	}
+	// 两个完整的窗口直接使用输入的数据，剩余的 100 个采样被缓冲
+	if (len(s.windows) != 2) || (&s.windows[0][0] != &audio[0]) ||
+		(&s.windows[1][0] != &audio[512]) {
+		t.Errorf("Expected the full windows to be passed without copying")
+	}
+	err = s.Write(audio[1124:])
+	if err != nil {
+		t.Fatalf("Error writing samples: %s", err)
+	}
+	if (len(s.windows) != 3) || (&s.windows[2][0] == &audio[1024]) {
+		t.Errorf("Expected the straddling window to be buffered")
+	}
+}
+
+func TestVadStreamReuse(t *testing.T) {
+	var probs []float32
+	probs = append(probs, repeatProbability(0, 5)...)
+	probs = append(probs, repeatProbability(0.9, 20)...)
+	audio := testStreamAudio(512, probs, 0, 0)
+	s := newTestStream(t, testSegmenterConfig(0))
+	s.writeInChunks(t, audio, 1000)
+	first := s.events
+	s.events = nil
+	s.writeInChunks(t, audio, 1000)
+	// Flush 之后采样位置重新从 0 开始，模型状态也被重置
+	if !reflect.DeepEqual(first, s.events) || (s.resets != 2) {
+		t.Errorf("Got events %v after reusing the stream, expected %v "+
+			"(%d resets)", s.events, first, s.resets)
+	}
+}
+
+func TestVadStreamDiscardsShortTrailingSpeech(t *testing.T) {
+	var probs []float32
+	probs = append(probs, repeatProbability(0, 20)...)
+	probs = append(probs, repeatProbability(0.9, 3)...)
+	audio := testStreamAudio(512, probs, 0, 0)
+	s := newTestStream(t, testSegmenterConfig(0))
+	s.writeInChunks(t, audio, 512)
+	expected := []SpeechEvent{
+		{Type: SpeechStart, Sample: 10240},
+		{Type: SpeechDiscard, Sample: len(audio)},
+	}
+	if !reflect.DeepEqual(s.events, expected) {
+		t.Errorf("Expected events %v, got %v", expected, s.events)
+	}
+
+	// 整段处理时同样丢弃
+	segmenter := newTestSegmenter(t, testSegmenterConfig(0), nil)
+	speeches := runProbabilities(segmenter, probs)
+	if len(speeches) != 0 {
+		t.Errorf("Expected no speeches, got %v", speeches)
+	}
+}
+
+func TestSegmenterWithEvents(t *testing.T) {
+	batch := newTestSegmenter(t, testSegmenterConfig(0), nil)
+	var events []SpeechEvent
+	stream := batch.withEvents(func(e SpeechEvent) {
+		events = append(events, e)
+	})
+	probs := repeatProbability(0.9, 10)
+	for _, p := range probs {
+		stream.Push(p)
+	}
+	// 流式检测器的事件不影响原来的 Segmenter 保存语音段
+	speeches := runProbabilities(batch, probs)
+	if (len(events) != 1) || !reflect.DeepEqual(speeches,
+		[]Timestamp{{0, 5120}}) {
+		t.Errorf("Got events %v and speeches %v", events, speeches)
+	}
+}
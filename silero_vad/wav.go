@@ -1,31 +1,90 @@
 package main
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"os"
 )
 
-// WavHeader WAV 文件头结构
-type WavHeader struct {
-	Riff           [4]byte // "RIFF"
-	Size           uint32  // 文件大小
-	Wave           [4]byte // "WAVE"
-	Fmt            [4]byte // "fmt "
-	FmtSize        uint32  // fmt 块大小
-	Format         uint16  // 音频格式
-	Channels       uint16  // 声道数
-	SampleRate     uint32  // 采样率
-	BytesPerSecond uint32  // 每秒字节数
-	BlockSize      uint16  // 数据块大小
-	BitsPerSample  uint16  // 采样位数
-	Data           [4]byte // "data"
-	DataSize       uint32  // 数据大小
+// WAV 文件中的音频格式标记
+const (
+	WaveFormatPCM        = 0x0001
+	WaveFormatIEEEFloat  = 0x0003
+	WaveFormatALaw       = 0x0006
+	WaveFormatMuLaw      = 0x0007
+	WaveFormatExtensible = 0xFFFE
+)
+
+// subformatGUIDSuffix 是 WAVE_FORMAT_EXTENSIBLE 子格式 GUID 中格式标记之后的
+// 14 个字节（KSDATAFORMAT_SUBTYPE_* 的公共部分）
+var subformatGUIDSuffix = []byte{0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00,
+	0x00, 0xaa, 0x00, 0x38, 0x9b, 0x71}
+
+// 解析 WAV 文件时可能返回的错误，可以用 errors.Is 判断
+var (
+	ErrNotRiff     = errors.New("不是 RIFF 文件")
+	ErrNotWave     = errors.New("不是 WAVE 文件")
+	ErrMissingFmt  = errors.New("缺少 fmt 块")
+	ErrMissingData = errors.New("缺少 data 块")
+	ErrMissingDs64 = errors.New("RF64 文件缺少 ds64 块")
+)
+
+// ChunkError 表示某个块的内容错误或不完整
+type ChunkError struct {
+	ID     string // 块标识，例如 "fmt "
+	Offset int64  // 块头在文件中的位置
+	Err    error
+}
+
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("解析位于 %d 的 %q 块失败: %v", e.Offset, e.ID, e.Err)
+}
+
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
+
+// FormatError 表示不支持的音频格式
+type FormatError struct {
+	FormatTag     uint16
+	BitsPerSample int
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("不支持的音频格式: 格式 0x%04X, 量化位数 %d", e.FormatTag, e.BitsPerSample)
+}
+
+// WavFormat fmt 块中的音频格式
+type WavFormat struct {
+	// 音频格式。WAVE_FORMAT_EXTENSIBLE 文件中为子格式 GUID 中的格式标记，
+	// 此时 Extensible 为 true。
+	FormatTag      uint16
+	Extensible     bool
+	Channels       int // 声道数
+	SampleRate     int // 采样率
+	BytesPerSecond int // 每秒字节数
+	BlockAlign     int // 每帧（所有声道的一个采样）的字节数
+	BitsPerSample  int // 每个采样在文件中占用的位数
+	// 每个采样中的有效位数，只有 WAVE_FORMAT_EXTENSIBLE 文件中可能小于
+	// BitsPerSample
+	ValidBitsPerSample int
+	ChannelMask        uint32 // 声道位置，只有 WAVE_FORMAT_EXTENSIBLE 文件中有
+}
+
+// wavLayout 是解析 WAV 文件的块得到的格式和音频数据的位置
+type wavLayout struct {
+	format     WavFormat
+	dataOffset int64
+	dataSize   int64
 }
 
 // WavReader WAV 文件读取器
 type WavReader struct {
-	header        WavHeader
+	format        WavFormat
 	data          []float32
 	numChannels   int
 	sampleRate    int
@@ -40,91 +99,350 @@ func (w *WavReader) Open(filename string) error {
 		return fmt.Errorf("打开文件失败: %w", err)
 	}
 	defer file.Close()
+	return w.Load(file)
+}
+
+// Load 从 r 读取 WAV 文件。r 的当前位置不影响读取，文件的开头为 r 的位置 0。
+func (w *WavReader) Load(r io.ReadSeeker) error {
+	layout, err := parseWav(r)
+	if err != nil {
+		return err
+	}
+	if _, err := r.Seek(layout.dataOffset, io.SeekStart); err != nil {
+		return fmt.Errorf("定位到音频数据失败: %w", err)
+	}
+
+	// 在读取音频数据之前检查格式，避免为不支持的格式读取整个 data 块
+	decode, err := newSampleDecoder(&layout.format)
+	if err != nil {
+		return err
+	}
+	// 忽略末尾不完整的帧
+	numSamples := int(layout.dataSize / int64(layout.format.BlockAlign))
+
+	// 一次读取所有音频数据再解码，避免逐个采样读取
+	raw := make([]byte, numSamples*layout.format.BlockAlign)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return fmt.Errorf("读取音频数据失败: %w", err)
+	}
+	data := make([]float32, numSamples*layout.format.Channels)
+	decode(raw, data)
+
+	// 只在成功时设置音频参数
+	w.format = layout.format
+	w.numChannels = layout.format.Channels
+	w.sampleRate = layout.format.SampleRate
+	w.bitsPerSample = layout.format.BitsPerSample
+	w.numSamples = numSamples
+	w.data = data
+	return nil
+}
+
+// newSampleDecoder 返回将 format 格式的采样解码为 [-1, 1] 范围内浮点数的函数，
+// 不支持该格式时返回 *FormatError。整数 PCM 除以该位数的满量程，例如 16 位
+// 除以 2^15；8 位 PCM 是无符号的，以 128 为零点。
+func newSampleDecoder(format *WavFormat) (func(raw []byte, dst []float32), error) {
+	le := binary.LittleEndian
+	switch format.FormatTag {
+	case WaveFormatPCM:
+		switch format.BitsPerSample {
+		case 8:
+			return func(raw []byte, dst []float32) {
+				for i := range dst {
+					dst[i] = (float32(raw[i]) - 128) / 128
+				}
+			}, nil
+		case 16:
+			return func(raw []byte, dst []float32) {
+				for i := range dst {
+					dst[i] = float32(int16(le.Uint16(raw[2*i:]))) / (1 << 15)
+				}
+			}, nil
+		case 24:
+			return func(raw []byte, dst []float32) {
+				for i := range dst {
+					b := raw[3*i : 3*i+3]
+					// 将 24 位数放在高位，再右移得到带符号的值
+					sample := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+					dst[i] = float32(sample) / (1 << 23)
+				}
+			}, nil
+		case 32:
+			return func(raw []byte, dst []float32) {
+				for i := range dst {
+					dst[i] = float32(float64(int32(le.Uint32(raw[4*i:]))) / (1 << 31))
+				}
+			}, nil
+		}
+	case WaveFormatIEEEFloat:
+		switch format.BitsPerSample {
+		case 32:
+			return func(raw []byte, dst []float32) {
+				for i := range dst {
+					dst[i] = math.Float32frombits(le.Uint32(raw[4*i:]))
+				}
+			}, nil
+		case 64:
+			return func(raw []byte, dst []float32) {
+				for i := range dst {
+					dst[i] = float32(math.Float64frombits(le.Uint64(raw[8*i:])))
+				}
+			}, nil
+		}
+	case WaveFormatALaw, WaveFormatMuLaw:
+		if format.BitsPerSample != 8 {
+			break
+		}
+		toLinear := aLawToLinear
+		if format.FormatTag == WaveFormatMuLaw {
+			toLinear = muLawToLinear
+		}
+		return func(raw []byte, dst []float32) {
+			for i := range dst {
+				dst[i] = float32(toLinear(raw[i])) / (1 << 15)
+			}
+		}, nil
+	}
+	return nil, &FormatError{FormatTag: format.FormatTag, BitsPerSample: format.BitsPerSample}
+}
 
-	// 读取文件头
-	if err := binary.Read(file, binary.LittleEndian, &w.header); err != nil {
-		return fmt.Errorf("读取文件头失败: %w", err)
+// aLawToLinear 按 G.711 将一个 A-law 采样解码为 16 位线性 PCM
+func aLawToLinear(b byte) int16 {
+	b ^= 0x55
+	exponent := (b >> 4) & 0x07
+	sample := int16(b&0x0f)<<4 + 8
+	if exponent > 0 {
+		sample = (sample + 0x100) << (exponent - 1)
+	}
+	// A-law 中符号位为 1 表示正数
+	if b&0x80 == 0 {
+		return -sample
 	}
+	return sample
+}
 
-	// 检查 fmt 块大小
-	if w.header.FmtSize < 16 {
-		return fmt.Errorf("WAV 文件格式错误：fmt 块大小小于 16")
+// muLawToLinear 按 G.711 将一个 µ-law 采样解码为 16 位线性 PCM
+func muLawToLinear(b byte) int16 {
+	b = ^b
+	exponent := (b >> 4) & 0x07
+	sample := (int16(b&0x0f)<<3+0x84)<<exponent - 0x84
+	if b&0x80 != 0 {
+		return -sample
 	}
+	return sample
+}
 
-	// 如果 fmt 块大小大于 16，跳过额外的字节
-	if w.header.FmtSize > 16 {
-		offset := 44 - 8 + w.header.FmtSize - 16
-		file.Seek(int64(offset), 0)
+// parseWav 依次遍历 RIFF/WAVE 或 RF64 文件中的块，返回音频格式和 data 块的
+// 位置。未知的块（例如 LIST、bext）被跳过，奇数大小的块之后有一个填充字节。
+// data 块的大小为 0 或超出文件末尾时（边录制边写入的文件），音频数据延续到
+// 文件末尾。
+func parseWav(r io.ReadSeeker) (*wavLayout, error) {
+	fileSize, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("获取文件大小失败: %w", err)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("定位到文件开头失败: %w", err)
 	}
 
-	// 跳过 fmt 和 data 块之间的子块
-	for string(w.header.Data[:]) != "data" {
-		file.Seek(int64(w.header.DataSize), 1)
-		if _, err := file.Read(w.header.Data[:]); err != nil {
-			return fmt.Errorf("读取数据块标记失败: %w", err)
+	// 检查文件头
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrNotRiff
 		}
+		return nil, fmt.Errorf("读取文件头失败: %w", err)
+	}
+	riffID := string(header[0:4])
+	if (riffID != "RIFF") && (riffID != "RF64") {
+		return nil, ErrNotRiff
+	}
+	if string(header[8:12]) != "WAVE" {
+		return nil, ErrNotWave
 	}
+	rf64 := riffID == "RF64"
+
+	var layout wavLayout
+	var ds64 *ds64Chunk
+	foundFmt, foundData := false, false
+	offset := int64(len(header))
+	for offset+8 <= fileSize {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("定位到位置 %d 失败: %w", offset, err)
+		}
+		var chunkHeader [8]byte
+		if _, err := io.ReadFull(r, chunkHeader[:]); err != nil {
+			return nil, fmt.Errorf("读取位于 %d 的块头失败: %w", offset, err)
+		}
+		id := string(chunkHeader[0:4])
+		size := int64(binary.LittleEndian.Uint32(chunkHeader[4:8]))
+		body := offset + 8
 
-	// 如果数据大小为 0，使用文件剩余部分
-	if w.header.DataSize == 0 {
-		offset, _ := file.Seek(0, 1)
-		file.Seek(0, 2)
-		end, _ := file.Seek(0, 1)
-		w.header.DataSize = uint32(end - offset)
-		file.Seek(offset, 0)
-	}
-
-	// 设置音频参数
-	w.numChannels = int(w.header.Channels)
-	w.sampleRate = int(w.header.SampleRate)
-	w.bitsPerSample = int(w.header.BitsPerSample)
-	numData := int(w.header.DataSize) / (w.bitsPerSample / 8)
-	w.numSamples = numData / w.numChannels
-
-	// 读取音频数据
-	w.data = make([]float32, numData)
-	switch w.bitsPerSample {
-	case 8:
-		for i := 0; i < numData; i++ {
-			var sample uint8
-			if err := binary.Read(file, binary.LittleEndian, &sample); err != nil {
-				return fmt.Errorf("读取 8 位采样数据失败: %w", err)
+		// RF64 文件中超过 4GB 的块大小保存在 ds64 块中
+		if rf64 && (size == math.MaxUint32) {
+			if ds64 == nil {
+				return nil, &ChunkError{ID: id, Offset: offset, Err: ErrMissingDs64}
+			}
+			if id == "data" {
+				size = ds64.dataSize
+			} else if tableSize, ok := ds64.sizes[id]; ok {
+				size = tableSize
 			}
-			w.data[i] = float32(sample) / 32768.0
 		}
-	case 16:
-		for i := 0; i < numData; i++ {
-			var sample int16
-			if err := binary.Read(file, binary.LittleEndian, &sample); err != nil {
-				return fmt.Errorf("读取 16 位采样数据失败: %w", err)
+
+		if id == "data" {
+			if rf64 && (ds64 == nil) {
+				return nil, &ChunkError{ID: id, Offset: offset, Err: ErrMissingDs64}
+			}
+			if (size == 0) || (size > fileSize-body) {
+				size = fileSize - body
 			}
-			w.data[i] = float32(sample) / 32768.0
+			layout.dataOffset = body
+			layout.dataSize = size
+			foundData = true
+			if foundFmt {
+				return &layout, nil
+			}
+		} else if size > fileSize-body {
+			return nil, &ChunkError{ID: id, Offset: offset, Err: io.ErrUnexpectedEOF}
 		}
-	case 32:
-		if w.header.Format == 1 { // S32
-			for i := 0; i < numData; i++ {
-				var sample int32
-				if err := binary.Read(file, binary.LittleEndian, &sample); err != nil {
-					return fmt.Errorf("读取 32 位采样数据失败: %w", err)
-				}
-				w.data[i] = float32(sample) / 32768.0
+
+		switch id {
+		case "ds64":
+			if !rf64 {
+				break
 			}
-		} else if w.header.Format == 3 { // IEEE-float
-			for i := 0; i < numData; i++ {
-				var sample float32
-				if err := binary.Read(file, binary.LittleEndian, &sample); err != nil {
-					return fmt.Errorf("读取 32 位浮点采样数据失败: %w", err)
-				}
-				w.data[i] = sample
+			content, err := readChunk(r, size)
+			if err != nil {
+				return nil, &ChunkError{ID: id, Offset: offset, Err: err}
+			}
+			ds64, err = parseDs64Chunk(content)
+			if err != nil {
+				return nil, &ChunkError{ID: id, Offset: offset, Err: err}
+			}
+		case "fmt ":
+			content, err := readChunk(r, size)
+			if err != nil {
+				return nil, &ChunkError{ID: id, Offset: offset, Err: err}
+			}
+			layout.format, err = parseFmtChunk(content)
+			if err != nil {
+				return nil, &ChunkError{ID: id, Offset: offset, Err: err}
+			}
+			foundFmt = true
+			if foundData {
+				return &layout, nil
 			}
-		} else {
-			return fmt.Errorf("不支持的量化位数: %d", w.bitsPerSample)
 		}
-	default:
-		return fmt.Errorf("不支持的量化位数: %d", w.bitsPerSample)
+		offset = body + size + size&1
 	}
 
-	return nil
+	if !foundFmt {
+		return nil, ErrMissingFmt
+	}
+	return nil, ErrMissingData
+}
+
+// readChunk 从 r 的当前位置读取 size 字节的块内容
+func readChunk(r io.Reader, size int64) ([]byte, error) {
+	content := make([]byte, size)
+	if _, err := io.ReadFull(r, content); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return content, nil
+}
+
+// parseFmtChunk 解析 fmt 块的内容。WAVE_FORMAT_EXTENSIBLE 格式的 FormatTag 为
+// 子格式 GUID 中的格式标记。
+func parseFmtChunk(content []byte) (WavFormat, error) {
+	var f WavFormat
+	if len(content) < 16 {
+		return f, fmt.Errorf("fmt 块大小小于 16: %d", len(content))
+	}
+	le := binary.LittleEndian
+	f.FormatTag = le.Uint16(content[0:2])
+	f.Channels = int(le.Uint16(content[2:4]))
+	f.SampleRate = int(le.Uint32(content[4:8]))
+	f.BytesPerSecond = int(le.Uint32(content[8:12]))
+	f.BlockAlign = int(le.Uint16(content[12:14]))
+	f.BitsPerSample = int(le.Uint16(content[14:16]))
+	f.ValidBitsPerSample = f.BitsPerSample
+
+	if f.FormatTag == WaveFormatExtensible {
+		if (len(content) < 40) || (le.Uint16(content[16:18]) < 22) {
+			return f, fmt.Errorf("WAVE_FORMAT_EXTENSIBLE 的 fmt 块不完整")
+		}
+		if validBits := int(le.Uint16(content[18:20])); validBits != 0 {
+			f.ValidBitsPerSample = validBits
+		}
+		f.ChannelMask = le.Uint32(content[20:24])
+		subformat := content[24:40]
+		if !bytes.Equal(subformat[2:], subformatGUIDSuffix) {
+			return f, fmt.Errorf("未知的子格式 GUID: % x", subformat)
+		}
+		f.FormatTag = le.Uint16(subformat[0:2])
+		f.Extensible = true
+	}
+
+	if f.Channels == 0 {
+		return f, fmt.Errorf("声道数为 0")
+	}
+	if f.SampleRate == 0 {
+		return f, fmt.Errorf("采样率为 0")
+	}
+	if (f.BitsPerSample == 0) || (f.ValidBitsPerSample > f.BitsPerSample) {
+		return f, fmt.Errorf("量化位数错误: %d（有效位数 %d）", f.BitsPerSample, f.ValidBitsPerSample)
+	}
+	if f.BlockAlign != f.Channels*((f.BitsPerSample+7)/8) {
+		return f, fmt.Errorf("块对齐 %d 与 %d 个声道、%d 位的采样不一致", f.BlockAlign, f.Channels, f.BitsPerSample)
+	}
+	return f, nil
+}
+
+// ds64Chunk 是 RF64 文件的 ds64 块中保存的 64 位大小
+type ds64Chunk struct {
+	dataSize int64
+	// 其他大小超过 4GB 的块
+	sizes map[string]int64
+}
+
+// parseDs64Chunk 解析 ds64 块的内容
+func parseDs64Chunk(content []byte) (*ds64Chunk, error) {
+	if len(content) < 28 {
+		return nil, fmt.Errorf("ds64 块大小小于 28: %d", len(content))
+	}
+	le := binary.LittleEndian
+	// 前 8 个字节为 RIFF 块的大小，读取时不需要
+	dataSize := le.Uint64(content[8:16])
+	if dataSize > math.MaxInt64 {
+		return nil, fmt.Errorf("ds64 块中的大小超出范围")
+	}
+	toReturn := &ds64Chunk{
+		dataSize: int64(dataSize),
+		sizes:    make(map[string]int64),
+	}
+	tableLength := int(le.Uint32(content[24:28]))
+	table := content[28:]
+	if len(table)/12 < tableLength {
+		return nil, fmt.Errorf("ds64 块中的表不完整")
+	}
+	for i := 0; i < tableLength; i++ {
+		entry := table[i*12 : (i+1)*12]
+		size := le.Uint64(entry[4:12])
+		if size > math.MaxInt64 {
+			return nil, fmt.Errorf("ds64 块中的大小超出范围")
+		}
+		toReturn.sizes[string(entry[0:4])] = int64(size)
+	}
+	return toReturn, nil
+}
+
+// Format 返回 fmt 块中的音频格式
+func (w *WavReader) Format() WavFormat {
+	return w.format
 }
 
 // NumChannels 返回声道数
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// testChunk 是生成测试用 WAV 文件时的一个块
+type testChunk struct {
+	id   string
+	body []byte
+	// 不为 0 时写入块头的大小，而不是 body 的长度
+	size uint32
+}
+
+// buildWav 生成以 riffID（"RIFF" 或 "RF64"）开头的 WAVE 文件，奇数大小的块
+// 之后补一个填充字节
+func buildWav(riffID string, chunks ...testChunk) []byte {
+	var body bytes.Buffer
+	body.WriteString("WAVE")
+	for _, c := range chunks {
+		size := c.size
+		if size == 0 {
+			size = uint32(len(c.body))
+		}
+		body.WriteString(c.id)
+		binary.Write(&body, binary.LittleEndian, size)
+		body.Write(c.body)
+		if len(c.body)%2 != 0 {
+			body.WriteByte(0)
+		}
+	}
+	var toReturn bytes.Buffer
+	toReturn.WriteString(riffID)
+	riffSize := uint32(body.Len())
+	if riffID == "RF64" {
+		riffSize = math.MaxUint32
+	}
+	binary.Write(&toReturn, binary.LittleEndian, riffSize)
+	toReturn.Write(body.Bytes())
+	return toReturn.Bytes()
+}
+
+// fmtChunk 返回 16 字节的 fmt 块
+func fmtChunk(formatTag uint16, channels, sampleRate, bits int) testChunk {
+	blockAlign := channels * ((bits + 7) / 8)
+	return testChunk{id: "fmt ", body: littleEndianBytes(formatTag,
+		uint16(channels), uint32(sampleRate), uint32(sampleRate*blockAlign),
+		uint16(blockAlign), uint16(bits))}
+}
+
+// extensibleFmtChunk 返回 WAVE_FORMAT_EXTENSIBLE 的 fmt 块，子格式为 subformat
+func extensibleFmtChunk(subformat uint16, channels, sampleRate, bits,
+	validBits int) testChunk {
+	c := fmtChunk(WaveFormatExtensible, channels, sampleRate, bits)
+	c.body = append(c.body, littleEndianBytes(uint16(22), uint16(validBits),
+		uint32(3), subformat, subformatGUIDSuffix)...)
+	return c
+}
+
+// ds64Body 返回 RF64 文件中 ds64 块的内容
+func ds64Body(dataSize uint64) []byte {
+	return littleEndianBytes(uint64(0), dataSize, uint64(0), uint32(0))
+}
+
+// littleEndianBytes 依次返回 values 中每个值的小端字节
+func littleEndianBytes(values ...any) []byte {
+	var b bytes.Buffer
+	for _, v := range values {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	return b.Bytes()
+}
+
+func TestWavReaderLoad(t *testing.T) {
+	pcm16 := littleEndianBytes([]int16{0, 16384, -32768})
+	expected16 := []float32{0, 0.5, -1}
+	floats := littleEndianBytes([]float32{0.25, -0.5, 1, 0})
+
+	tests := []struct {
+		name       string
+		file       []byte
+		channels   int
+		sampleRate int
+		extensible bool
+		expected   []float32
+	}{
+		{"pcm16", buildWav("RIFF", fmtChunk(WaveFormatPCM, 1, 16000, 16),
+			testChunk{id: "data", body: pcm16}), 1, 16000, false, expected16},
+		{"LIST and bext chunks", buildWav("RIFF",
+			testChunk{id: "LIST", body: []byte("INFO")},
+			fmtChunk(WaveFormatPCM, 1, 8000, 16),
+			testChunk{id: "bext", body: make([]byte, 602)},
+			testChunk{id: "data", body: pcm16}), 1, 8000, false, expected16},
+		{"odd chunk padding", buildWav("RIFF",
+			fmtChunk(WaveFormatPCM, 1, 16000, 16),
+			testChunk{id: "junk", body: []byte{1, 2, 3}},
+			testChunk{id: "data", body: pcm16}), 1, 16000, false, expected16},
+		{"data before fmt", buildWav("RIFF",
+			testChunk{id: "data", body: pcm16},
+			fmtChunk(WaveFormatPCM, 1, 16000, 16)), 1, 16000, false,
+			expected16},
+		{"extensible float", buildWav("RIFF",
+			extensibleFmtChunk(WaveFormatIEEEFloat, 2, 48000, 32, 32),
+			testChunk{id: "data", body: floats}), 2, 48000, true,
+			[]float32{0.25, -0.5, 1, 0}},
+		{"streaming data size", buildWav("RIFF",
+			fmtChunk(WaveFormatPCM, 1, 16000, 16),
+			testChunk{id: "data", body: pcm16, size: math.MaxUint32}), 1,
+			16000, false, expected16},
+		{"partial frame", buildWav("RIFF",
+			fmtChunk(WaveFormatPCM, 2, 16000, 16),
+			testChunk{id: "data", body: pcm16}), 2, 16000, false,
+			[]float32{0, 0.5}},
+		{"rf64", buildWav("RF64", testChunk{id: "ds64", body: ds64Body(6)},
+			fmtChunk(WaveFormatPCM, 1, 16000, 16),
+			testChunk{id: "data", body: pcm16, size: math.MaxUint32}), 1,
+			16000, false, expected16},
+	}
+	for _, test := range tests {
+		var w WavReader
+		err := w.Load(bytes.NewReader(test.file))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if (w.NumChannels() != test.channels) ||
+			(w.SampleRate() != test.sampleRate) ||
+			(w.Format().Extensible != test.extensible) {
+			t.Errorf("%s: got incorrect format %+v", test.name, w.Format())
+		}
+		if !reflect.DeepEqual(w.Data(), test.expected) {
+			t.Errorf("%s: expected samples %v, got %v", test.name,
+				test.expected, w.Data())
+		}
+		if w.NumSamples() != len(test.expected)/test.channels {
+			t.Errorf("%s: got incorrect number of samples %d", test.name,
+				w.NumSamples())
+		}
+	}
+}
+
+func TestWavReaderErrors(t *testing.T) {
+	pcmFmt := fmtChunk(WaveFormatPCM, 1, 16000, 16)
+	data := testChunk{id: "data", body: make([]byte, 4)}
+	badGUID := extensibleFmtChunk(WaveFormatPCM, 1, 16000, 16, 16)
+	badGUID.body[len(badGUID.body)-1] ^= 0xff
+	truncated := buildWav("RIFF", pcmFmt, testChunk{id: "LIST",
+		body: make([]byte, 4), size: 100})
+
+	tests := []struct {
+		name string
+		file []byte
+		// 用 errors.Is 检查的错误，为 nil 时只检查 chunkID
+		expected error
+		// 不为空时错误必须是这个块的 ChunkError
+		chunkID string
+	}{
+		{"empty", nil, ErrNotRiff, ""},
+		{"not riff", []byte("RIFX\x00\x00\x00\x00WAVE"), ErrNotRiff, ""},
+		{"short header", buildWav("RIFF")[:8], ErrNotRiff, ""},
+		{"avi", append([]byte("RIFF\x04\x00\x00\x00"), "AVI "...),
+			ErrNotWave, ""},
+		{"missing fmt", buildWav("RIFF", data), ErrMissingFmt, ""},
+		{"missing data", buildWav("RIFF", pcmFmt), ErrMissingData, ""},
+		{"truncated chunk", truncated, io.ErrUnexpectedEOF, "LIST"},
+		{"short fmt", buildWav("RIFF", testChunk{id: "fmt ",
+			body: make([]byte, 14)}, data), nil, "fmt "},
+		{"zero channels", buildWav("RIFF", fmtChunk(WaveFormatPCM, 0, 16000,
+			16), data), nil, "fmt "},
+		{"unknown subformat", buildWav("RIFF", badGUID, data), nil, "fmt "},
+		{"rf64 without ds64", buildWav("RF64", pcmFmt, testChunk{id: "data",
+			body: make([]byte, 4), size: math.MaxUint32}), ErrMissingDs64,
+			"data"},
+	}
+	for _, test := range tests {
+		var w WavReader
+		err := w.Load(bytes.NewReader(test.file))
+		if err == nil {
+			t.Errorf("%s: didn't get an error", test.name)
+			continue
+		}
+		if (test.expected != nil) && !errors.Is(err, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, err)
+		}
+		var chunkError *ChunkError
+		if (test.chunkID != "") && (!errors.As(err, &chunkError) ||
+			(chunkError.ID != test.chunkID)) {
+			t.Errorf("%s: expected an error in chunk %q, got %v", test.name,
+				test.chunkID, err)
+		}
+	}
+}
+
+func TestUnsupportedWavFormat(t *testing.T) {
+	// IMA ADPCM
+	file := buildWav("RIFF", fmtChunk(0x0011, 1, 16000, 4),
+		testChunk{id: "data", body: make([]byte, 4)})
+	var w WavReader
+	valid := buildWav("RIFF", fmtChunk(WaveFormatPCM, 1, 16000, 16),
+		testChunk{id: "data", body: littleEndianBytes([]int16{16384})})
+	err := w.Load(bytes.NewReader(valid))
+	if err != nil {
+		t.Fatalf("Error loading a valid file: %s", err)
+	}
+	err = w.Load(bytes.NewReader(file))
+	var formatError *FormatError
+	if !errors.As(err, &formatError) || (formatError.FormatTag != 0x0011) {
+		t.Errorf("Expected a FormatError, got %v", err)
+	}
+	// 失败时保留之前读取的文件
+	if (w.Format().FormatTag != WaveFormatPCM) ||
+		!reflect.DeepEqual(w.Data(), []float32{0.5}) {
+		t.Errorf("The reader was modified by a failed load: %+v, %v",
+			w.Format(), w.Data())
+	}
+}
+
+// errTestSeek 是 failingSeeker 返回的错误
+var errTestSeek = errors.New("test seek error")
+
+// failingSeeker 在定位到 failAt 或之后的位置时返回错误
+type failingSeeker struct {
+	*bytes.Reader
+	failAt int64
+}
+
+func (s *failingSeeker) Seek(offset int64, whence int) (int64, error) {
+	if (whence == io.SeekStart) && (offset >= s.failAt) {
+		return 0, errTestSeek
+	}
+	return s.Reader.Seek(offset, whence)
+}
+
+func TestWavReaderSeekError(t *testing.T) {
+	file := buildWav("RIFF", testChunk{id: "LIST", body: make([]byte, 10)},
+		fmtChunk(WaveFormatPCM, 1, 16000, 16),
+		testChunk{id: "data", body: make([]byte, 4)})
+	var w WavReader
+	err := w.Load(&failingSeeker{Reader: bytes.NewReader(file), failAt: 20})
+	if !errors.Is(err, errTestSeek) {
+		t.Errorf("Expected the seek error to be returned, got %v", err)
+	}
+}
+
+func TestDecodeSamples(t *testing.T) {
+	tests := []struct {
+		name      string
+		formatTag uint16
+		bits      int
+		raw       []byte
+		expected  []float32
+	}{
+		{"pcm8", WaveFormatPCM, 8, []byte{0, 64, 128, 255},
+			[]float32{-1, -0.5, 0, 127.0 / 128}},
+		{"pcm16", WaveFormatPCM, 16,
+			littleEndianBytes([]int16{-32768, 0, 16384, 32767}),
+			[]float32{-1, 0, 0.5, 32767.0 / 32768}},
+		{"pcm24", WaveFormatPCM, 24, []byte{0x00, 0x00, 0x80, 0x00, 0x00,
+			0x40, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f},
+			[]float32{-1, 0.5, -1.0 / (1 << 23), 8388607.0 / (1 << 23)}},
+		{"pcm32", WaveFormatPCM, 32,
+			littleEndianBytes([]int32{math.MinInt32, 1 << 30, 0}),
+			[]float32{-1, 0.5, 0}},
+		{"float32", WaveFormatIEEEFloat, 32,
+			littleEndianBytes([]float32{0.25, -1}), []float32{0.25, -1}},
+		{"float64", WaveFormatIEEEFloat, 64,
+			littleEndianBytes([]float64{-0.75, 1}), []float32{-0.75, 1}},
+		{"a-law", WaveFormatALaw, 8, []byte{0xd5, 0x55, 0xaa, 0x2a},
+			[]float32{8.0 / 32768, -8.0 / 32768, 32256.0 / 32768,
+				-32256.0 / 32768}},
+		{"mu-law", WaveFormatMuLaw, 8, []byte{0xff, 0x7f, 0x80, 0x00},
+			[]float32{0, 0, 32124.0 / 32768, -32124.0 / 32768}},
+	}
+	for _, test := range tests {
+		format := &WavFormat{FormatTag: test.formatTag,
+			BitsPerSample: test.bits}
+		decode, err := newSampleDecoder(format)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		actual := make([]float32, len(test.expected))
+		decode(test.raw, actual)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected,
+				actual)
+		}
+	}
+
+	for _, format := range []WavFormat{
+		{FormatTag: WaveFormatPCM, BitsPerSample: 12},
+		{FormatTag: WaveFormatIEEEFloat, BitsPerSample: 16},
+		{FormatTag: WaveFormatMuLaw, BitsPerSample: 16},
+	} {
+		decode, err := newSampleDecoder(&format)
+		if decode != nil {
+			t.Errorf("Expected no decoder for %+v", format)
+		}
+		var formatError *FormatError
+		if !errors.As(err, &formatError) {
+			t.Errorf("Expected a FormatError for %+v, got %v", format, err)
+		}
+	}
+}
+
+func TestLoadExtensible24Bit(t *testing.T) {
+	// 20 位有效数据保存在 24 位的容器中，高位对齐
+	file := buildWav("RIFF", extensibleFmtChunk(WaveFormatPCM, 1, 16000, 24,
+		20), testChunk{id: "data", body: []byte{0x00, 0x00, 0xc0}})
+	var w WavReader
+	err := w.Load(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("Error loading the file: %s", err)
+	}
+	if (w.Format().ValidBitsPerSample != 20) ||
+		!reflect.DeepEqual(w.Data(), []float32{-0.5}) {
+		t.Errorf("Got format %+v and samples %v", w.Format(), w.Data())
+	}
+}